@@ -7076,6 +7076,28 @@ Builtin_call_expression::do_flatten(Gogo*, Named_object*,
 	}
       break;
 
+    case BUILTIN_PRINT:
+    case BUILTIN_PRINTLN:
+      // Evaluate the arguments before taking the print lock, so that
+      // a function call in an argument can not block or print while
+      // the lock is held.
+      if (this->args() != NULL)
+	{
+	  for (Expression_list::iterator pa = this->args()->begin();
+	       pa != this->args()->end();
+	       ++pa)
+	    {
+	      if (!(*pa)->is_variable() && !(*pa)->is_constant())
+		{
+		  Temporary_statement* temp =
+		    Statement::make_temporary(NULL, *pa, loc);
+		  inserter->insert(temp);
+		  *pa = Expression::make_temporary_reference(temp, loc);
+		}
+	    }
+	}
+      break;
+
     case BUILTIN_LEN:
       Expression_list::iterator pa = this->args()->begin();
       if (!(*pa)->is_variable()
@@ -8414,6 +8436,17 @@ Builtin_call_expression::do_get_backend(Translate_context* context)
         if (print_stmts == NULL)
           return context->backend()->boolean_constant_expression(false);
 
+        // Hold the print lock across the whole statement so that
+        // concurrent prints are not interleaved.
+        Expression* print_lock =
+            Runtime::make_call(Runtime::PRINT_LOCK, location, 0);
+        Expression* print_unlock =
+            Runtime::make_call(Runtime::PRINT_UNLOCK, location, 0);
+        print_stmts = Expression::make_compound(print_lock, print_stmts,
+                                                location);
+        print_stmts = Expression::make_compound(print_stmts, print_unlock,
+                                                location);
+
         return print_stmts->get_backend(context);
       }
 
@@ -10342,15 +10375,6 @@ Array_index_expression::do_get_backend(Translate_context* context)
   if (length == NULL)
     length = cap_arg;
 
-  int code = (array_type->length() != NULL
-	      ? (this->end_ == NULL
-		 ? RUNTIME_ERROR_ARRAY_INDEX_OUT_OF_BOUNDS
-		 : RUNTIME_ERROR_ARRAY_SLICE_OUT_OF_BOUNDS)
-	      : (this->end_ == NULL
-		 ? RUNTIME_ERROR_SLICE_INDEX_OUT_OF_BOUNDS
-		 : RUNTIME_ERROR_SLICE_SLICE_OUT_OF_BOUNDS));
-  Bexpression* crash = gogo->runtime_error(code, loc)->get_backend(context);
-
   if (this->start_->type()->integer_type() == NULL
       && !Type::are_convertible(int_type, this->start_->type(), NULL))
     {
@@ -10358,6 +10382,22 @@ Array_index_expression::do_get_backend(Translate_context* context)
       return context->backend()->error_expression();
     }
 
+  // For a simple index, report the index and the length when it is
+  // out of range.
+  Bexpression* crash;
+  if (this->end_ == NULL)
+    {
+      Expression* len = array_type->get_length(gogo, this->array_);
+      crash = gogo->index_error(this->start_, len, loc)->get_backend(context);
+    }
+  else
+    {
+      int code = (array_type->length() != NULL
+		  ? RUNTIME_ERROR_ARRAY_SLICE_OUT_OF_BOUNDS
+		  : RUNTIME_ERROR_SLICE_SLICE_OUT_OF_BOUNDS);
+      crash = gogo->runtime_error(code, loc)->get_backend(context);
+    }
+
   Bexpression* bad_index =
     Expression::check_bounds(this->start_, loc)->get_backend(context);
 
@@ -10661,13 +10701,7 @@ String_index_expression::do_get_backend(Translate_context* context)
 
   Expression* bad_index = Expression::check_bounds(this->start_, loc);
 
-  int code = (this->end_ == NULL
-	      ? RUNTIME_ERROR_STRING_INDEX_OUT_OF_BOUNDS
-	      : RUNTIME_ERROR_STRING_SLICE_OUT_OF_BOUNDS);
-
   Gogo* gogo = context->gogo();
-  Bexpression* crash = gogo->runtime_error(code, loc)->get_backend(context);
-
   Type* int_type = Type::lookup_integer_type("int");
 
   // It is possible that an error occurred earlier because the start index
@@ -10688,6 +10722,8 @@ String_index_expression::do_get_backend(Translate_context* context)
     {
       Expression* length =
           Expression::make_string_info(this->string_, STRING_INFO_LENGTH, loc);
+      Bexpression* crash =
+	gogo->index_error(this->start_, length, loc)->get_backend(context);
 
       Expression* start_too_large =
           Expression::make_binary(OPERATOR_GE, start, length, loc);
@@ -10720,6 +10756,9 @@ String_index_expression::do_get_backend(Translate_context* context)
       end = Expression::make_cast(int_type, this->end_, loc);
     }
 
+  Bexpression* crash =
+    gogo->runtime_error(RUNTIME_ERROR_STRING_SLICE_OUT_OF_BOUNDS,
+			loc)->get_backend(context);
   Expression* strslice = Runtime::make_call(Runtime::STRING_SLICE, loc, 3,
                                             string_arg, start, end);
   Bexpression* bstrslice = strslice->get_backend(context);
@@ -4248,6 +4248,19 @@ Gogo::runtime_error(int code, Location location)
   return Runtime::make_call(Runtime::RUNTIME_ERROR, location, 1, code_expr);
 }
 
+// Build a call to the runtime function that panics for an index out
+// of range.  The panic message reports the index and the length.
+
+Expression*
+Gogo::index_error(Expression* index, Expression* length, Location location)
+{
+  Integer_type* itype = index->type()->integer_type();
+  Runtime::Function code = (itype != NULL && itype->is_unsigned()
+			    ? Runtime::PANIC_INDEX_U
+			    : Runtime::PANIC_INDEX);
+  return Runtime::make_call(code, location, 2, index, length);
+}
+
 // Look for named types to see whether we need to create an interface
 // method table.
 
@@ -714,6 +714,11 @@ class Gogo
   Expression*
   runtime_error(int code, Location);
 
+  // Build a call to the runtime function that panics because INDEX
+  // is out of range for LENGTH.
+  Expression*
+  index_error(Expression* index, Expression* length, Location);
+
   // Build required interface method tables.
   void
   build_interface_method_tables();
@@ -0,0 +1,34 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "unsafe"
+
+// User arenas, for request-scoped allocation.
+//
+// An arena is a region of memory from which objects are allocated by
+// bumping a pointer and which is freed all at once with arenaFree.
+// The garbage collector does not track the objects in an arena: it
+// scans the used part of each live arena for pointers into the heap,
+// so heap objects referenced from an arena stay alive, but it never
+// frees anything in an arena. After arenaFree, any pointer into the
+// arena is dangling, so the arena must not be reachable from the heap
+// by then.
+
+// Functions in malloc.goc.
+func arenaNew(size uintptr) unsafe.Pointer
+func arenaAlloc(a unsafe.Pointer, size, align uintptr) unsafe.Pointer
+func arenaFree(a unsafe.Pointer)
+func arenaCount() int
+
+// arenaNewObject allocates zeroed memory for a value of type t in the
+// arena a. It returns nil if the arena does not have room.
+func arenaNewObject(a unsafe.Pointer, t *_type) unsafe.Pointer {
+	align := uintptr(t.align)
+	if align == 0 {
+		align = 1
+	}
+	return arenaAlloc(a, t.size, align)
+}
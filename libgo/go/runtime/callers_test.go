@@ -81,3 +81,299 @@ func TestCallersPanic(t *testing.T) {
 	}()
 	f1(true)
 }
+
+func TestPanicCallers(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("did not panic")
+		}
+		pcs := make([]uintptr, 20)
+		pcs = pcs[:runtime.PanicCallers(pcs)]
+		if len(pcs) == 0 {
+			t.Fatal("no panic stack recorded")
+		}
+		frame, _ := runtime.CallersFrames(pcs).Next()
+		if frame.Function != "runtime_test.f3" {
+			t.Errorf("panic stack starts at %q, want runtime_test.f3", frame.Function)
+		}
+		testCallers(t, pcs, true)
+	}()
+	f1(true)
+}
+
+func TestPanicCallersNotPanicking(t *testing.T) {
+	pcs := make([]uintptr, 20)
+	if n := runtime.PanicCallers(pcs); n != 0 {
+		t.Errorf("PanicCallers returned %d when not panicking", n)
+	}
+}
+
+func BenchmarkPanicRecover(b *testing.B) {
+	for _, callers := range []bool{false, true} {
+		name := "recover"
+		if callers {
+			name = "callers"
+		}
+		b.Run(name, func(b *testing.B) {
+			pcs := make([]uintptr, 32)
+			for i := 0; i < b.N; i++ {
+				func() {
+					defer func() {
+						recover()
+						if callers {
+							runtime.PanicCallers(pcs)
+						}
+					}()
+					f1(true)
+				}()
+			}
+		})
+	}
+}
+
+// TestCallersSkip checks that Callers skips the same frames as
+// Caller: Callers(skip+1) starts at the frame Caller(skip) reports.
+func TestCallersSkip(t *testing.T) {
+	callersSkip(t, 3)
+}
+
+func callersSkip(t *testing.T, depth int) {
+	if depth > 0 {
+		callersSkip(t, depth-1)
+		// Keep this from being a tail call.
+		fpStackDepth = depth
+		return
+	}
+	pcs := make([]uintptr, 1)
+	for skip := 1; skip < 5; skip++ {
+		_, file, line, ok := runtime.Caller(skip)
+		if !ok {
+			t.Fatalf("Caller(%d) failed", skip)
+		}
+		if runtime.Callers(skip+1, pcs) != 1 {
+			t.Fatalf("Callers(%d) returned no PC", skip+1)
+		}
+		frame, _ := runtime.CallersFrames(pcs).Next()
+		if frame.File != file || frame.Line != line {
+			t.Errorf("Callers(%d) starts at %s:%d, Caller(%d) is %s:%d", skip+1, frame.File, frame.Line, skip, file, line)
+		}
+	}
+}
+
+var memProfSink []byte
+
+func memProfAlloc() {
+	memProfSink = make([]byte, 1<<10)
+}
+
+// TestCallersFramesMemProfile checks that CallersFrames on a memory
+// profile stack, which repeats a PC for each call inlined there,
+// returns each frame once.
+func TestCallersFramesMemProfile(t *testing.T) {
+	defer func(old int) { runtime.MemProfileRate = old }(runtime.MemProfileRate)
+	runtime.MemProfileRate = 1
+	memProfAlloc()
+	runtime.GC()
+
+	var p []runtime.MemProfileRecord
+	n, ok := runtime.MemProfile(nil, true)
+	for !ok {
+		p = make([]runtime.MemProfileRecord, n+50)
+		n, ok = runtime.MemProfile(p, true)
+	}
+	found := false
+	for _, r := range p[:n] {
+		var stk []runtime.Frame
+		frames := runtime.CallersFrames(r.Stack())
+		for {
+			frame, more := frames.Next()
+			stk = append(stk, frame)
+			if !more {
+				break
+			}
+		}
+		mine := false
+		for _, frame := range stk {
+			mine = mine || frame.Function == "runtime_test.memProfAlloc"
+		}
+		if !mine {
+			continue
+		}
+		found = true
+		for i := 1; i < len(stk); i++ {
+			if stk[i] == stk[i-1] {
+				t.Errorf("frame %s:%d repeated in memory profile stack", stk[i].Function, stk[i].Line)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("no memory profile record for memProfAlloc")
+	}
+}
+
+var fpStackDepth int
+
+// fpStack calls runtime.CallersFP depth calls down.
+func fpStack(depth int, pcs []uintptr) int {
+	if depth > 0 {
+		n := fpStack(depth-1, pcs)
+		// Keep this from being a tail call.
+		fpStackDepth = depth
+		return n
+	}
+	return runtime.CallersFP(0, pcs)
+}
+
+func TestCallersFP(t *testing.T) {
+	defer runtime.SetFPUnwind(runtime.SetFPUnwind(true))
+	if !runtime.SetFPUnwind(true) {
+		t.Skip("frame pointer unwinding not configured")
+	}
+	// Unwind from the same call site both ways, so that every frame
+	// should match.
+	var pcs [2][]uintptr
+	for i, fp := range []bool{true, false} {
+		runtime.SetFPUnwind(fp)
+		pcs[i] = make([]uintptr, 64)
+		pcs[i] = pcs[i][:fpStack(5, pcs[i])]
+	}
+	fp, dwarf := pcs[0], pcs[1]
+
+	var fpFrames, dwarfFrames []runtime.Frame
+	for _, f := range []struct {
+		pcs    []uintptr
+		frames *[]runtime.Frame
+	}{
+		{fp, &fpFrames},
+		{dwarf, &dwarfFrames},
+	} {
+		frames := runtime.CallersFrames(f.pcs)
+		for {
+			frame, more := frames.Next()
+			if strings.HasPrefix(frame.Function, "runtime_test.") {
+				*f.frames = append(*f.frames, frame)
+			}
+			if !more {
+				break
+			}
+		}
+	}
+
+	if len(dwarfFrames) < 7 {
+		t.Fatalf("found %d frames in package runtime_test, want at least 7", len(dwarfFrames))
+	}
+	if len(fpFrames) != len(dwarfFrames) {
+		t.Fatalf("frame pointer unwinding found %d frames, default found %d", len(fpFrames), len(dwarfFrames))
+	}
+	for i := range fpFrames {
+		f, d := fpFrames[i], dwarfFrames[i]
+		if f.PC != d.PC || f.Function != d.Function || f.Line != d.Line {
+			t.Errorf("frame %d: frame pointer unwinding found %s:%d (%#x), default found %s:%d (%#x)", i, f.Function, f.Line, f.PC, d.Function, d.Line, d.PC)
+		}
+	}
+}
+
+func BenchmarkCallersFP(b *testing.B) {
+	defer runtime.SetFPUnwind(runtime.SetFPUnwind(true))
+	for _, fp := range []bool{true, false} {
+		name := "dwarf"
+		if fp {
+			name = "fp"
+		}
+		b.Run(name, func(b *testing.B) {
+			runtime.SetFPUnwind(fp)
+			pcs := make([]uintptr, 32)
+			for i := 0; i < b.N; i++ {
+				fpStack(20, pcs)
+			}
+		})
+	}
+}
+
+type frameInfo struct {
+	function, file string
+	line           int
+	entry          uintptr
+}
+
+func symbolize(pcs []uintptr) []frameInfo {
+	var ret []frameInfo
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		ret = append(ret, frameInfo{frame.Function, frame.File, frame.Line, frame.Entry})
+		if !more {
+			break
+		}
+	}
+	return ret
+}
+
+func TestFileLineCache(t *testing.T) {
+	defer runtime.SetFileLineCache(runtime.SetFileLineCache(false))
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:fpStack(10, pcs)]
+	uncached := symbolize(pcs)
+
+	runtime.SetFileLineCache(true)
+	// The first pass fills the cache, the second uses it.
+	for pass := 0; pass < 2; pass++ {
+		cached := symbolize(pcs)
+		if len(cached) != len(uncached) {
+			t.Fatalf("pass %d: %d frames with the cache, %d without", pass, len(cached), len(uncached))
+		}
+		for i := range cached {
+			if cached[i] != uncached[i] {
+				t.Errorf("pass %d: frame %d is %+v with the cache, %+v without", pass, i, cached[i], uncached[i])
+			}
+		}
+	}
+}
+
+func BenchmarkSymbolize(b *testing.B) {
+	defer runtime.SetFileLineCache(runtime.SetFileLineCache(true))
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:fpStack(20, pcs)]
+	for _, cache := range []bool{true, false} {
+		name := "uncached"
+		if cache {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			runtime.SetFileLineCache(cache)
+			for i := 0; i < b.N; i++ {
+				symbolize(pcs)
+			}
+		})
+	}
+}
+
+// callersStack calls runtime.Callers depth calls down.
+func callersStack(depth int, pcs []uintptr) int {
+	if depth > 0 {
+		n := callersStack(depth-1, pcs)
+		// Keep this from being a tail call.
+		fpStackDepth = depth
+		return n
+	}
+	return runtime.Callers(0, pcs)
+}
+
+func BenchmarkCallers(b *testing.B) {
+	pcs := make([]uintptr, 32)
+	b.Run("pcs", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			callersStack(20, pcs)
+		}
+	})
+	b.Run("symbolized", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			frames := runtime.CallersFrames(pcs[:callersStack(20, pcs)])
+			for {
+				if _, more := frames.Next(); !more {
+					break
+				}
+			}
+		}
+	})
+}
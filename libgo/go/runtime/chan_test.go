@@ -6,12 +6,46 @@ package runtime_test
 
 import (
 	"runtime"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// TestChanSudogReuse checks that the sudogs of goroutines blocked on
+// channels are returned to the pool and reused.
+func TestChanSudogReuse(t *testing.T) {
+	// A GC drops the central sudog cache.
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	const N = 2000
+	round := func() {
+		c := make(chan int)
+		done := make(chan bool)
+		for i := 0; i < N; i++ {
+			go func() {
+				<-c
+				done <- true
+			}()
+		}
+		for i := 0; i < N; i++ {
+			c <- i
+		}
+		for i := 0; i < N; i++ {
+			<-done
+		}
+	}
+	round()
+	n0 := runtime.SudogAllocs()
+	for i := 0; i < 5; i++ {
+		round()
+	}
+	if n := runtime.SudogAllocs() - n0; n > N/2 {
+		t.Errorf("%d more sudogs allocated after the first round of %d blocked goroutines, want few", n, N)
+	}
+}
+
 func TestChan(t *testing.T) {
 	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
 	N := 200
@@ -591,6 +625,174 @@ func TestSelectDuplicateChannel(t *testing.T) {
 	c <- 8 // wake up B.  This operation used to fail because c.recvq was corrupted (it tries to wake up an already running G instead of B)
 }
 
+func TestSelectOverlapping(t *testing.T) {
+	// Many goroutines select on overlapping sets of shared
+	// channels, listed in different orders and sometimes more
+	// than once. Every value sent must be received exactly once,
+	// and losing cases must be dequeued cleanly.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	const (
+		nchan = 4
+		nrecv = 8
+		nsend = 8
+	)
+	n := 2000
+	if testing.Short() {
+		n = 200
+	}
+	var chans [nchan]chan int
+	for i := range chans {
+		chans[i] = make(chan int)
+	}
+	var sum, received int64
+	var wg sync.WaitGroup
+	for r := 0; r < nrecv; r++ {
+		a, b, c := chans[r%nchan], chans[(r+1)%nchan], chans[(r+2)%nchan]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var v int
+				var ok bool
+				select {
+				case v, ok = <-a:
+				case v, ok = <-b:
+				case v, ok = <-c:
+				case v, ok = <-a:
+				}
+				if !ok {
+					return
+				}
+				atomic.AddInt64(&sum, int64(v))
+				atomic.AddInt64(&received, 1)
+			}
+		}()
+	}
+	var swg sync.WaitGroup
+	for s := 0; s < nsend; s++ {
+		a, b := chans[s%nchan], chans[(s+nchan-1)%nchan]
+		swg.Add(1)
+		go func() {
+			defer swg.Done()
+			for i := 1; i <= n; i++ {
+				select {
+				case a <- i:
+				case b <- i:
+				}
+			}
+		}()
+	}
+	// A select with a default case must never park, but may
+	// still win values from waiting senders.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			select {
+			case v, ok := <-chans[0]:
+				if ok {
+					atomic.AddInt64(&sum, int64(v))
+					atomic.AddInt64(&received, 1)
+				}
+			default:
+			}
+		}
+	}()
+	swg.Wait()
+	for _, c := range chans {
+		close(c)
+	}
+	wg.Wait()
+	if want := int64(nsend * n); received != want {
+		t.Errorf("received %d values, want %d", received, want)
+	}
+	if want := int64(nsend * n * (n + 1) / 2); sum != want {
+		t.Errorf("sum of received values %d, want %d", sum, want)
+	}
+}
+
+func TestSelectLockOrder(t *testing.T) {
+	// Two goroutines select over the same pair of channels,
+	// listed in opposite orders. Locking the channels in the
+	// order they are listed would deadlock.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	n := 100000
+	if testing.Short() {
+		n = 10000
+	}
+	a := make(chan int)
+	b := make(chan int)
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < n; i++ {
+			select {
+			case a <- i:
+			case <-b:
+			}
+		}
+		done <- true
+	}()
+	go func() {
+		for i := 0; i < n; i++ {
+			select {
+			case b <- i:
+			case <-a:
+			}
+		}
+		done <- true
+	}()
+	// Drain whatever the two goroutines send to each other
+	// past the point where one of them has finished.
+	for finished := 0; finished < 2; {
+		select {
+		case <-done:
+			finished++
+		case <-a:
+		case <-b:
+		}
+	}
+}
+
+func TestSendCloseRace(t *testing.T) {
+	// Race a close against a sender that is blocked, or about
+	// to block, on the channel. The sender must always panic
+	// with "send on closed channel", and must never deposit its
+	// value in the closed channel.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	n := 10000
+	if testing.Short() {
+		n = 1000
+	}
+	for i := 0; i < n; i++ {
+		for _, size := range []int{0, 1} {
+			c := make(chan int, size)
+			if size > 0 {
+				c <- 1 // fill the buffer so the sender blocks
+			}
+			res := make(chan interface{})
+			go func() {
+				defer func() {
+					res <- recover()
+				}()
+				c <- 2
+			}()
+			if i%2 == 0 {
+				runtime.Gosched()
+			}
+			close(c)
+			err, ok := (<-res).(runtime.Error)
+			if !ok || err.Error() != "runtime error: send on closed channel" {
+				t.Fatalf("iteration %d, size %d: sender recovered %v, want send on closed channel panic", i, size, err)
+			}
+			for v := range c {
+				if v != 1 {
+					t.Fatalf("iteration %d, size %d: received %d from closed channel", i, size, v)
+				}
+			}
+		}
+	}
+}
+
 var selectSink interface{}
 
 func TestSelectStackAdjust(t *testing.T) {
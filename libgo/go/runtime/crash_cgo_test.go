@@ -10,8 +10,10 @@ import (
 	"bytes"
 	"fmt"
 	"internal/testenv"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -197,6 +199,56 @@ func TestCgoCheckBytes(t *testing.T) {
 	t.Errorf("cgo check too slow: got %v, expected at most %v", tot2/tries, (tot1/tries)*20)
 }
 
+func TestCgoCheckNested(t *testing.T) {
+	got := runTestProg(t, "testprogcgo", "CgoCheckNested")
+	want := "panic: runtime error: cgo argument has Go pointer to Go pointer\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("expected %q in output, got:\n%s", want, got)
+	}
+
+	// With cgocheck=0 the call must be let through.
+	exe, err := buildTestProg(t, "testprogcgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testEnv(exec.Command(exe, "CgoCheckNested"))
+	cmd.Env = append(cmd.Env, "GODEBUG=cgocheck=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "OK\n" {
+		t.Errorf("with cgocheck=0: expected %q, got %q (%v)", "OK\n", out, err)
+	}
+}
+
+func TestCgoCheckFlat(t *testing.T) {
+	got := runTestProg(t, "testprogcgo", "CgoCheckFlat")
+	want := "OK\n"
+	if got != want {
+		t.Errorf("expected %q got %v", want, got)
+	}
+}
+
+func TestCgoSigmask(t *testing.T) {
+	if runtime.GOOS == "plan9" || runtime.GOOS == "windows" {
+		t.Skipf("no pthreads on %s", runtime.GOOS)
+	}
+	got := runTestProg(t, "testprogcgo", "CgoSigmask")
+	want := "OK\n"
+	if got != want {
+		t.Errorf("expected %q got %v", want, got)
+	}
+}
+
+func TestCgoSigstack(t *testing.T) {
+	if runtime.GOOS == "plan9" || runtime.GOOS == "windows" {
+		t.Skipf("no pthreads on %s", runtime.GOOS)
+	}
+	got := runTestProg(t, "testprogcgo", "CgoSigstack")
+	want := "OK\n"
+	if got != want {
+		t.Errorf("expected %q got %v", want, got)
+	}
+}
+
 func TestCgoPanicDeadlock(t *testing.T) {
 	// test issue 14432
 	got := runTestProg(t, "testprogcgo", "CgoPanicDeadlock")
@@ -226,6 +278,34 @@ func TestCgoCrashTraceback(t *testing.T) {
 	}
 }
 
+func TestCgoCrashCFrames(t *testing.T) {
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "386") {
+		t.Skipf("signal PC not available on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	got := runTestProg(t, "testprogcgo", "CrashInCFunction")
+	for _, want := range []string{
+		"\ncrashInCFunction\n",
+		"\ncallCrashInCFunction\n",
+		"\nmain.CrashInCFunction\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestCgoCrashContext(t *testing.T) {
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "386") {
+		t.Skipf("signal PC not available on %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	// The traceback function reports the context of the call from
+	// C to Go as a PC.
+	got := runTestProg(t, "testprogcgo", "CrashInCFunctionContext")
+	if want := "pc=0x1234\n"; !strings.Contains(got, want) {
+		t.Errorf("output does not contain %q:\n%s", want, got)
+	}
+}
+
 func TestCgoTracebackContext(t *testing.T) {
 	got := runTestProg(t, "testprogcgo", "TracebackContext")
 	want := "OK\n"
@@ -288,3 +368,85 @@ func TestCgoPprofPIE(t *testing.T) {
 func TestCgoPprofThread(t *testing.T) {
 	testCgoPprof(t, "", "CgoPprofThread")
 }
+
+// Check that the first call into a c-archive finds the runtime's
+// background goroutines already running.
+func TestCArchiveInit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("not yet supported on %s", runtime.GOOS)
+	}
+	testenv.MustHaveGoBuild(t)
+
+	dir, err := ioutil.TempDir("", "go-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := filepath.Join(dir, "libtestcarchive.a")
+	cmd := testEnv(exec.Command("go", "build", "-buildmode=c-archive", "-o", lib))
+	cmd.Dir = "testdata/testcarchive"
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building c-archive: %v\n%s", err, out)
+	}
+
+	// The C program has to be linked with the Go runtime library,
+	// which the gccgo driver does for us.
+	cc := "gcc"
+	if runtime.Compiler == "gccgo" {
+		cc = "gccgo"
+	}
+	exe := filepath.Join(dir, "testcarchive.exe")
+	src, err := filepath.Abs("testdata/testcarchive-c/main.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd = testEnv(exec.Command(cc, "-I", dir, "-o", exe, src, lib, "-lpthread"))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("linking: %v\n%s", err, out)
+	}
+
+	cmd = testEnv(exec.Command(exe))
+	cmd.Env = append(cmd.Env, "GOTRACEBACK=system")
+	got, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, got)
+	}
+	if want := "OK\n"; string(got) != want {
+		t.Errorf("expected %q, but got:\n%s", want, got)
+	}
+}
+
+func TestCgoCallStack(t *testing.T) {
+	switch runtime.GOOS {
+	case "plan9", "windows":
+		t.Skipf("no unistd.h on %s", runtime.GOOS)
+	}
+	exe, err := buildTestProg(t, "testprogcgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := testEnv(exec.Command(exe, "CgoCallStack")).CombinedOutput()
+	for _, want := range []string{
+		"[cgo call]",
+		"Go stack unavailable",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("without cgocallers, output does not contain %q:\n%s", want, got)
+		}
+	}
+
+	cmd := testEnv(exec.Command(exe, "CgoCallStack"))
+	cmd.Env = append(cmd.Env, "GODEBUG=cgocallers=1")
+	got, _ = cmd.CombinedOutput()
+	for _, want := range []string{
+		"[cgo call]\n",
+		"\nmain.cgoCallStack2\n",
+		"\nmain.cgoCallStack1\n",
+	} {
+		if !bytes.Contains(got, []byte(want)) {
+			t.Errorf("with cgocallers=1, output does not contain %q:\n%s", want, got)
+		}
+	}
+}
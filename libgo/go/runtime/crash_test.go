@@ -204,6 +204,41 @@ func TestSimpleDeadlock(t *testing.T) {
 	testDeadlock(t, "SimpleDeadlock")
 }
 
+func TestBlockedDeadlock(t *testing.T) {
+	testDeadlock(t, "BlockedDeadlock")
+}
+
+func TestMainReturnWhileSleeping(t *testing.T) {
+	start := time.Now()
+	output := runTestProg(t, "testprog", "MainReturnWhileSleeping")
+	if output != "OK\n" {
+		t.Fatalf("expected %q, got %q", "OK\n", output)
+	}
+	if d := time.Since(start); d > time.Minute {
+		t.Errorf("program took %v to exit", d)
+	}
+}
+
+func TestExitFromGoroutine(t *testing.T) {
+	exe, err := buildTestProg(t, "testprog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := testEnv(exec.Command(exe, "ExitFromGoroutine")).CombinedOutput()
+	if string(out) != "exiting\n" {
+		t.Errorf("expected %q, got %q", "exiting\n", out)
+	}
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		t.Fatalf("expected exit status 7, got %v", err)
+	}
+	if ws, ok := ee.Sys().(interface {
+		ExitStatus() int
+	}); ok && ws.ExitStatus() != 7 {
+		t.Errorf("expected exit status 7, got %d", ws.ExitStatus())
+	}
+}
+
 func TestInitDeadlock(t *testing.T) {
 	testDeadlock(t, "InitDeadlock")
 }
@@ -216,6 +251,14 @@ func TestLockedDeadlock2(t *testing.T) {
 	testDeadlock(t, "LockedDeadlock2")
 }
 
+func TestLockedIdleNoDeadlock(t *testing.T) {
+	output := runTestProg(t, "testprog", "LockedIdleNoDeadlock")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
 func TestGoexitDeadlock(t *testing.T) {
 	output := runTestProg(t, "testprog", "GoexitDeadlock")
 	want := "no goroutines (main called runtime.Goexit) - deadlock!"
@@ -232,12 +275,41 @@ func TestStackOverflow(t *testing.T) {
 	}
 }
 
+func TestStackOverflowGuard(t *testing.T) {
+	if runtime.UsingSplitStack() {
+		t.Skip("split stacks grow instead of overflowing")
+	}
+	output := runTestProg(t, "testprog", "StackOverflowGuard")
+	want := "runtime: goroutine stack exceeds "
+	if !strings.HasPrefix(output, want) {
+		t.Fatalf("output does not start with %q:\n%s", want, output)
+	}
+	if !strings.Contains(output, "-byte limit\nfatal error: stack overflow") {
+		t.Fatalf("output does not report a stack overflow:\n%s", output)
+	}
+	if strings.Contains(output, "unexpected fault address") {
+		t.Fatalf("stack overflow reported as a fault:\n%s", output)
+	}
+}
+
 func TestThreadExhaustion(t *testing.T) {
 	output := runTestProg(t, "testprog", "ThreadExhaustion")
-	want := "runtime: program exceeds 10-thread limit\nfatal error: thread exhaustion"
+	want := "runtime: program exceeds 10-thread limit\n"
 	if !strings.HasPrefix(output, want) {
 		t.Fatalf("output does not start with %q:\n%s", want, output)
 	}
+	want = "fatal error: thread exhaustion"
+	if !strings.Contains(output, want) {
+		t.Fatalf("output does not contain %q:\n%s", want, output)
+	}
+}
+
+func TestThreadExhaustionCreateStack(t *testing.T) {
+	output := runTestProg(t, "testprog", "ThreadExhaustionCreateStack")
+	re := regexp.MustCompile(`(?s)^runtime: program exceeds 10-thread limit\nruntime: \d+ threads created at:\n.*main\.ThreadExhaustionCreateStack.*fatal error: thread exhaustion`)
+	if !re.MatchString(output) {
+		t.Fatalf("output does not match %q:\n%s", re, output)
+	}
 }
 
 func TestRecursivePanic(t *testing.T) {
@@ -252,6 +324,107 @@ panic: again
 
 }
 
+func TestPanicValuePrinting(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"PanicError", "panic: error value\n"},
+		{"PanicStringer", "panic: stringer value\n"},
+		{"PanicErrorPanics", "fatal error: panic while printing panic value\n"},
+	}
+	for _, tt := range tests {
+		output := runTestProg(t, "testprog", tt.name)
+		if !strings.HasPrefix(output, tt.want) {
+			t.Errorf("%s: output does not start with %q:\n%s", tt.name, tt.want, output)
+		}
+	}
+}
+
+func TestDoublePanic(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"DoublePanic", "panic: A\n\tpanic: B\n"},
+		{"RecoveredDoublePanic", "panic: A [recovered]\n\tpanic: B\n"},
+	}
+	for _, tt := range tests {
+		output := runTestProg(t, "testprog", tt.name)
+		if !strings.HasPrefix(output, tt.want) {
+			t.Errorf("%s: output does not start with %q:\n%s", tt.name, tt.want, output)
+		}
+	}
+}
+
+func TestConcurrentPanics(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		output := runTestProg(t, "testprog", "ConcurrentPanics")
+		if !strings.HasPrefix(output, "panic: concurrent panic ") {
+			t.Fatalf("output does not start with a panic message:\n%s", output)
+		}
+		if n := strings.Count(output, "panic: concurrent panic "); n != 1 {
+			t.Fatalf("got %d crash reports, want 1:\n%s", n, output)
+		}
+	}
+}
+
+func TestNilDerefSignalLine(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("nil dereference may not raise SIGSEGV on %s", runtime.GOOS)
+	}
+	output := runTestProg(t, "testprog", "NilDeref")
+	re := regexp.MustCompile(`\[signal SIGSEGV: segmentation violation code=0x[0-9a-f]+ addr=0x0 pc=(0x[0-9a-f]+)\]`)
+	m := re.FindStringSubmatch(output)
+	if m == nil {
+		t.Fatalf("output does not contain the signal line:\n%s", output)
+	}
+	if (runtime.GOARCH == "amd64" || runtime.GOARCH == "386") && m[1] == "0x0" {
+		t.Errorf("signal line does not report the faulting pc:\n%s", output)
+	}
+}
+
+func testConcurrentMap(t *testing.T, name, want string) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("skipping: concurrent map accesses need more than one CPU to overlap")
+	}
+	output := runTestProg(t, "testprog", name)
+	if strings.HasPrefix(output, "no conflict detected") {
+		t.Skip("skipping: accesses never overlapped")
+	}
+	if !strings.HasPrefix(output, want) {
+		t.Fatalf("output does not start with %q:\n%s", want, output)
+	}
+}
+
+func TestConcurrentMapWrites(t *testing.T) {
+	testConcurrentMap(t, "ConcurrentMapWrites", "fatal error: concurrent map writes")
+}
+
+func TestConcurrentMapReadWrite(t *testing.T) {
+	testConcurrentMap(t, "ConcurrentMapReadWrite", "fatal error: concurrent map read and map write")
+}
+
+func TestConcurrentMapIterateWrite(t *testing.T) {
+	testConcurrentMap(t, "ConcurrentMapIterateWrite", "fatal error: concurrent map iteration and map write")
+}
+
+func TestPrintInterleave(t *testing.T) {
+	output := runTestProg(t, "testprog", "PrintInterleave")
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	if len(lines) != 8*200 {
+		t.Errorf("got %d lines, want %d", len(lines), 8*200)
+	}
+	for _, line := range lines {
+		var i, j int
+		var b bool
+		n, err := fmt.Sscanf(line, "start %d line %d %t end", &i, &j, &b)
+		if n != 3 || err != nil || line != fmt.Sprintf("start %d line %d true end", i, j) {
+			t.Fatalf("garbled line %q in output:\n%s", line, output)
+		}
+	}
+}
+
 func TestGoexitCrash(t *testing.T) {
 	output := runTestProg(t, "testprog", "GoexitExit")
 	want := "no goroutines (main called runtime.Goexit) - deadlock!"
@@ -260,6 +433,18 @@ func TestGoexitCrash(t *testing.T) {
 	}
 }
 
+func TestGoexitMainRunning(t *testing.T) {
+	output := runTestProg(t, "testprog", "GoexitMainRunning")
+	want := "main deferred\ngoroutine done\n"
+	if output != want {
+		t.Fatalf("output:\n%s\n\nwanted:\n%s", output, want)
+	}
+}
+
+func TestGoexitMainBlocked(t *testing.T) {
+	testDeadlock(t, "GoexitMainBlocked")
+}
+
 func TestGoexitDefer(t *testing.T) {
 	c := make(chan struct{})
 	go func() {
@@ -276,6 +461,58 @@ func TestGoexitDefer(t *testing.T) {
 	<-c
 }
 
+// Check that a panic in a goroutine started by the runtime is
+// reported as a fatal runtime error.
+func TestSystemGoroutinePanic(t *testing.T) {
+	if os.Getenv("GO_SYSTEM_PANIC") == "1" {
+		runtime.StartSystemPanic()
+		select {}
+	}
+
+	cmd := testEnv(exec.Command(os.Args[0], "-test.run=TestSystemGoroutinePanic"))
+	cmd.Env = append(cmd.Env, "GO_SYSTEM_PANIC=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("system goroutine panic did not crash:\n%s", out)
+	}
+	for _, want := range []string{
+		"panic: runtime error: injected system goroutine fault",
+		"fatal error: runtime: system goroutine panic",
+		"systempanic",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+// Check that a panic in a finalizer, which runs on the finalizer
+// goroutine, is reported as an ordinary panic.
+func TestFinalizerPanic(t *testing.T) {
+	if os.Getenv("GO_FINALIZER_PANIC") == "1" {
+		runtime.SetFinalizer(new([64]byte), func(*[64]byte) {
+			panic("finalizer panic")
+		})
+		for {
+			runtime.GC()
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	cmd := testEnv(exec.Command(os.Args[0], "-test.run=TestFinalizerPanic"))
+	cmd.Env = append(cmd.Env, "GO_FINALIZER_PANIC=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("finalizer panic did not crash:\n%s", out)
+	}
+	if !bytes.Contains(out, []byte("panic: finalizer panic")) {
+		t.Fatalf("output does not contain the panic:\n%s", out)
+	}
+	if bytes.Contains(out, []byte("system goroutine panic")) {
+		t.Fatalf("finalizer panic reported as a system goroutine panic:\n%s", out)
+	}
+}
+
 func TestGoNil(t *testing.T) {
 	output := runTestProg(t, "testprog", "GoNil")
 	want := "go of nil func value"
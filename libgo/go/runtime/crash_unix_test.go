@@ -12,11 +12,14 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"syscall"
 	"testing"
+	"time"
 )
 
 // sigquit is the signal to send to kill a hanging testdata program.
@@ -169,6 +172,80 @@ func TestSignalExitStatus(t *testing.T) {
 	}
 }
 
+func TestSignalPipe(t *testing.T) {
+	output := runTestProg(t, "testprog", "SignalPipe")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestSignalPipeStdout(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	exe, err := buildTestProg(t, "testprog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	defer w.Close()
+
+	var stderr bytes.Buffer
+	cmd := testEnv(exec.Command(exe, "SignalPipeStdout"))
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err == nil {
+		t.Errorf("test program succeeded unexpectedly:\n%s", stderr.Bytes())
+	} else if ee, ok := err.(*exec.ExitError); !ok {
+		t.Errorf("error (%v) has type %T; expected exec.ExitError", err, err)
+	} else if ws, ok := ee.Sys().(syscall.WaitStatus); !ok {
+		t.Errorf("error.Sys (%v) has type %T; expected syscall.WaitStatus", ee.Sys(), ee.Sys())
+	} else if !ws.Signaled() || ws.Signal() != syscall.SIGPIPE {
+		t.Errorf("got %v; expected SIGPIPE\n%s", ee, stderr.Bytes())
+	}
+}
+
+func TestSignalGoroutine(t *testing.T) {
+	output := runTestProg(t, "testprog", "SignalGoroutine")
+	re := regexp.MustCompile(`\[signal [^\]]*\] goroutine (\d+) running\n`)
+	m := re.FindStringSubmatch(output)
+	if m == nil {
+		t.Fatalf("output does not name the goroutine that caught the signal:\n%s", output)
+	}
+	if m[1] == "1" {
+		t.Errorf("signal reported on main goroutine, want the faulting goroutine:\n%s", output)
+	}
+	want := "goroutine " + m[1] + " [running]:\n"
+	if !strings.Contains(output, want) {
+		t.Errorf("output does not contain %q:\n%s", want, output)
+	}
+}
+
+func TestSyscallStall(t *testing.T) {
+	testenv.MustHaveGoBuild(t)
+	exe, err := buildTestProg(t, "testprog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testEnv(exec.Command(exe, "SyscallStall"))
+	cmd.Env = append(cmd.Env, "GODEBUG=syscallstall=20")
+	got, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, got)
+	}
+	want := regexp.MustCompile(`runtime: goroutine \d+ in system call for \d+ms on M\d+`)
+	if !want.Match(got) {
+		t.Errorf("output does not match %q:\n%s", want, got)
+	}
+	if !bytes.HasSuffix(got, []byte("OK\n")) {
+		t.Errorf("output does not end in OK:\n%s", got)
+	}
+}
+
 func TestSignalIgnoreSIGTRAP(t *testing.T) {
 	output := runTestProg(t, "testprognet", "SignalIgnoreSIGTRAP")
 	want := "OK\n"
@@ -176,3 +253,26 @@ func TestSignalIgnoreSIGTRAP(t *testing.T) {
 		t.Fatalf("want %s, got %s\n", want, output)
 	}
 }
+
+func TestSigblock(t *testing.T) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	defer signal.Stop(c)
+
+	old := runtime.Sigblock(uint32(syscall.SIGUSR1))
+	inner := runtime.Sigblock(uint32(syscall.SIGUSR1))
+	runtime.Raise(uint32(syscall.SIGUSR1))
+	runtime.Sigunblock(inner)
+	select {
+	case <-c:
+		t.Fatal("SIGUSR1 delivered while blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	runtime.Sigunblock(old)
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGUSR1 not delivered after unblocking")
+	}
+}
@@ -10,6 +10,8 @@ func Breakpoint()
 // LockOSThread wires the calling goroutine to its current operating system thread.
 // Until the calling goroutine exits or calls UnlockOSThread, it will always
 // execute in that thread, and no other goroutine can.
+// If the calling goroutine exits without unlocking the thread,
+// the thread will be terminated.
 func LockOSThread()
 
 // UnlockOSThread unwires the calling goroutine from its fixed operating system thread.
@@ -34,8 +36,26 @@ func NumCPU() int
 func NumCgoCall() int64
 
 // NumGoroutine returns the number of goroutines that currently exist.
+// The count leaves out the runtime's own goroutines: the heap
+// scavenger, the forced-GC helper it starts, the background sweeper,
+// the timer goroutine and the finalizer goroutine. These are also left
+// out of stack dumps unless GOTRACEBACK is set to system or higher.
 func NumGoroutine() int
 
+// Goid returns the ID of the calling goroutine. IDs are positive and
+// are never reused during a run of the process, so they may be used to
+// correlate log output or to key goroutine-local data. Code running
+// on a thread's scheduling goroutine (g0), which ordinary Go code
+// never does, sees 0.
+func Goid() int64
+
+// setMaxGoroutines sets a soft limit on the number of goroutines
+// started from now on that may be runnable at once, and returns the
+// previous limit. Past the limit, a go statement's goroutine waits to
+// start until enough of the limited goroutines have blocked or
+// exited. n == 0 removes the limit. Implemented in proc.c.
+func setMaxGoroutines(n int) int
+
 // MemProfileRate controls the fraction of memory allocations
 // that are recorded and reported in the memory profile.
 // The profiler aims to sample an average of
@@ -125,6 +145,32 @@ func ThreadCreateProfile(p []StackRecord) (n int, ok bool)
 // of calling GoroutineProfile directly.
 func GoroutineProfile(p []StackRecord) (n int, ok bool)
 
+// A GoroutineWait describes a blocked goroutine.
+type GoroutineWait struct {
+	Goid         int64  // goroutine ID
+	Reason       string // what it is waiting for, such as "chan receive"
+	BlockedNanos int64  // how long it has been blocked
+}
+
+// GoroutineWaits returns a record for each goroutine that is blocked
+// on a channel, select, lock or other runtime wait. The goroutines
+// keep running while the records are taken, so the records need not
+// be consistent with each other.
+// The runtime's own goroutines are left out unless system is true.
+func GoroutineWaits(system bool) []GoroutineWait {
+	n := NumGoroutine()
+	for {
+		p := make([]GoroutineWait, n+10)
+		var ok bool
+		n, ok = goroutineWaits(p, system)
+		if ok {
+			return p[:n]
+		}
+	}
+}
+
+func goroutineWaits(p []GoroutineWait, system bool) (n int, ok bool)
+
 // CPUProfile returns the next chunk of binary CPU profiling stack trace data,
 // blocking until data is available.  If profiling is turned off and all the profile
 // data accumulated while it was on has been returned, CPUProfile returns nil.
@@ -169,12 +215,67 @@ type BlockProfileRecord struct {
 // of calling BlockProfile directly.
 func BlockProfile(p []BlockProfileRecord) (n int, ok bool)
 
+// SetMutexProfileFraction controls the fraction of mutex contention events
+// that are reported in the mutex profile. On average 1/rate events are
+// reported. The previous rate is returned.
+//
+// To turn off profiling entirely, pass rate 0.
+// To just read the current rate, pass rate -1.
+// (For n>1 the details of sampling may change.)
+func SetMutexProfileFraction(rate int) int
+
+// MutexProfile returns n, the number of records in the current mutex profile.
+// If len(p) >= n, MutexProfile copies the profile into p and returns n, true.
+// Otherwise, MutexProfile does not change p, and returns n, false.
+//
+// Each record charges the time goroutines spent waiting for a
+// sync.Mutex to the call stack that unlocked it. Time threads spent
+// sleeping on one of the runtime's internal locks is charged to the
+// call stack that then acquired the lock.
+func MutexProfile(p []BlockProfileRecord) (n int, ok bool)
+
 // Stack formats a stack trace of the calling goroutine into buf
 // and returns the number of bytes written to buf.
 // If all is true, Stack formats stack traces of all other goroutines
 // into buf after the trace for the current goroutine.
 func Stack(buf []byte, all bool) int
 
+// GoroutineFrames copies the call stack of the goroutine with the
+// given ID into frames, innermost call first, and returns the number
+// of frames copied. The frames are the ones that Stack prints for
+// that goroutine. more reports whether frames were left out, either
+// because frames is too short or because the stack is deeper than the
+// traceback limit of 100 frames.
+//
+// ok is false if there is no such goroutine or its stack is not
+// available because it is running or in a system call. That includes
+// the calling goroutine; use Callers and CallersFrames for it.
+func GoroutineFrames(goid int64, frames []Frame) (n int, more bool, ok bool) {
+	locs := make([]location, _TracebackMaxFrames)
+	c, elided, ok := goroutineFrames(goid, locs)
+	if !ok {
+		return 0, false, false
+	}
+	for n < len(frames) && n < c {
+		loc := &locs[n]
+		frame := Frame{
+			PC:       loc.pc - 1,
+			Function: loc.function,
+			File:     loc.filename,
+			Line:     loc.lineno,
+		}
+		if f := FuncForPC(frame.PC); f != nil && f.Name() == frame.Function {
+			frame.Func = f
+			frame.Entry = f.Entry()
+		}
+		frames[n] = frame
+		n++
+	}
+	return n, elided || n < c, true
+}
+
+func goroutineFrames(goid int64, locs []location) (n int, elided bool, ok bool)
+
 // Get field tracking information.  Only fields with a tag go:"track"
 // are tracked.  This function will add every such field that is
 // referenced to the map.  The keys in the map will be
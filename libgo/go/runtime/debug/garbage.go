@@ -145,6 +145,9 @@ func SetMaxThreads(threads int) int {
 // that the runtime trigger only a panic, not a crash.
 // SetPanicOnFault applies only to the current goroutine.
 // It returns the previous setting.
+//
+// The runtime.Error value of such a panic has an Addr() uintptr
+// method that reports the faulting address.
 func SetPanicOnFault(enabled bool) bool
 
 // WriteHeapDump writes a description of the heap and the objects in
@@ -95,6 +95,48 @@ func TestFreeOSMemory(t *testing.T) {
 	}
 }
 
+var largeBufs [][]byte
+
+func allocLargeBufs(n, size int) {
+	largeBufs = make([][]byte, n)
+	for i := range largeBufs {
+		largeBufs[i] = make([]byte, size)
+	}
+}
+
+// Large objects get spans of their own, so once they are collected
+// their pages can go straight back to the operating system, and the
+// freed spans coalesce into space for a single object of their
+// combined size.
+func TestFreeOSMemoryLarge(t *testing.T) {
+	if runtime.GOARCH == "arm64" || runtime.GOARCH == "ppc64" || runtime.GOARCH == "ppc64le" || runtime.GOARCH == "mips64" || runtime.GOARCH == "mips64le" ||
+		runtime.GOOS == "nacl" {
+		t.Skip("issue 9993; scavenger temporarily disabled on systems with physical pages larger than logical pages")
+	}
+	const (
+		n    = 8
+		size = 4 << 20
+	)
+	var ms1, ms2, ms3 runtime.MemStats
+
+	allocLargeBufs(n, size)
+	runtime.ReadMemStats(&ms1)
+	largeBufs = nil
+	runtime.GC()
+	FreeOSMemory()
+	runtime.ReadMemStats(&ms2)
+	if released := int64(ms2.HeapReleased) - int64(ms1.HeapReleased); released < n*size/2 {
+		t.Errorf("released %d bytes after freeing %d large buffers of %d bytes", released, n, size)
+	}
+
+	allocLargeBufs(1, n*size)
+	runtime.ReadMemStats(&ms3)
+	largeBufs = nil
+	if grew := int64(ms3.HeapSys) - int64(ms2.HeapSys); grew > n*size/2 {
+		t.Errorf("heap grew by %d bytes to allocate %d bytes that were just freed", grew, n*size)
+	}
+}
+
 func TestSetGCPercent(t *testing.T) {
 	// Test that the variable is being set and returned correctly.
 	// Assume the percentage itself is implemented fine during GC,
@@ -118,6 +118,28 @@ func NewErrorCString(s uintptr, ret *interface{}) {
 	*ret = errorCString{s}
 }
 
+// An errorAddressString represents a runtime error described by a
+// single string and the address of the memory access that caused it.
+type errorAddressString struct {
+	msg  string  // error message
+	addr uintptr // memory address where the error occurred
+}
+
+func (e errorAddressString) RuntimeError() {}
+
+func (e errorAddressString) Error() string {
+	return "runtime error: " + e.msg
+}
+
+// Addr returns the memory address where a fault occurred.
+// The address provided is best-effort.
+// The veracity of the result may depend on the platform.
+// Errors providing this method will only be returned as
+// a result of using runtime/debug.SetPanicOnFault.
+func (e errorAddressString) Addr() uintptr {
+	return e.addr
+}
+
 // plainError represents a runtime error described a string without
 // the prefix "runtime error: " after invoking errorString.Error().
 // See Issue #14965.
@@ -129,6 +151,49 @@ func (e plainError) Error() string {
 	return string(e)
 }
 
+// A boundsError reports an index out of range. Unlike errorString
+// it records the index and the length, so that the message can
+// include them.
+type boundsError struct {
+	x        int64
+	isSigned bool // if false, x holds a uint64
+	y        int
+}
+
+func (e boundsError) RuntimeError() {}
+
+func (e boundsError) Error() string {
+	var x string
+	if e.isSigned {
+		x = itoa(e.x)
+	} else {
+		x = uitoa(uint64(e.x))
+	}
+	return "runtime error: index out of range [" + x + "] with length " + itoa(int64(e.y))
+}
+
+// uitoa returns the decimal representation of v.
+func uitoa(v uint64) string {
+	var buf [20]byte
+	i := len(buf)
+	for v >= 10 {
+		i--
+		buf[i] = byte(v%10 + '0')
+		v /= 10
+	}
+	i--
+	buf[i] = byte(v + '0')
+	return string(buf[i:])
+}
+
+// itoa returns the decimal representation of v.
+func itoa(v int64) string {
+	if v < 0 {
+		return "-" + uitoa(uint64(-v))
+	}
+	return uitoa(uint64(v))
+}
+
 type stringer interface {
 	String() string
 }
@@ -138,15 +203,16 @@ func typestring(interface{}) string
 // For calling from C.
 // Prints an argument passed to panic.
 // There's room for arbitrary complexity here, but we keep it
-// simple and handle just a few important cases: int, string, and Stringer.
+// simple and handle just a few important cases: int, string, error,
+// and Stringer.
 func Printany(i interface{}) {
 	switch v := i.(type) {
 	case nil:
 		print("nil")
-	case stringer:
-		print(v.String())
 	case error:
 		print(v.Error())
+	case stringer:
+		print(v.String())
 	case int:
 		print(v)
 	case string:
@@ -156,6 +222,29 @@ func Printany(i interface{}) {
 	}
 }
 
+// For calling from C.
+// Preprintpanics replaces each error or Stringer panic value on the
+// stack p with the string it formats to. This runs the methods before
+// the runtime starts reporting the panic, so that a method that
+// panics in turn is reported as such rather than as a panic during
+// panic.
+func Preprintpanics(p *_panic) {
+	defer func() {
+		if recover() != nil {
+			throw("panic while printing panic value")
+		}
+	}()
+	for p != nil {
+		switch v := p.arg.(type) {
+		case error:
+			p.arg = v.Error()
+		case stringer:
+			p.arg = v.String()
+		}
+		p = p.next
+	}
+}
+
 // called from generated code
 func panicwrap(pkg, typ, meth string) {
 	panic(plainError("value method " + pkg + "." + typ + "." + meth + " called using nil *" + typ + " pointer"))
@@ -6,6 +6,8 @@
 
 package runtime
 
+import "unsafe"
+
 //var Fadd64 = fadd64
 //var Fsub64 = fsub64
 //var Fmul64 = fmul64
@@ -23,6 +25,22 @@ var Entersyscall = entersyscall
 var Exitsyscall = exitsyscall
 var LockedOSThread = golockedOSThread
 
+type Note note
+
+func NoteClear(n *Note)       { noteclear((*note)(n)) }
+func NoteWakeup(n *Note)      { notewakeup((*note)(n)) }
+func NoteCancel(n *Note) bool { return notecancel((*note)(n)) }
+
+func NoteTsleepgResult(n *Note, ns int64) int32 {
+	return notetsleepgresult((*note)(n), ns)
+}
+
+const (
+	NoteResultTimeout  = noteTimeout
+	NoteResultWakeup   = noteWakeup
+	NoteResultCanceled = noteCanceled
+)
+
 // var Xadduintptr = xadduintptr
 
 // var FuncPC = funcPC
@@ -86,6 +104,220 @@ func GCMask(x interface{}) (ret []byte) {
 
 var HashLoad = &hashLoad
 
+// setDebugVar sets the GODEBUG variable *v to n, passes the new
+// settings to the C code, and returns the old value of *v.
+func setDebugVar(v *int32, n int32) int32 {
+	old := *v
+	*v = n
+	runtime_setdebug(&debug)
+	return old
+}
+
+func SetGfreeCap(n int32) int32 {
+	return setDebugVar(&debug.gfreecap, n)
+}
+
+func setfpunwind(bool) bool
+func callersfp(skip int, pc []uintptr) int
+
+var SetFPUnwind = setfpunwind
+var CallersFP = callersfp
+
+func setfilelinecache(bool) bool
+
+var SetFileLineCache = setfilelinecache
+
+func casgstatusgoid(goid int64, old, new uint32) bool
+
+var CasGStatusGoid = casgstatusgoid
+
+const (
+	GWaiting   = _Gwaiting
+	GCopystack = _Gcopystack
+)
+
+func testPersistentAlloc(size, align uintptr) *byte
+func setPersistentPerP(bool) bool
+
+var PersistentAlloc = testPersistentAlloc
+var SetPersistentPerP = setPersistentPerP
+
+// Values for schedTestValue; proc.c has the same numbers.
+const (
+	schedTestGoidgen = iota
+	schedTestTotaltime
+	schedTestNmspinning
+	schedTestNgsys
+	schedTestCountsystemg
+	schedTestSysmontick
+	schedTestSudogallocs
+	schedTestPgfreecntmax
+	schedTestCheckpidle
+	schedTestCheckmidle
+	schedTestCheckallm
+	schedTestSplitstack
+)
+
+func schedTestValue(what int32) int64
+
+func GoidGen() uint64       { return uint64(schedTestValue(schedTestGoidgen)) }
+func SchedTotalTime() int64 { return schedTestValue(schedTestTotaltime) }
+func NMSpinning() uint32    { return uint32(schedTestValue(schedTestNmspinning)) }
+func NGsys() uint32         { return uint32(schedTestValue(schedTestNgsys)) }
+func CountSystemG() int32   { return int32(schedTestValue(schedTestCountsystemg)) }
+func SysmonTick() uint32    { return uint32(schedTestValue(schedTestSysmontick)) }
+func SudogAllocs() uint32   { return uint32(schedTestValue(schedTestSudogallocs)) }
+func PGfreeCntMax() int32   { return int32(schedTestValue(schedTestPgfreecntmax)) }
+func CheckPidle() int32     { return int32(schedTestValue(schedTestCheckpidle)) }
+func CheckMidle() int32     { return int32(schedTestValue(schedTestCheckmidle)) }
+func CheckAllm() int32      { return int32(schedTestValue(schedTestCheckallm)) }
+func UsingSplitStack() bool { return schedTestValue(schedTestSplitstack) != 0 }
+
+func testStopTheWorld(counter *uint64, us int32, delta *int64) int32
+
+func StopTheWorld() int32 {
+	return testStopTheWorld(nil, 0, nil)
+}
+
+func GCWaiting(counter *uint64, us int32) int64 {
+	var delta int64
+	testStopTheWorld(counter, us, &delta)
+	return delta
+}
+
+const GoidCacheBatch = 16 // must match GoidCacheBatch in proc.c
+
+func testForEachP(counts []uint32)
+
+var ForEachP = testForEachP
+
+func testParkParam() unsafe.Pointer
+func testReadyParam(param unsafe.Pointer) bool
+func testGParam() unsafe.Pointer
+
+var ParkParam = testParkParam
+var ReadyParam = testReadyParam
+var GParam = testGParam
+
+func startsystempanic()
+
+var StartSystemPanic = startsystempanic
+
+func SetSchedFairness(n int32) int32 {
+	return setDebugVar(&debug.schedfairness, n)
+}
+
+func SetSchedCheck(n int32) int32 {
+	return setDebugVar(&debug.schedcheck, n)
+}
+
+var PanicCallers = panicCallers
+
+type MState struct {
+	Spinning   bool
+	Blocked    bool
+	InWB       bool
+	Mallocing  int32
+	Locks      int32
+	PreemptOff string
+}
+
+func GetMState() MState {
+	s := getMState()
+	return MState{
+		Spinning:   s.spinning,
+		Blocked:    s.blocked,
+		InWB:       s.inwb,
+		Mallocing:  s.mallocing,
+		Locks:      s.locks,
+		PreemptOff: s.preemptoff,
+	}
+}
+
+var PreemptPoint = checkpreempt
+var PreemptionPoint = preemptionPoint
+
+func setsysmonpreempt(bool) bool
+
+var SetSysmonPreempt = setsysmonpreempt
+
+func LockM()   { acquirem() }
+func UnlockM() { releasem(getg().m) }
+
+func RequestPreempt()      { getg().preempt = true }
+func PreemptPending() bool { return getg().preempt }
+
+// SetPreemptOff sets the calling M's preemptoff reason and returns
+// the old one.
+func SetPreemptOff(reason string) string {
+	mp := getg().m
+	old := mp.preemptoff
+	mp.preemptoff = reason
+	return old
+}
+
+// InMallocRegion calls f with the calling M marked as allocating and
+// holding a lock, as it is inside mallocgc, and returns the M state
+// seen on entry to the region.
+func InMallocRegion(f func()) MState {
+	mp := acquirem()
+	mp.mallocing++
+	old := mp.preemptoff
+	mp.preemptoff = "InMallocRegion"
+	s := GetMState()
+	f()
+	mp.preemptoff = old
+	mp.mallocing--
+	releasem(mp)
+	return s
+}
+
+func SetGCCheckmark(v int32) int32 {
+	return setDebugVar(&debug.gccheckmark, v)
+}
+
+func setcheckmarkdrop(unsafe.Pointer)
+
+var SetCheckmarkDrop = setcheckmarkdrop
+
+func gchelpersmarking() int
+
+var GCHelpersMarking = gchelpersmarking
+
+var SetMaxGoroutines = setMaxGoroutines
+
+func gcmaxovershoot() int
+
+var GCMaxOvershoot = gcmaxovershoot
+
+func SetGCPaceRate(n int32) int32 {
+	return setDebugVar(&debug.gcpacerate, n)
+}
+
+func SetRunSince(n int32) int32 {
+	return setDebugVar(&debug.runsince, n)
+}
+
+// ContendRuntimeLock has n goroutines take a runtime lock iters times
+// each, holding it long enough that the others sleep waiting for it.
+func ContendRuntimeLock(n, iters int) {
+	var l mutex
+	done := make(chan bool)
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := 0; j < iters; j++ {
+				lock(&l)
+				usleep(100)
+				unlock(&l)
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-done
+	}
+}
+
 // entry point for testing
 //func GostringW(w []uint16) (s string) {
 //	s = gostringw(&w[0])
@@ -209,6 +441,47 @@ var Close = closefd
 var Read = read
 var Write = write
 
+// Arena is a user arena, for testing.
+type Arena struct{ p unsafe.Pointer }
+
+func NewArena(size uintptr) *Arena { return &Arena{arenaNew(size)} }
+
+// New allocates a zeroed value of the dynamic type of x in the arena.
+func (a *Arena) New(x interface{}) unsafe.Pointer {
+	return arenaNewObject(a.p, efaceOf(&x)._type)
+}
+
+func (a *Arena) Free() { arenaFree(a.p) }
+
+var ArenaCount = arenaCount
+
+// SetPoisonFree turns GODEBUG=poisonfree on or off and returns the
+// previous setting.
+func SetPoisonFree(on bool) bool {
+	n := int32(0)
+	if on {
+		n = 1
+	}
+	return setDebugVar(&debug.poisonfree, n) != 0
+}
+
+var RawByteSlice = rawbyteslice
+
+// SetMCacheHot sets GODEBUG=mcachehot and returns the previous setting.
+func SetMCacheHot(n int32) int32 {
+	return setDebugVar(&debug.mcachehot, n)
+}
+
+// MapGrowState reports whether the map m is part way through growing
+// and, if so, how many of its old buckets have been evacuated.
+func MapGrowState(m interface{}) (growing bool, evacuated, oldbuckets uintptr) {
+	h := (*hmap)(efaceOf(&m).data)
+	if h == nil || h.oldbuckets == nil {
+		return false, 0, 0
+	}
+	return true, h.nevacuate, uintptr(1) << (h.B - 1)
+}
+
 func Envs() []string     { return envs }
 func SetEnvs(e []string) { envs = e }
 
@@ -0,0 +1,24 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+// Export guts for testing.
+
+package runtime
+
+type Sigset sigset
+
+func Sigblock(sig uint32) (old Sigset) {
+	sigblock(sig, (*sigset)(&old))
+	return old
+}
+
+func Sigunblock(old Sigset) {
+	sigunblock((*sigset)(&old))
+}
+
+func Raise(sig uint32) {
+	raise(int32(sig))
+}
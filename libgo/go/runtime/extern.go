@@ -27,6 +27,14 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	allocfreetrace: setting allocfreetrace=1 causes every allocation to be
 	profiled and a stack trace printed on each object's allocation and free.
 
+	asyncfinalizers: setting asyncfinalizers=1 runs each finalizer in its
+	own goroutine instead of one after another, so that a finalizer that
+	blocks or runs for a long time does not delay the others.
+
+	cgocallers: setting cgocallers=1 causes each cgo call to record
+	the Go stack that made it, so that a stack dump taken while the
+	call is in progress shows the Go frames leading to the call.
+
 	cgocheck: setting cgocheck=0 disables all checks for packages
 	using cgo to incorrectly pass Go pointers to non-Go code.
 	Setting cgocheck=1 (the default) enables relatively cheap
@@ -39,10 +47,17 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	never recycled.
 
 	gccheckmark: setting gccheckmark=1 enables verification of the
-	garbage collector's concurrent mark phase by performing a
-	second mark pass while the world is stopped.  If the second
-	pass finds a reachable object that was not found by concurrent
-	mark, the garbage collector will panic.
+	garbage collector's parallel mark phase by performing a second,
+	single-threaded mark pass while the world is still stopped.  If
+	the second pass finds a reachable object that was not found by
+	the first, the garbage collector will panic.
+
+	gcpacerate: by default the garbage collector starts each collection
+	early by the number of bytes the program is expected to allocate
+	between triggering the collection and stopping the world, as
+	measured from its allocation rate, so that bursts of allocation
+	overshoot the heap goal less. Setting gcpacerate=0 starts each
+	collection exactly at the heap goal.
 
 	gcpacertrace: setting gcpacertrace=1 causes the garbage collector to
 	print information about the internal state of the concurrent pacer.
@@ -97,6 +112,26 @@ It is a comma-separated list of name=val pairs setting these named variables:
 		released: #  MB released to the system
 		consumed: #  MB allocated from the system
 
+	gfreecap: setting gfreecap=X limits the number of exited goroutines
+	each P caches for reuse to X (default 64). When a P's cache reaches
+	the limit, half of it is moved to the global cache shared by all Ps,
+	whose goroutine stacks are freed at the next garbage collection.
+	Lowering the limit bounds the memory retained by idle Ps after a
+	burst of goroutine creation, at some cost in goroutine start-up time.
+
+	invalidptr: defaults to invalidptr=1, causing the garbage collector to
+	crash the program if an invalid pointer value (for example, 1) is found
+	in a pointer-typed location. Setting invalidptr=0 disables this check.
+	This should only be used as a temporary workaround to diagnose buggy code.
+	The real fix is to not store integers in pointer-typed locations.
+
+	mcachehot: setting mcachehot=X makes a size class hot for a P once the P
+	has fetched X spans of that class from the central free lists since the
+	last garbage collection (default 8). A hot size class fetches two spans
+	at a time, halving the searches of the central list, made while
+	holding its lock, for programs that allocate many objects of one
+	size. Setting mcachehot=0 disables this.
+
 	memprofilerate: setting memprofilerate=X will update the value of runtime.MemProfileRate.
 	When set to 0 memory profiling is disabled.  Refer to the description of
 	MemProfileRate for the default value.
@@ -105,19 +140,47 @@ It is a comma-separated list of name=val pairs setting these named variables:
 	runtime.MemProfileRate.  Refer to the description of this variable for how
 	it is used and its default value.
 
+	poisonfree: setting poisonfree=1 causes the garbage collector to fill
+	freed objects with the byte 0xdb, and to crash the program if a later
+	collection finds that pattern in a pointer-typed location of a live
+	object. This catches uses of memory that the program has let go of,
+	such as Go pointers kept only in C memory.
+
+	runsince: setting runsince=1 causes the scheduler to record when each
+	goroutine became runnable or started running, so that stack dumps show
+	how long running and runnable goroutines have been in that state.
+	Recording it reads the clock on every scheduling event.
+
 	sbrk: setting sbrk=1 replaces the memory allocator and garbage collector
 	with a trivial allocator that obtains memory from the operating system and
 	never reclaims any memory.
 
 	scavenge: scavenge=1 enables debugging mode of heap scavenger.
 
+	schedcheck: setting schedcheck=1 causes the scheduler to check, each time
+	it adds a goroutine to the global run queue or a free list, that the
+	goroutine is not already on such a list, and to crash if it is. This
+	catches corruption of the scheduler's lists where it happens, at a
+	small cost on each scheduling operation.
+
 	scheddetail: setting schedtrace=X and scheddetail=1 causes the scheduler to emit
 	detailed multiline info every X milliseconds, describing state of the scheduler,
 	processors, threads and goroutines.
 
+	schedfairness: setting schedfairness=X causes each P to take a goroutine
+	from the global run queue, ahead of its own local run queue, once every X
+	scheduling rounds (default 61). Lower values service goroutines in the
+	global run queue, such as those woken by the network poller, sooner, at
+	some cost in locality and throughput.
+
 	schedtrace: setting schedtrace=X causes the scheduler to emit a single line to standard
 	error every X milliseconds, summarizing the scheduler state.
 
+	syscallstall: setting syscallstall=X causes the scheduler to report, once per
+	system call, each goroutine that has been in a non-blocking system call for
+	more than X milliseconds. Such system calls hold on to their P until sysmon
+	retakes it, and may be better marked as blocking.
+
 The net and net/http packages also refer to debugging variables in GODEBUG.
 See the documentation for those packages for details.
 
@@ -250,7 +313,8 @@ func Callers(skip int, pc []uintptr) int
 //
 // A single goroutine runs all finalizers for a program, sequentially.
 // If a finalizer must run for a long time, it should do so by starting
-// a new goroutine.
+// a new goroutine. Setting GODEBUG=asyncfinalizers=1 instead runs each
+// finalizer in a goroutine of its own.
 func SetFinalizer(obj interface{}, finalizer interface{})
 
 // KeepAlive marks its argument as currently reachable.
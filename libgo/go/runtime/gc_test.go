@@ -5,10 +5,13 @@
 package runtime_test
 
 import (
+	"bytes"
 	"os"
+	"os/exec"
 	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
@@ -25,6 +28,152 @@ func TestGcSys(t *testing.T) {
 	}
 }
 
+func TestInvalidPointer(t *testing.T) {
+	got := runTestProg(t, "testprog", "InvalidPointer")
+	want := "fatal error: found bad pointer in Go heap"
+	if !strings.Contains(got, want) {
+		t.Fatalf("expected %q in output, got:\n%s", want, got)
+	}
+	if !strings.Contains(got, "in object of type main.invalidPointerHolder") {
+		t.Errorf("output does not name the containing type:\n%s", got)
+	}
+
+	// With invalidptr=0 the value must be ignored.
+	exe, err := buildTestProg(t, "testprog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testEnv(exec.Command(exe, "InvalidPointer"))
+	cmd.Env = append(cmd.Env, "GODEBUG=invalidptr=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "OK\n" {
+		t.Errorf("with invalidptr=0: expected %q, got %q (%v)", "OK\n", out, err)
+	}
+}
+
+func TestAsyncFinalizers(t *testing.T) {
+	exe, err := buildTestProg(t, "testprog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testEnv(exec.Command(exe, "AsyncFinalizers"))
+	cmd.Env = append(cmd.Env, "GODEBUG=asyncfinalizers=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil || string(out) != "OK\n" {
+		t.Errorf("expected %q, got %q (%v)", "OK\n", out, err)
+	}
+}
+
+func TestPoisonFree(t *testing.T) {
+	exe, err := buildTestProg(t, "testprog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := testEnv(exec.Command(exe, "PoisonFree"))
+	cmd.Env = append(cmd.Env, "GODEBUG=poisonfree=1")
+	out, _ := cmd.CombinedOutput()
+	want := "fatal error: use of freed object"
+	if !strings.Contains(string(out), want) {
+		t.Fatalf("expected %q in output, got:\n%s", want, out)
+	}
+
+	// Without poisonfree the pattern is just an odd pointer value.
+	got := runTestProg(t, "testprog", "PoisonFree")
+	if got != "OK\n" {
+		t.Errorf("without poisonfree: expected %q, got %q", "OK\n", got)
+	}
+}
+
+// Memory poisoned when it was freed must not show through in new
+// allocations.
+func TestPoisonFreeAllocZeroed(t *testing.T) {
+	defer runtime.SetPoisonFree(runtime.SetPoisonFree(true))
+
+	type small [4]*int
+	type large [1 << 14]*int
+	x := new(int)
+	for i := 0; i < 1000; i++ {
+		s := new(small)
+		for j := range s {
+			s[j] = x
+		}
+		if i%100 == 0 {
+			l := new(large)
+			for j := range l {
+				l[j] = x
+			}
+		}
+	}
+	runtime.GC()
+	runtime.GC()
+	for i := 0; i < 1000; i++ {
+		s := new(small)
+		for j, p := range s {
+			if p != nil {
+				t.Fatalf("new small object has %p at index %d", p, j)
+			}
+		}
+		if i%100 == 0 {
+			l := new(large)
+			for j, p := range l {
+				if p != nil {
+					t.Fatalf("new large object has %p at index %d", p, j)
+				}
+			}
+		}
+	}
+}
+
+var gcBurstSink [][]byte
+
+// gcBurstOvershoot allocates in a sudden burst from every P and
+// returns how far past the GC goal the heap got, as a percentage.
+func gcBurstOvershoot() int {
+	procs := runtime.GOMAXPROCS(0)
+	gcBurstSink = make([][]byte, procs)
+	runtime.GC()
+	runtime.GCMaxOvershoot()
+
+	done := make(chan bool)
+	for i := 0; i < procs; i++ {
+		go func(i int) {
+			for j := 0; j < 4096; j++ {
+				gcBurstSink[i] = make([]byte, 64<<10)
+			}
+			done <- true
+		}(i)
+	}
+	for i := 0; i < procs; i++ {
+		<-done
+	}
+	gcBurstSink = nil
+	return runtime.GCMaxOvershoot()
+}
+
+// A sudden burst of allocation that carries the heap past the GC goal
+// with the fixed trigger (gcpacerate=0) must carry it less far with
+// the trigger paced by the allocation rate.
+func TestGCBurstOvershoot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if runtime.GOMAXPROCS(0) < 2 {
+		t.Skip("the heap only overshoots when other Ps allocate while the world is stopping")
+	}
+	defer debug.SetGCPercent(debug.SetGCPercent(100))
+	defer runtime.SetGCPaceRate(runtime.SetGCPaceRate(0))
+
+	fixed := gcBurstOvershoot()
+	if fixed == 0 {
+		t.Skip("allocation burst did not overshoot the GC goal with the fixed trigger")
+	}
+	runtime.SetGCPaceRate(1)
+	paced := gcBurstOvershoot()
+	if paced >= fixed {
+		t.Errorf("heap reached %d%% over the GC goal with gcpacerate=1, %d%% with the fixed trigger", paced, fixed)
+	}
+}
+
 func TestGcDeepNesting(t *testing.T) {
 	type T [2][2][2][2][2][2][2][2][2][2]*int
 	a := new(T)
@@ -109,6 +258,159 @@ func TestGcRescan(t *testing.T) {
 	}
 }
 
+type checkmarkNode struct {
+	left, right *checkmarkNode
+	data        []byte
+}
+
+func buildCheckmarkTree(depth int) *checkmarkNode {
+	if depth == 0 {
+		return nil
+	}
+	return &checkmarkNode{
+		left:  buildCheckmarkTree(depth - 1),
+		right: buildCheckmarkTree(depth - 1),
+		data:  make([]byte, depth),
+	}
+}
+
+func checkCheckmarkTree(t *testing.T, n *checkmarkNode, depth int) {
+	if depth == 0 {
+		if n != nil {
+			t.Fatal("tree too deep")
+		}
+		return
+	}
+	if n == nil || len(n.data) != depth {
+		t.Fatal("tree corrupted")
+	}
+	checkCheckmarkTree(t, n.left, depth-1)
+	checkCheckmarkTree(t, n.right, depth-1)
+}
+
+func TestGCCheckmark(t *testing.T) {
+	defer runtime.SetGCCheckmark(runtime.SetGCCheckmark(1))
+
+	const depth = 12
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func() {
+			m := make(map[int]*checkmarkNode)
+			for j := 0; j < 10; j++ {
+				m[j] = buildCheckmarkTree(depth)
+				runtime.Gosched()
+			}
+			for _, n := range m {
+				checkCheckmarkTree(t, n, depth)
+			}
+			done <- true
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		runtime.GC()
+		<-done
+	}
+}
+
+// Check that the checkmark pass notices an object that the main
+// mark pass failed to mark.
+func TestGCCheckmarkMissedMark(t *testing.T) {
+	if os.Getenv("GO_GCCHECKMARK_DROP") == "1" {
+		runtime.SetGCCheckmark(1)
+		p := new([64]byte)
+		runtime.SetCheckmarkDrop(unsafe.Pointer(p))
+		runtime.GC()
+		runtime.KeepAlive(p)
+		return
+	}
+
+	cmd := testEnv(exec.Command(os.Args[0], "-test.run=TestGCCheckmarkMissedMark"))
+	cmd.Env = append(cmd.Env, "GO_GCCHECKMARK_DROP=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("missed mark was not detected:\n%s", out)
+	}
+	want := "gccheckmark: mark phase missed reachable objects"
+	if !bytes.Contains(out, []byte(want)) {
+		t.Fatalf("output does not contain %q:\n%s", want, out)
+	}
+}
+
+// Check that GC helper threads have stopped marking and left the
+// helper state by the time the collection returns, so that a
+// following stop the world never overlaps with a helper.
+func TestGCHelpersDoneBeforeStartTheWorld(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	const depth = 10
+	tree := buildCheckmarkTree(depth)
+	var ms runtime.MemStats
+	for i := 0; i < 50; i++ {
+		runtime.GC()
+		if n := runtime.GCHelpersMarking(); n != 0 {
+			t.Fatalf("after GC %d: %d GC helpers still marking", i, n)
+		}
+		// ReadMemStats stops the world again right away.
+		runtime.ReadMemStats(&ms)
+		if n := runtime.GCHelpersMarking(); n != 0 {
+			t.Fatalf("after ReadMemStats %d: %d GC helpers still marking", i, n)
+		}
+	}
+	checkCheckmarkTree(t, tree, depth)
+}
+
+var mallocReenterSink *[64]byte
+
+// Check that an allocation made while the M is already allocating
+// is caught rather than corrupting the allocator.
+func TestMallocReentrancy(t *testing.T) {
+	if os.Getenv("GO_MALLOC_REENTER") == "1" {
+		runtime.InMallocRegion(func() {
+			mallocReenterSink = new([64]byte)
+		})
+		return
+	}
+
+	cmd := testEnv(exec.Command(os.Args[0], "-test.run=TestMallocReentrancy"))
+	cmd.Env = append(cmd.Env, "GO_MALLOC_REENTER=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("reentrant allocation was not detected:\n%s", out)
+	}
+	for _, want := range []string{
+		"runtime: malloc of 64 bytes while already allocating",
+		"fatal error: malloc/free - deadlock",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Fatalf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGCBlockedWhileMallocing(t *testing.T) {
+	if s := runtime.GetMState(); s.Mallocing != 0 || s.Locks != 0 || s.PreemptOff != "" {
+		t.Fatalf("M state outside malloc region: %+v", s)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	s := runtime.InMallocRegion(func() {
+		// Must return without collecting.
+		runtime.GC()
+	})
+	runtime.ReadMemStats(&after)
+
+	if s.Mallocing == 0 || s.Locks == 0 || s.PreemptOff != "InMallocRegion" {
+		t.Errorf("M state inside malloc region: %+v", s)
+	}
+	if s.Spinning || s.Blocked || s.InWB {
+		t.Errorf("running M reports spinning, blocked or in write barrier: %+v", s)
+	}
+	if after.NumGC != before.NumGC {
+		t.Errorf("GC ran while M was mallocing: NumGC went from %d to %d", before.NumGC, after.NumGC)
+	}
+}
+
 func TestGcLastTime(t *testing.T) {
 	ms := new(runtime.MemStats)
 	t0 := time.Now().UnixNano()
@@ -129,6 +431,28 @@ func TestGcLastTime(t *testing.T) {
 	}
 }
 
+var cpuFractionSink []byte
+
+func TestGCCPUFraction(t *testing.T) {
+	if os.Getenv("GOGC") == "off" {
+		t.Skip("skipping test; GOGC=off in environment")
+	}
+	ms := new(runtime.MemStats)
+	runtime.ReadMemStats(ms)
+	numgc := ms.NumGC
+	for i := 0; i < 1e5; i++ {
+		cpuFractionSink = make([]byte, 1<<10)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(ms)
+	if ms.NumGC == numgc {
+		t.Fatalf("no GC ran")
+	}
+	if ms.GCCPUFraction <= 0 || ms.GCCPUFraction >= 1 {
+		t.Errorf("GCCPUFraction = %v, want in (0, 1)", ms.GCCPUFraction)
+	}
+}
+
 var hugeSink interface{}
 
 func TestHugeGCInfo(t *testing.T) {
@@ -495,3 +819,31 @@ func TestPageAccounting(t *testing.T) {
 }
 
 */
+
+var deferLargeSink byte
+
+func deferLarge(collected chan bool) {
+	big := new([1 << 20]byte)
+	runtime.SetFinalizer(big, func(*[1 << 20]byte) {
+		collected <- true
+	})
+	defer func() {
+		deferLargeSink = big[0]
+	}()
+}
+
+// Check that a defer returned to the pool does not keep its
+// closure's captured variables alive.
+func TestDeferPoolNoRetain(t *testing.T) {
+	collected := make(chan bool, 1)
+	deferLarge(collected)
+	for i := 0; i < 10; i++ {
+		runtime.GC()
+		select {
+		case <-collected:
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("object captured by a finished deferred call was not collected")
+}
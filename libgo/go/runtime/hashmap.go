@@ -703,6 +703,9 @@ func mapiternext(it *hiter) {
 		callerpc := getcallerpc(unsafe.Pointer( /* &it */ nil))
 		racereadpc(unsafe.Pointer(h), callerpc, funcPC(mapiternext))
 	}
+	if h.flags&hashWriting != 0 {
+		throw("concurrent map iteration and map write")
+	}
 	t := it.t
 	bucket := it.bucket
 	b := it.bptr
@@ -866,6 +869,15 @@ func hashGrow(t *maptype, h *hmap) {
 }
 
 func growWork(t *maptype, h *hmap, bucket uintptr) {
+	// Evacuation allocates overflow buckets, and an allocation is
+	// a point at which we might stop for the garbage collector.
+	// Keep this M from being descheduled until the buckets have
+	// been moved, so that the collector never observes a bucket
+	// that is only partly evacuated.
+	mp := acquirem()
+	preemptoff := mp.preemptoff
+	mp.preemptoff = "map evacuation"
+
 	noldbuckets := uintptr(1) << (h.B - 1)
 
 	// make sure we evacuate the oldbucket corresponding
@@ -876,6 +888,9 @@ func growWork(t *maptype, h *hmap, bucket uintptr) {
 	if h.oldbuckets != nil {
 		evacuate(t, h, h.nevacuate)
 	}
+
+	mp.preemptoff = preemptoff
+	releasem(mp)
 }
 
 func evacuate(t *maptype, h *hmap, oldbucket uintptr) {
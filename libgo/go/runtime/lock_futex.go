@@ -22,6 +22,8 @@ import (
 //go:linkname notesleep runtime.notesleep
 //go:linkname notetsleep runtime.notetsleep
 //go:linkname notetsleepg runtime.notetsleepg
+//go:linkname notetsleepgresult runtime.notetsleepgresult
+//go:linkname notecancel runtime.notecancel
 
 // This implementation depends on OS-specific implementations of
 //
@@ -44,6 +46,14 @@ const (
 	passive_spin    = 1
 )
 
+// Results of notetsleepgresult. These are also the values that
+// notewakeup and notecancel store in note.key.
+const (
+	noteTimeout  = 0
+	noteWakeup   = 1
+	noteCanceled = 2
+)
+
 // Possible lock states are mutex_unlocked, mutex_locked and mutex_sleeping.
 // mutex_sleeping means that there is presumably at least one sleeping thread.
 // Note that there can be spinning threads during all states - they do not
@@ -83,12 +93,14 @@ func lock(l *mutex) {
 	if ncpu > 1 {
 		spin = active_spin
 	}
+	var t0 int64 // when we first slept, for the mutex profile
+Loop:
 	for {
 		// Try for lock, spinning.
 		for i := 0; i < spin; i++ {
 			for l.key == mutex_unlocked {
 				if atomic.Cas(key32(&l.key), mutex_unlocked, wait) {
-					return
+					break Loop
 				}
 			}
 			procyield(active_spin_cnt)
@@ -98,7 +110,7 @@ func lock(l *mutex) {
 		for i := 0; i < passive_spin; i++ {
 			for l.key == mutex_unlocked {
 				if atomic.Cas(key32(&l.key), mutex_unlocked, wait) {
-					return
+					break Loop
 				}
 			}
 			osyield()
@@ -107,11 +119,17 @@ func lock(l *mutex) {
 		// Sleep.
 		v = atomic.Xchg(key32(&l.key), mutex_sleeping)
 		if v == mutex_unlocked {
-			return
+			break
 		}
 		wait = mutex_sleeping
+		if t0 == 0 {
+			t0 = cputicks()
+		}
 		futexsleep(key32(&l.key), mutex_sleeping, -1)
 	}
+	if t0 != 0 {
+		lockwait(t0)
+	}
 }
 
 func unlock(l *mutex) {
@@ -128,6 +146,9 @@ func unlock(l *mutex) {
 	if gp.m.locks < 0 {
 		throw("runtime·unlock: lock count")
 	}
+	if gp.m.locks == 0 && gp.m.lockwaitcycles != 0 {
+		lockwaitflush()
+	}
 	// if gp.m.locks == 0 && gp.preempt { // restore the preemption request in case we've cleared it in newstack
 	//	gp.stackguard0 = stackPreempt
 	// }
@@ -139,8 +160,10 @@ func noteclear(n *note) {
 }
 
 func notewakeup(n *note) {
-	old := atomic.Xchg(key32(&n.key), 1)
-	if old != 0 {
+	// A wakeup may race with notecancel; the sleeper has already
+	// been woken by the cancel, so only a second wakeup is an error.
+	old := atomic.Xchg(key32(&n.key), noteWakeup)
+	if old == noteWakeup {
 		print("notewakeup - double wakeup (", old, ")\n")
 		throw("notewakeup - double wakeup")
 	}
@@ -223,3 +246,25 @@ func notetsleepg(n *note, ns int64) bool {
 	exitsyscall(0)
 	return ok
 }
+
+// notecancel wakes up a notetsleepgresult on n early, making it
+// return noteCanceled. It reports whether it did so; it does nothing
+// if n has already been woken up or canceled. Unlike notewakeup it
+// may be called more than once, and may race with notewakeup.
+func notecancel(n *note) bool {
+	if !atomic.Cas(key32(&n.key), 0, noteCanceled) {
+		return false
+	}
+	futexwakeup(key32(&n.key), 1)
+	return true
+}
+
+// same as notetsleepg, but reports why it returned: noteTimeout,
+// noteWakeup or noteCanceled. If a wakeup and a cancel race, either
+// result may be returned, but n is only woken once.
+func notetsleepgresult(n *note, ns int64) int32 {
+	if !notetsleepg(n, ns) {
+		return noteTimeout
+	}
+	return int32(atomic.Load(key32(&n.key)))
+}
@@ -22,6 +22,8 @@ import (
 //go:linkname notesleep runtime.notesleep
 //go:linkname notetsleep runtime.notetsleep
 //go:linkname notetsleepg runtime.notetsleepg
+//go:linkname notetsleepgresult runtime.notetsleepgresult
+//go:linkname notecancel runtime.notecancel
 
 // This implementation depends on OS-specific implementations of
 //
@@ -37,13 +39,21 @@ import (
 //		Wake up mp, which is or will soon be sleeping on its semaphore.
 //
 const (
-	mutex_locked uintptr = 1
+	mutex_locked  uintptr = 1
+	note_canceled uintptr = 2 // notecancel was called
 
 	active_spin     = 4
 	active_spin_cnt = 30
 	passive_spin    = 1
 )
 
+// Results of notetsleepgresult.
+const (
+	noteTimeout  = 0
+	noteWakeup   = 1
+	noteCanceled = 2
+)
+
 func lock(l *mutex) {
 	gp := getg()
 	if gp.m.locks < 0 {
@@ -63,13 +73,14 @@ func lock(l *mutex) {
 	if ncpu > 1 {
 		spin = active_spin
 	}
+	var t0 int64 // when we first slept, for the mutex profile
 Loop:
 	for i := 0; ; i++ {
 		v := atomic.Loaduintptr(&l.key)
 		if v&mutex_locked == 0 {
 			// Unlocked. Try to lock.
 			if atomic.Casuintptr(&l.key, v, v|mutex_locked) {
-				return
+				break
 			}
 			i = 0
 		}
@@ -94,11 +105,17 @@ Loop:
 			}
 			if v&mutex_locked != 0 {
 				// Queued. Wait.
+				if t0 == 0 {
+					t0 = cputicks()
+				}
 				semasleep(-1)
 				i = 0
 			}
 		}
 	}
+	if t0 != 0 {
+		lockwait(t0)
+	}
 }
 
 //go:nowritebarrier
@@ -127,6 +144,9 @@ func unlock(l *mutex) {
 	if gp.m.locks < 0 {
 		throw("runtime·unlock: lock count")
 	}
+	if gp.m.locks == 0 && gp.m.lockwaitcycles != 0 {
+		lockwaitflush()
+	}
 	// if gp.m.locks == 0 && gp.preempt { // restore the preemption request in case we've cleared it in newstack
 	//	gp.stackguard0 = stackPreempt
 	// }
@@ -154,6 +174,8 @@ func notewakeup(n *note) {
 	case v == mutex_locked:
 		// Two notewakeups!  Not allowed.
 		throw("notewakeup - double wakeup")
+	case v == note_canceled:
+		// notecancel already woke up any waiter.
 	default:
 		// Must be the waiting m. Wake it up.
 		semawakeup((*m)(unsafe.Pointer(v)))
@@ -171,8 +193,8 @@ func notesleep(n *note) {
 
 	semacreate(gp.m)
 	if !atomic.Casuintptr(&n.key, 0, uintptr(unsafe.Pointer(gp.m))) {
-		// Must be locked (got wakeup).
-		if n.key != mutex_locked {
+		// Must be locked (got wakeup) or canceled.
+		if n.key != mutex_locked && n.key != note_canceled {
 			throw("notesleep - waitm out of sync")
 		}
 		return
@@ -193,8 +215,8 @@ func notetsleep_internal(n *note, ns int64, gp *g, deadline int64) bool {
 
 	// Register for wakeup on n->waitm.
 	if !atomic.Casuintptr(&n.key, 0, uintptr(unsafe.Pointer(gp.m))) {
-		// Must be locked (got wakeup).
-		if n.key != mutex_locked {
+		// Must be locked (got wakeup) or canceled.
+		if n.key != mutex_locked && n.key != note_canceled {
 			throw("notetsleep - waitm out of sync")
 		}
 		return true
@@ -238,8 +260,8 @@ func notetsleep_internal(n *note, ns int64, gp *g, deadline int64) bool {
 			if atomic.Casuintptr(&n.key, v, 0) {
 				return false
 			}
-		case mutex_locked:
-			// Wakeup happened so semaphore is available.
+		case mutex_locked, note_canceled:
+			// Wakeup or cancel happened so semaphore is available.
 			// Grab it to avoid getting out of sync.
 			gp.m.blocked = true
 			if semasleep(-1) < 0 {
@@ -279,3 +301,41 @@ func notetsleepg(n *note, ns int64) bool {
 	exitsyscall(0)
 	return ok
 }
+
+// notecancel wakes up a notetsleepgresult on n early, making it
+// return noteCanceled. It reports whether it did so; it does nothing
+// if n has already been woken up or canceled. Unlike notewakeup it
+// may be called more than once, and may race with notewakeup.
+func notecancel(n *note) bool {
+	for {
+		v := atomic.Loaduintptr(&n.key)
+		switch v {
+		case mutex_locked, note_canceled:
+			return false
+		case 0:
+			// Nothing is waiting yet.
+			if atomic.Casuintptr(&n.key, 0, note_canceled) {
+				return true
+			}
+		default:
+			// Must be the waiting m. Wake it up.
+			if atomic.Casuintptr(&n.key, v, note_canceled) {
+				semawakeup((*m)(unsafe.Pointer(v)))
+				return true
+			}
+		}
+	}
+}
+
+// same as notetsleepg, but reports why it returned: noteTimeout,
+// noteWakeup or noteCanceled. If a wakeup and a cancel race, either
+// result may be returned, but n is only woken once.
+func notetsleepgresult(n *note, ns int64) int32 {
+	if !notetsleepg(n, ns) {
+		return noteTimeout
+	}
+	if atomic.Loaduintptr(&n.key) == note_canceled {
+		return noteCanceled
+	}
+	return noteWakeup
+}
@@ -6,7 +6,9 @@ package runtime_test
 
 import (
 	"flag"
+	"fmt"
 	. "runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -109,6 +111,107 @@ func TestTinyAlloc(t *testing.T) {
 	}
 }
 
+type arenaNode struct {
+	next *arenaNode
+	val  *arenaPayload
+}
+
+type arenaPayload struct {
+	x [4]int
+}
+
+func TestArena(t *testing.T) {
+	const N = 10000
+	a := NewArena(N * unsafe.Sizeof(arenaNode{}))
+	if n := ArenaCount(); n != 1 {
+		t.Fatalf("ArenaCount() = %d after NewArena, want 1", n)
+	}
+
+	var before, after MemStats
+	ReadMemStats(&before)
+	var list *arenaNode
+	for i := 0; i < N; i++ {
+		n := (*arenaNode)(a.New(arenaNode{}))
+		if n == nil {
+			t.Fatalf("arena full after %d nodes", i)
+		}
+		if n.next != nil || n.val != nil {
+			t.Fatalf("arena node %d is not zeroed", i)
+		}
+		n.next = list
+		list = n
+	}
+	ReadMemStats(&after)
+	if d := after.Mallocs - before.Mallocs; d >= N/10 {
+		t.Errorf("allocating %d arena objects counted %d heap allocations", N, d)
+	}
+	if a.New([1 << 20]byte{}) != nil {
+		t.Errorf("arena did not report running out of room")
+	}
+
+	// A heap object referenced only from the arena must stay alive.
+	finalized := make(chan bool, 1)
+	p := &arenaPayload{x: [4]int{1, 2, 3, 4}}
+	SetFinalizer(p, func(*arenaPayload) { finalized <- true })
+	list.val = p
+	p = nil
+	GC()
+	GC()
+	select {
+	case <-finalized:
+		t.Fatal("object referenced from arena was collected")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if list.val.x != [4]int{1, 2, 3, 4} {
+		t.Errorf("object referenced from arena was overwritten: %v", list.val.x)
+	}
+
+	n := 0
+	for l := list; l != nil; l = l.next {
+		n++
+	}
+	if n != N {
+		t.Errorf("walked %d arena nodes, want %d", n, N)
+	}
+
+	ReadMemStats(&before)
+	list = nil
+	a.Free()
+	ReadMemStats(&after)
+	if n := ArenaCount(); n != 0 {
+		t.Errorf("ArenaCount() = %d after Free, want 0", n)
+	}
+	if after.OtherSys >= before.OtherSys {
+		t.Errorf("freeing arena did not release memory: OtherSys %d -> %d", before.OtherSys, after.OtherSys)
+	}
+}
+
+// Skipping the zeroing for RawByteSlice must not leak old contents
+// into allocations that do need zeroing.
+func TestNoscanZeroing(t *testing.T) {
+	sizes := []int{24, 1000, 32 << 10, 1 << 20}
+	for i := 0; i < 4; i++ {
+		for _, n := range sizes {
+			b := RawByteSlice(n)
+			if len(b) != n || cap(b) != n {
+				t.Fatalf("RawByteSlice(%d) has len %d, cap %d", n, len(b), cap(b))
+			}
+			for j := range b {
+				b[j] = 0xff
+			}
+		}
+		GC()
+		for _, n := range sizes {
+			b := make([]byte, n)
+			for j, c := range b {
+				if c != 0 {
+					t.Fatalf("make([]byte, %d)[%d] = %#x, want 0", n, j, c)
+				}
+			}
+		}
+	}
+}
+
 var mallocSink uintptr
 
 func BenchmarkMalloc8(b *testing.B) {
@@ -129,6 +232,71 @@ func BenchmarkMalloc16(b *testing.B) {
 	mallocSink = x
 }
 
+type hotClassObject struct {
+	p *int
+	x [5]int
+}
+
+// BenchmarkMallocHotClass allocates objects of a single size class
+// from every P, which makes the Ps compete for that class's central
+// free list. With mcachehot=0 each P searches the central list, holding
+// its lock, for every span it fills its cache with; otherwise it does
+// so for every other span.
+func BenchmarkMallocHotClass(b *testing.B) {
+	for _, hot := range []int32{0, 8} {
+		b.Run(fmt.Sprintf("mcachehot=%d", hot), func(b *testing.B) {
+			defer SetMCacheHot(SetMCacheHot(hot))
+			b.RunParallel(func(pb *testing.PB) {
+				var x uintptr
+				for pb.Next() {
+					p := new(hotClassObject)
+					x ^= uintptr(unsafe.Pointer(p))
+				}
+				atomic.AddUintptr(&mallocSink, x)
+			})
+		})
+	}
+}
+
+// BenchmarkPersistentAlloc allocates runtime-internal memory from
+// every P, with and without per-P chunks. The memory is never freed,
+// so each allocation is a single byte.
+func BenchmarkPersistentAlloc(b *testing.B) {
+	for _, perP := range []bool{false, true} {
+		b.Run(fmt.Sprintf("perP=%v", perP), func(b *testing.B) {
+			defer SetPersistentPerP(SetPersistentPerP(perP))
+			b.RunParallel(func(pb *testing.PB) {
+				var x uintptr
+				for pb.Next() {
+					p := PersistentAlloc(1, 1)
+					x ^= uintptr(unsafe.Pointer(p))
+				}
+				atomic.AddUintptr(&mallocSink, x)
+			})
+		})
+	}
+}
+
+var byteSliceSink []byte
+
+// BenchmarkMallocLargeNoscan compares allocating a byte slice that
+// must be zeroed with one that the caller is about to overwrite.
+func BenchmarkMallocLargeNoscan(b *testing.B) {
+	const n = 1 << 20
+	b.Run("zeroed", func(b *testing.B) {
+		b.SetBytes(n)
+		for i := 0; i < b.N; i++ {
+			byteSliceSink = make([]byte, n)
+		}
+	})
+	b.Run("raw", func(b *testing.B) {
+		b.SetBytes(n)
+		for i := 0; i < b.N; i++ {
+			byteSliceSink = RawByteSlice(n)
+		}
+	})
+}
+
 func BenchmarkMallocTypeInfo8(b *testing.B) {
 	var x uintptr
 	for i := 0; i < b.N; i++ {
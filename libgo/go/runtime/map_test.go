@@ -77,6 +77,35 @@ func TestNan(t *testing.T) {
 	}
 }
 
+func TestNilMap(t *testing.T) {
+	const nilMapWrite = "assignment to entry in nil map"
+	var m map[string]int
+
+	// Reading from, deleting from and ranging over a nil map are
+	// all fine.
+	if v := m["a"]; v != 0 {
+		t.Errorf("m[\"a\"] = %d, want 0", v)
+	}
+	if v, ok := m["a"]; v != 0 || ok {
+		t.Errorf("m[\"a\"] = %d, %t, want 0, false", v, ok)
+	}
+	if len(m) != 0 {
+		t.Errorf("len(m) = %d, want 0", len(m))
+	}
+	delete(m, "a")
+	for k := range m {
+		t.Errorf("range over nil map returned key %q", k)
+	}
+
+	testRuntimeError(t, "assign", nilMapWrite, func() { m["a"] = 1 })
+	testRuntimeError(t, "increment", nilMapWrite, func() { m["a"]++ })
+	testRuntimeError(t, "op-assign", nilMapWrite, func() { m["a"] += 2 })
+	testRuntimeError(t, "struct key", nilMapWrite, func() {
+		var ms map[struct{ a, b int }]string
+		ms[struct{ a, b int }{1, 2}] = "x"
+	})
+}
+
 // Maps aren't actually copied on assignment.
 func TestAlias(t *testing.T) {
 	m := make(map[int]int, 0)
@@ -234,6 +263,61 @@ func TestIterGrowWithGC(t *testing.T) {
 	}
 }
 
+// make sure maps that are being grown while the garbage
+// collector runs don't lose entries.
+func TestGrowWithConcurrentGC(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	numMaps := 8
+	numKeys := 10000
+	if testing.Short() {
+		numKeys = 1000
+	}
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+	defer close(done)
+
+	var wg sync.WaitGroup
+	errs := make(chan string, numMaps)
+	for i := 0; i < numMaps; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Overflow buckets are allocated while
+			// evacuating, so use values big enough to
+			// keep the allocator busy.
+			m := make(map[int]*[4]int)
+			for k := 0; k < numKeys; k++ {
+				m[k] = &[4]int{k, k, k, k}
+			}
+			if len(m) != numKeys {
+				errs <- fmt.Sprintf("len(m) = %d, want %d", len(m), numKeys)
+				return
+			}
+			for k := 0; k < numKeys; k++ {
+				v, ok := m[k]
+				if !ok || v[0] != k || v[3] != k {
+					errs <- fmt.Sprintf("m[%d] = %v, %v", k, v, ok)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
 func testConcurrentReadsAfterGrowth(t *testing.T, useReflect bool) {
 	if runtime.GOMAXPROCS(-1) == 1 {
 		if runtime.GOARCH == "s390" {
@@ -624,3 +708,35 @@ func TestNonEscapingMap(t *testing.T) {
 		t.Fatalf("want 0 allocs, got %v", n)
 	}
 }
+
+// Growing a map must not move all of its entries at once: each insert
+// evacuates only a couple of old buckets.
+func TestMapGrowIncremental(t *testing.T) {
+	m := make(map[int]int)
+	i := 0
+	for ; i < 1<<20; i++ {
+		m[i] = i
+		if growing, _, n := runtime.MapGrowState(m); growing && n >= 1<<10 {
+			break
+		}
+	}
+	growing, prev, n := runtime.MapGrowState(m)
+	if !growing {
+		t.Fatal("map never started growing")
+	}
+	inserts := 0
+	for growing {
+		i++
+		m[i] = i
+		inserts++
+		var evacuated uintptr
+		growing, evacuated, _ = runtime.MapGrowState(m)
+		if growing && evacuated > prev+2 {
+			t.Fatalf("insert %d evacuated %d old buckets", inserts, evacuated-prev)
+		}
+		prev = evacuated
+	}
+	if uintptr(inserts) < n/2 {
+		t.Errorf("growing %d old buckets finished after %d inserts", n, inserts)
+	}
+}
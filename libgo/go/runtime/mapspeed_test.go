@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 )
 
 const size = 10
@@ -326,3 +327,25 @@ func BenchmarkComplexAlgMap(b *testing.B) {
 		_ = m[k]
 	}
 }
+
+// BenchmarkMapGrowLatency times each insert into a map that grows
+// from empty to 1<<20 entries. Moving entries to the new buckets is
+// spread over later inserts, so the slowest insert should stay far
+// below the total.
+func BenchmarkMapGrowLatency(b *testing.B) {
+	const n = 1 << 20
+	var worst, total time.Duration
+	for i := 0; i < b.N; i++ {
+		m := make(map[int]int)
+		for j := 0; j < n; j++ {
+			start := time.Now()
+			m[j] = j
+			d := time.Since(start)
+			total += d
+			if d > worst {
+				worst = d
+			}
+		}
+	}
+	b.Logf("mean %v, worst %v", total/time.Duration(b.N*n), worst)
+}
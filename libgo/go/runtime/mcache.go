@@ -50,6 +50,16 @@ type mcache struct {
 	alloc [_NumSizeClasses]*mspan     // spans to allocate from
 	free  [_NumSizeClasses]mcachelist // lists of explicitly freed objects
 
+	// Hot size classes keep a spare span, so that every other
+	// refill can be done without searching the central list. The
+	// central list counts the spare span's objects as free until
+	// it replaces the span in alloc. A size class is hot when it
+	// has been refilled GODEBUG=mcachehot times; the counts are
+	// halved at each collection, which also returns the spare
+	// spans.
+	spare   [_NumSizeClasses]*mspan
+	nrefill [_NumSizeClasses]uint32
+
 	// Local allocator stats, flushed during GC.
 	local_nlookup    uintptr                  // number of pointer lookups
 	local_largefree  uintptr                  // bytes freed for large objects (>maxsmallsize)
@@ -47,7 +47,7 @@ type MemStats struct {
 	PauseNs       [256]uint64 // circular buffer of recent GC pause times, most recent at [(NumGC+255)%256]
 	PauseEnd      [256]uint64 // circular buffer of recent GC pause end times
 	NumGC         uint32
-	GCCPUFraction float64 // fraction of CPU time used by GC
+	GCCPUFraction float64 // fraction of CPU time used by GC pauses; sweeping after a pause is not counted
 	EnableGC      bool
 	DebugGC       bool
 
@@ -6,6 +6,7 @@ package runtime_test
 
 import (
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -123,6 +124,48 @@ func TestFinalizerZeroSizedStruct(t *testing.T) {
 	runtime.SetFinalizer(z, func(*Z) {})
 }
 
+// A large batch of finalizers should not keep other goroutines
+// from running, even with only one P.
+func TestFinalizerFlood(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	const N = 10000
+	var ran int32
+	done := make(chan bool)
+	go func() {
+		// Allocate in a separate goroutine so that no stale
+		// pointers are left on this stack.
+		for i := 0; i < N; i++ {
+			v := new(int)
+			runtime.SetFinalizer(v, func(*int) {
+				atomic.AddInt32(&ran, 1)
+			})
+		}
+		done <- true
+	}()
+	<-done
+	runtime.GC()
+
+	progress := false
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		n := atomic.LoadInt32(&ran)
+		if n == N {
+			break
+		}
+		if n > 0 {
+			progress = true
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d finalizers ran", n, N)
+		}
+		runtime.Gosched()
+	}
+	if !progress {
+		t.Error("no other goroutine ran while finalizers were running")
+	}
+}
+
 func BenchmarkFinalizer(b *testing.B) {
 	const Batch = 1000
 	b.RunParallel(func(pb *testing.PB) {
@@ -0,0 +1,78 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import _ "unsafe" // for go:linkname
+
+// For gccgo, use go:linkname to rename compiler-called functions to
+// themselves, so that the compiler will export them.
+//
+//go:linkname panicIndex runtime.panicIndex
+//go:linkname panicIndexU runtime.panicIndexU
+
+// panicmemAddr is called from the C signal handler in go-signal.c.
+//
+//go:linkname panicmemAddr runtime.panicmemAddr
+
+// panicCallers copies into pc the stack of the innermost active
+// panic of the calling goroutine, as it was when panic was called
+// and before any deferred functions ran. It is meant to be called
+// from a deferred function, possibly after calling recover, to find
+// out where the panic came from. The first entry is the caller of
+// panic. It returns the number of entries written to pc, which is
+// zero if the goroutine is not panicking. The frames that called
+// panic are still on the stack below the deferred call, so they are
+// only walked here, not on every panic.
+func panicCallers(pc []uintptr) int {
+	p := getg()._panic
+	if p == nil || p.isforeign {
+		return 0
+	}
+	return panicpcs(pc)
+}
+
+// panicpcs stores in pc the callers of the innermost __go_panic on
+// the stack. Implemented in go-callers.c.
+func panicpcs(pc []uintptr) int
+
+// panicIndex is called by compiled code when the index x is out of
+// range for the length y.
+func panicIndex(x int64, y int) {
+	panicBounds(boundsError{x: x, isSigned: true, y: y})
+}
+
+// panicIndexU is like panicIndex, for an index of unsigned type.
+func panicIndexU(x uint64, y int) {
+	panicBounds(boundsError{x: int64(x), isSigned: false, y: y})
+}
+
+// panicBounds panics with e, or throws if the M is in a state in
+// which it cannot panic, as runtime_panicstring does.
+func panicBounds(e boundsError) {
+	mp := getg().m
+	if mp.mallocing != 0 || mp.gcing != 0 || mp.locks != 0 {
+		print("panic: index out of range [")
+		if e.isSigned {
+			print(e.x)
+		} else {
+			print(uint64(e.x))
+		}
+		print("] with length ", e.y, "\n")
+		throw("panic in runtime")
+	}
+	panic(e)
+}
+
+// panicmemAddr is called by the signal handler for a fault at addr
+// in a goroutine that has called debug.SetPanicOnFault. The panic
+// value's Addr method reports addr.
+func panicmemAddr(addr uintptr) {
+	mp := getg().m
+	if mp.mallocing != 0 || mp.gcing != 0 || mp.locks != 0 {
+		print("panic: invalid memory address or nil pointer dereference\n")
+		throw("panic in runtime")
+	}
+	panic(errorAddressString{msg: "invalid memory address or nil pointer dereference", addr: addr})
+}
@@ -594,6 +594,91 @@ func blockCond() {
 	mu.Unlock()
 }
 
+func TestMutexProfile(t *testing.T) {
+	old := runtime.SetMutexProfileFraction(1)
+	defer runtime.SetMutexProfileFraction(old)
+	if old != 0 {
+		t.Fatalf("need MutexProfileRate 0, got %d", old)
+	}
+
+	blockMutex()
+
+	var records []runtime.BlockProfileRecord
+	n, ok := runtime.MutexProfile(nil)
+	for !ok {
+		records = make([]runtime.BlockProfileRecord, n+10)
+		n, ok = runtime.MutexProfile(records)
+	}
+	records = records[:n]
+	if n == 0 {
+		t.Fatal("empty mutex profile")
+	}
+
+	// The wait is charged to the Unlock in blockMutex that ended it.
+	for _, r := range records {
+		if r.Count <= 0 || r.Cycles <= 0 {
+			continue
+		}
+		for _, pc := range r.Stack() {
+			f := runtime.FuncForPC(pc)
+			if f == nil {
+				continue
+			}
+			if name := f.Name(); strings.Contains(name, "blockMutex") || strings.Contains(name, "sync.(*Mutex).Unlock") {
+				return
+			}
+		}
+	}
+	t.Fatal("no mutex profile record with nonzero cycles from the Unlock in blockMutex")
+}
+
+func blockProfileRecords() []runtime.BlockProfileRecord {
+	var records []runtime.BlockProfileRecord
+	n, ok := runtime.BlockProfile(nil)
+	for !ok {
+		records = make([]runtime.BlockProfileRecord, n+10)
+		n, ok = runtime.BlockProfile(records)
+	}
+	return records[:n]
+}
+
+func blockFullChan() {
+	c := make(chan bool, 1)
+	c <- true
+	go func() {
+		time.Sleep(blockDelay)
+		<-c
+	}()
+	c <- true
+}
+
+func TestBlockProfileFullChan(t *testing.T) {
+	runtime.SetBlockProfileRate(1)
+	defer runtime.SetBlockProfileRate(0)
+
+	var before int64
+	for _, r := range blockProfileRecords() {
+		before += r.Count
+	}
+
+	blockFullChan()
+
+	var after int64
+	found := false
+	for _, r := range blockProfileRecords() {
+		after += r.Count
+		if r.Count > 0 && r.Cycles > 0 {
+			found = true
+		}
+	}
+	if after <= before {
+		t.Fatalf("blocking on a full channel was not recorded: %d events before, %d after", before, after)
+	}
+	if !found {
+		t.Fatal("no block profile record with nonzero cycles")
+	}
+}
+
 func func1(c chan int) { <-c }
 func func2(c chan int) { <-c }
 func func3(c chan int) { <-c }
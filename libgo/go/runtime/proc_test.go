@@ -5,16 +5,20 @@
 package runtime_test
 
 import (
+	"fmt"
 	"math"
 	"net"
+	"os"
 	"runtime"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 var stop = make(chan bool, 1)
@@ -52,6 +56,306 @@ func TestStopTheWorldDeadlock(t *testing.T) {
 	runtime.GOMAXPROCS(maxprocs)
 }
 
+func TestStopTheWorldStopsAllPs(t *testing.T) {
+	const P = 4
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P))
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	// Keep the other Ps running goroutines that only stop at their
+	// next channel operation...
+	for i := 0; i < P; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := make(chan bool, 1)
+			for {
+				select {
+				case <-stop:
+					return
+				case c <- true:
+				}
+				<-c
+			}
+		}()
+	}
+	// ...and one that keeps entering system calls, so that its P
+	// is often stopped on its behalf.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ts := syscall.Timespec{Nsec: 1000}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			syscall.Nanosleep(&ts, nil)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if n := runtime.StopTheWorld(); n != P {
+			t.Fatalf("stop the world returned with %d of %d Ps stopped", n, P)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestGCWaitingStopsGoroutines(t *testing.T) {
+	const P = 4
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P))
+
+	var counter uint64
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < P; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				atomic.AddUint64(&counter, 1)
+				runtime.Gosched()
+			}
+		}()
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	waitCounter := func() {
+		c := atomic.LoadUint64(&counter)
+		for atomic.LoadUint64(&counter) == c {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitCounter()
+	for i := 0; i < 10; i++ {
+		// Once gcwaiting is set the goroutines must give up
+		// their Ps and stop running.
+		if n := runtime.GCWaiting(&counter, 10000); n != 0 {
+			t.Fatalf("goroutines ran %d times with gcwaiting set", n)
+		}
+		// And they must run again once the world starts.
+		waitCounter()
+	}
+}
+
+func TestSpinningMBound(t *testing.T) {
+	const P = 4
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P))
+
+	for round := 0; round < 10; round++ {
+		// Ready many goroutines at once. Each new one may wake
+		// an M, but only when none is spinning already, so the
+		// number of spinning Ms stays bounded by the number of Ps.
+		start := make(chan bool)
+		var wg sync.WaitGroup
+		for i := 0; i < 200; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				for j := 0; j < 100; j++ {
+					runtime.Gosched()
+				}
+			}()
+		}
+		done := make(chan bool)
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		close(start)
+
+		timeout := time.After(10 * time.Second)
+	wait:
+		for {
+			if n := runtime.NMSpinning(); n > P {
+				t.Fatalf("%d spinning Ms with GOMAXPROCS=%d", n, P)
+			}
+			select {
+			case <-done:
+				break wait
+			case <-timeout:
+				t.Fatal("readied goroutines did not all run")
+			default:
+				runtime.Gosched()
+			}
+		}
+	}
+}
+
+func TestIdlePList(t *testing.T) {
+	const P = 8
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P))
+
+	// Goroutines that keep going to sleep and waking up make their
+	// Ps go idle and be taken off the idle list again, from many
+	// Ms at once.
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 2*P; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				time.Sleep(10 * time.Microsecond)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		if n := runtime.CheckPidle(); n < 0 || n >= P {
+			close(stop)
+			wg.Wait()
+			t.Fatalf("idle P list is inconsistent: CheckPidle = %d", n)
+		}
+		runtime.Gosched()
+	}
+	close(stop)
+	wg.Wait()
+
+	// Once the goroutines are gone their Ps are back on the list.
+	for i := 0; ; i++ {
+		n := runtime.CheckPidle()
+		if n < 0 {
+			t.Fatal("idle P list is inconsistent")
+		}
+		if n > 0 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("no P went idle")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIdleMList(t *testing.T) {
+	const P = 4
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P))
+
+	// Blocking system calls hand off their Ps, which wakes idle Ms
+	// or starts new ones; when the calls return the extra Ms park
+	// on the idle list again.
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 2*P; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ts := syscall.Timespec{Nsec: 100000}
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				syscall.Nanosleep(&ts, nil)
+			}
+		}()
+	}
+
+	for i := 0; i < 1000; i++ {
+		if n := runtime.CheckMidle(); n < 0 {
+			close(stop)
+			wg.Wait()
+			t.Fatal("idle M list is inconsistent")
+		}
+		runtime.Gosched()
+	}
+	close(stop)
+	wg.Wait()
+
+	// With the system calls done, the Ms that ran them go idle.
+	for i := 0; ; i++ {
+		n := runtime.CheckMidle()
+		if n < 0 {
+			t.Fatal("idle M list is inconsistent")
+		}
+		if n > 0 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("no M went idle")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAllm(t *testing.T) {
+	const N = 4
+
+	// Each goroutine keeps its own M while it blocks, so the rest
+	// of the program needs new ones.
+	var started sync.WaitGroup
+	done := make(chan bool)
+	for i := 0; i < N; i++ {
+		started.Add(1)
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			started.Done()
+			<-done
+		}()
+	}
+	started.Wait()
+	defer close(done)
+
+	n := runtime.CheckAllm()
+	if n < 0 {
+		t.Fatal("allm is inconsistent")
+	}
+	if n < N+1 {
+		t.Fatalf("allm has %d Ms, want at least %d", n, N+1)
+	}
+	if m, _ := runtime.ThreadCreateProfile(nil); m < int(n) {
+		t.Errorf("ThreadCreateProfile saw %d Ms, allm has %d", m, n)
+	}
+}
+
+func TestSysmonIdle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if strings.Contains(os.Getenv("GODEBUG"), "schedtrace") {
+		t.Skip("sysmon does not park with schedtrace set")
+	}
+
+	// With every P idle, sysmon should park on sysmonnote rather
+	// than keep polling. Polling from 20us up to 10ms would take
+	// about 75 ticks in 200ms.
+	t0 := runtime.SysmonTick()
+	time.Sleep(200 * time.Millisecond)
+	if n := runtime.SysmonTick() - t0; n > 25 {
+		t.Errorf("sysmon ticked %d times while the program was idle", n)
+	}
+
+	// Once there is work again sysmon must wake up and resume
+	// polling.
+	t0 = runtime.SysmonTick()
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+		runtime.Gosched()
+	}
+	if runtime.SysmonTick() == t0 {
+		t.Error("sysmon did not wake up when the program became busy")
+	}
+}
+
 func TestYieldProgress(t *testing.T) {
 	testYieldProgress(t, false)
 }
@@ -97,6 +401,88 @@ func TestYieldLocked(t *testing.T) {
 	<-c
 }
 
+func TestMaxGoroutines(t *testing.T) {
+	const (
+		limit = 4
+		N     = 200
+	)
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(8))
+	defer runtime.SetMaxGoroutines(runtime.SetMaxGoroutines(limit))
+
+	var running, maxRunning int32
+	done := make(chan bool, N)
+	for i := 0; i < N; i++ {
+		go func() {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			for j := 0; j < 10; j++ {
+				runtime.Gosched()
+			}
+			atomic.AddInt32(&running, -1)
+			done <- true
+		}()
+	}
+	for i := 0; i < N; i++ {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("only %d of %d goroutines finished", i, N)
+		}
+	}
+	if max := atomic.LoadInt32(&maxRunning); max > limit {
+		t.Errorf("%d goroutines ran at once, want at most %d", max, limit)
+	}
+}
+
+// TestMaxGoroutinesBlocked checks that goroutines blocked in a
+// channel receive do not count against the goroutine limit, and that
+// the runtime's timer goroutine is not held back by it.
+func TestMaxGoroutinesBlocked(t *testing.T) {
+	const limit = 2
+	defer runtime.SetMaxGoroutines(runtime.SetMaxGoroutines(limit))
+
+	block := make(chan bool)
+	for i := 0; i < 2*limit; i++ {
+		go func() {
+			<-block
+		}()
+	}
+	defer close(block)
+
+	stop := make(chan bool)
+	spinning := make(chan bool, limit)
+	for i := 0; i < limit; i++ {
+		go func() {
+			spinning <- true
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+	for i := 0; i < limit; i++ {
+		select {
+		case <-spinning:
+		case <-time.After(10 * time.Second):
+			close(stop)
+			t.Fatalf("goroutine held back although the goroutines started before it are blocked")
+		}
+	}
+	// The limit is now taken by the spinning goroutines; sleeping
+	// still needs the timer goroutine.
+	time.Sleep(time.Millisecond)
+	close(stop)
+}
+
 func TestGoroutineParallelism(t *testing.T) {
 	if runtime.NumCPU() == 1 {
 		// Takes too long, too easy to deadlock, etc.
@@ -214,176 +600,1067 @@ func testGoroutineParallelism2(t *testing.T, load, netpoll bool) {
 	}
 }
 
-func TestBlockLocked(t *testing.T) {
-	const N = 10
-	c := make(chan bool)
+func TestBlockLocked(t *testing.T) {
+	const N = 10
+	c := make(chan bool)
+	go func() {
+		runtime.LockOSThread()
+		for i := 0; i < N; i++ {
+			c <- true
+		}
+		runtime.UnlockOSThread()
+	}()
+	for i := 0; i < N; i++ {
+		<-c
+	}
+}
+
+func TestTimerFairness(t *testing.T) {
+	done := make(chan bool)
+	c := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for {
+				select {
+				case c <- true:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	timer := time.After(20 * time.Millisecond)
+	for {
+		select {
+		case <-c:
+		case <-timer:
+			close(done)
+			return
+		}
+	}
+}
+
+func TestTimerFairness2(t *testing.T) {
+	done := make(chan bool)
+	c := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			timer := time.After(20 * time.Millisecond)
+			var buf [1]byte
+			for {
+				syscall.Read(0, buf[0:0])
+				select {
+				case c <- true:
+				case <-c:
+				case <-timer:
+					done <- true
+					return
+				}
+			}
+		}()
+	}
+	<-done
+	<-done
+}
+
+// The function is used to test preemption at split stack checks.
+// Declaring a var avoids inlining at the call site.
+var preempt = func() int {
+	var a [128]int
+	sum := 0
+	for _, v := range a {
+		sum += v
+	}
+	return sum
+}
+
+func TestPreemption(t *testing.T) {
+	t.Skip("gccgo does not implement preemption")
+	// Test that goroutines are preempted at function calls.
+	N := 5
+	if testing.Short() {
+		N = 2
+	}
+	c := make(chan bool)
+	var x uint32
+	for g := 0; g < 2; g++ {
+		go func(g int) {
+			for i := 0; i < N; i++ {
+				for atomic.LoadUint32(&x) != uint32(g) {
+					preempt()
+				}
+				atomic.StoreUint32(&x, uint32(1-g))
+			}
+			c <- true
+		}(g)
+	}
+	<-c
+	<-c
+}
+
+func TestPreemptionGC(t *testing.T) {
+	t.Skip("gccgo does not implement preemption")
+	// Test that pending GC preempts running goroutines.
+	P := 5
+	N := 10
+	if testing.Short() {
+		P = 3
+		N = 2
+	}
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P + 1))
+	var stop uint32
+	for i := 0; i < P; i++ {
+		go func() {
+			for atomic.LoadUint32(&stop) == 0 {
+				preempt()
+			}
+		}()
+	}
+	for i := 0; i < N; i++ {
+		runtime.Gosched()
+		runtime.GC()
+	}
+	atomic.StoreUint32(&stop, 1)
+}
+
+func TestGCFairness(t *testing.T) {
+	output := runTestProg(t, "testprog", "GCFairness")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestGCFairness2(t *testing.T) {
+	output := runTestProg(t, "testprog", "GCFairness2")
+	want := "OK\n"
+	if output != want {
+		t.Fatalf("want %s, got %s\n", want, output)
+	}
+}
+
+func TestNumGoroutine(t *testing.T) {
+	output := runTestProg(t, "testprog", "NumGoroutine")
+	want := "1\n"
+	if output != want {
+		t.Fatalf("want %q, got %q", want, output)
+	}
+
+	buf := make([]byte, 1<<20)
+
+	// Try up to 10 times for a match before giving up.
+	// This is a fundamentally racy check but it's important
+	// to notice if NumGoroutine and Stack are _always_ out of sync.
+	for i := 0; ; i++ {
+		// Give goroutines about to exit a chance to exit.
+		// The NumGoroutine and Stack below need to see
+		// the same state of the world, so anything we can do
+		// to keep it quiet is good.
+		runtime.Gosched()
+
+		n := runtime.NumGoroutine()
+		buf = buf[:runtime.Stack(buf, true)]
+
+		nstk := strings.Count(string(buf), "goroutine ")
+		if n == nstk {
+			break
+		}
+		if i >= 10 {
+			t.Fatalf("NumGoroutine=%d, but found %d goroutines in stack dump: %s", n, nstk, buf)
+		}
+	}
+}
+
+func TestNumGoroutineSystem(t *testing.T) {
+	output := runTestProg(t, "testprog", "NumGoroutineSystem")
+	want := "1\n"
+	if output != want {
+		t.Fatalf("want %q, got %q", want, output)
+	}
+}
+
+func TestSystemGoroutineCount(t *testing.T) {
+	// The scavenger starts before main; make sure the timer
+	// goroutine and the background sweeper have been started too.
+	time.Sleep(time.Millisecond)
+	runtime.GC()
+
+	// The sweeper marks itself once it first runs, so give it a
+	// few chances to catch up.
+	for i := 0; ; i++ {
+		runtime.Gosched()
+		n := runtime.NGsys()
+		if n < 2 {
+			t.Fatalf("ngsys = %d, want at least 2", n)
+		}
+		c := runtime.CountSystemG()
+		if int32(n) == c {
+			break
+		}
+		if i >= 10 {
+			t.Fatalf("ngsys = %d, but found %d system goroutines", n, c)
+		}
+	}
+}
+
+func TestStackAllGoidOrder(t *testing.T) {
+	// Let some goroutines exit first, so that the blocked ones
+	// below reuse their G structures out of goid order.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	runtime.Gosched()
+
+	c := make(chan bool)
+	defer close(c)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			wg.Done()
+			<-c
+		}()
+	}
+	wg.Wait()
+
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	var goids []int
+	for _, line := range strings.Split(string(buf), "\n") {
+		if !strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+		goid, err := strconv.Atoi(strings.Fields(line)[1])
+		if err != nil {
+			t.Fatalf("bad goroutine header %q", line)
+		}
+		goids = append(goids, goid)
+	}
+
+	// The calling goroutine comes first, then the others in
+	// ascending order starting with the main goroutine.
+	if len(goids) < 12 {
+		t.Fatalf("found %d goroutines in stack dump, want at least 12:\n%s", len(goids), buf)
+	}
+	if goids[1] != 1 {
+		t.Errorf("first goroutine after the caller is %d, want 1", goids[1])
+	}
+	for i := 2; i < len(goids); i++ {
+		if goids[i] <= goids[i-1] {
+			t.Fatalf("goroutines out of order: %v", goids)
+		}
+	}
+}
+
+// blockInFrames calls itself depth times and then blocks receiving
+// from c. The addition keeps the recursive call from being a tail
+// call, so that every level has a frame.
+func blockInFrames(depth int, c chan bool) int {
+	if depth > 0 {
+		return blockInFrames(depth-1, c) + 1
+	}
+	<-c
+	return 0
+}
+
+// startBlockedInFrames starts a goroutine that blocks in
+// blockInFrames(depth, c) and returns its ID once it has blocked.
+func startBlockedInFrames(depth int, c chan bool) int64 {
+	id := make(chan int64)
+	go func() {
+		id <- runtime.Goid()
+		blockInFrames(depth, c)
+	}()
+	goid := <-id
+	for {
+		if _, ok := chanReceiveWaits()[goid]; ok {
+			return goid
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stackFrameLines returns the function and file:line lines that
+// Stack(buf, true) prints for the goroutine goid.
+func stackFrameLines(goid int64) []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	header := fmt.Sprintf("goroutine %d [", goid)
+	var lines []string
+	in := false
+	for _, line := range strings.Split(string(buf), "\n") {
+		switch {
+		case strings.HasPrefix(line, header):
+			in = true
+		case !in:
+		case line == "" || strings.HasPrefix(line, "created by "):
+			return lines
+		default:
+			lines = append(lines, strings.TrimPrefix(line, "\t"))
+		}
+	}
+	return lines
+}
+
+func TestGoroutineFrames(t *testing.T) {
+	c := make(chan bool)
+	defer close(c)
+	goid := startBlockedInFrames(10, c)
+
+	frames := make([]runtime.Frame, 200)
+	n, more, ok := runtime.GoroutineFrames(goid, frames)
+	if !ok {
+		t.Fatalf("GoroutineFrames(%d) failed", goid)
+	}
+	if more {
+		t.Errorf("GoroutineFrames(%d) reported elided frames for a shallow stack", goid)
+	}
+	var got []string
+	depth := 0
+	for _, f := range frames[:n] {
+		got = append(got, f.Function, fmt.Sprintf("%s:%d", f.File, f.Line))
+		if f.Function == "runtime_test.blockInFrames" {
+			depth++
+		}
+	}
+	want := stackFrameLines(goid)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("GoroutineFrames(%d) =\n%s\nStack printed\n%s", goid, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if depth != 11 {
+		t.Errorf("found %d blockInFrames frames, want 11", depth)
+	}
+
+	n, more, ok = runtime.GoroutineFrames(goid, frames[:3])
+	if !ok || n != 3 || !more {
+		t.Errorf("GoroutineFrames(%d, 3 frames) = %d, %t, %t; want 3, true, true", goid, n, more, ok)
+	}
+
+	if _, _, ok := runtime.GoroutineFrames(runtime.Goid(), frames); ok {
+		t.Errorf("GoroutineFrames succeeded for the calling goroutine")
+	}
+}
+
+func TestGoroutineFramesElided(t *testing.T) {
+	c := make(chan bool)
+	defer close(c)
+	goid := startBlockedInFrames(200, c)
+
+	frames := make([]runtime.Frame, 300)
+	n, more, ok := runtime.GoroutineFrames(goid, frames)
+	if !ok {
+		t.Fatalf("GoroutineFrames(%d) failed", goid)
+	}
+	if !more {
+		t.Errorf("GoroutineFrames(%d) did not report elided frames", goid)
+	}
+	if n == 0 || n > 100 {
+		t.Errorf("GoroutineFrames(%d) returned %d frames, want 1 to 100", goid, n)
+	}
+}
+
+func TestStackRunnableSince(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	defer runtime.SetRunSince(runtime.SetRunSince(1))
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// With one P that this goroutine keeps busy, the new goroutine
+	// stays runnable until we yield.
+	done := make(chan bool)
+	go func() {
+		done <- true
+	}()
+	for start := time.Now(); time.Since(start) < 1500*time.Millisecond; {
+	}
+
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	<-done
+
+	var secs []int
+	for _, line := range strings.Split(string(buf), "\n") {
+		const prefix = "[runnable, "
+		i := strings.Index(line, prefix)
+		if !strings.HasPrefix(line, "goroutine ") || i < 0 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.Fields(line[i+len(prefix):])[0])
+		if err != nil {
+			t.Fatalf("bad goroutine header %q", line)
+		}
+		secs = append(secs, n)
+	}
+	if len(secs) == 0 {
+		t.Fatalf("no runnable goroutine annotated with its time in the stack dump:\n%s", buf)
+	}
+	for _, n := range secs {
+		if n < 1 || n > 60 {
+			t.Errorf("goroutine runnable for %d seconds, want about 1", n)
+		}
+	}
+}
+
+func TestStackAllDuringCopy(t *testing.T) {
+	goid := make(chan int64)
+	block := make(chan bool)
+	go func() {
+		goid <- runtime.Goid()
+		<-block
+	}()
+	id := <-goid
+
+	// Once the goroutine has blocked, pretend that its stack is
+	// being copied.
+	for start := time.Now(); !runtime.CasGStatusGoid(id, runtime.GWaiting, runtime.GCopystack); {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("goroutine never blocked")
+		}
+		runtime.Gosched()
+	}
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	if !runtime.CasGStatusGoid(id, runtime.GCopystack, runtime.GWaiting) {
+		t.Fatal("goroutine left copystack status during the stack dump")
+	}
+	close(block)
+
+	want := fmt.Sprintf("goroutine %d [copystack]:\n\tgoroutine stack is being copied; stack unavailable\n", id)
+	if !strings.Contains(string(buf), want) {
+		t.Errorf("stack dump does not contain %q:\n%s", want, buf)
+	}
+}
+
+func TestGoid(t *testing.T) {
+	const N = 100
+	self := runtime.Goid()
+	if self <= 0 {
+		t.Fatalf("Goid() = %d, want > 0", self)
+	}
+	seen := map[int64]bool{self: true}
+	// Run several waves so that later goroutines reuse the Gs of
+	// earlier ones; their IDs must still be fresh.
+	for i := 0; i < 10; i++ {
+		ids := make(chan int64, N)
+		for j := 0; j < N; j++ {
+			go func() {
+				ids <- runtime.Goid()
+			}()
+		}
+		for j := 0; j < N; j++ {
+			id := <-ids
+			if id <= 0 {
+				t.Fatalf("Goid() = %d, want > 0", id)
+			}
+			if seen[id] {
+				t.Fatalf("goroutine ID %d returned twice", id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func TestGoidCacheBatch(t *testing.T) {
+	const N = 1000
+	start := runtime.GoidGen()
+	var wg sync.WaitGroup
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go wg.Done()
+	}
+	wg.Wait()
+	n := runtime.GoidGen() - start
+	// The central counter only moves a batch at a time, and every P
+	// may be sitting on a partly used batch.
+	if n%runtime.GoidCacheBatch != 0 {
+		t.Errorf("goidgen advanced by %d, not a multiple of %d", n, runtime.GoidCacheBatch)
+	}
+	slack := uint64(runtime.GOMAXPROCS(-1) * runtime.GoidCacheBatch)
+	if n+slack < N || n > N+slack {
+		t.Errorf("goidgen advanced by %d for %d goroutines, want within %d", n, N, slack)
+	}
+}
+
+func TestGfreeCap(t *testing.T) {
+	const cap = 4
+	defer runtime.SetGfreeCap(runtime.SetGfreeCap(cap))
+
+	for i := 0; i < 10; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < 100; j++ {
+			wg.Add(1)
+			go func() {
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+		runtime.Gosched()
+		if n := runtime.PGfreeCntMax(); n > cap {
+			t.Fatalf("P caches %d dead goroutines, want at most %d", n, cap)
+		}
+		// The collector releases the stacks of the dead
+		// goroutines on the global list; the next round reuses
+		// them with new stacks.
+		if i%2 == 1 {
+			runtime.GC()
+		}
+	}
+}
+
+func TestSchedCheck(t *testing.T) {
+	// With the checks on, a goroutine put on a scheduler list while
+	// still on one crashes the program.
+	defer runtime.SetSchedCheck(runtime.SetSchedCheck(1))
+	// A small dead G cache moves Gs between the local and global
+	// free lists often.
+	defer runtime.SetGfreeCap(runtime.SetGfreeCap(4))
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	N := 1000
+	if testing.Short() {
+		N = 100
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := make(chan int)
+			for j := 0; j < N; j++ {
+				// Gosched puts the caller on the global run
+				// queue; the short-lived goroutine goes
+				// through the free lists.
+				go func() {
+					c <- j
+				}()
+				<-c
+				runtime.Gosched()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSchedFairness(t *testing.T) {
+	// With one P, a goroutine that yields goes on the global run
+	// queue behind a busy local one.  It must run again after about
+	// one interval's worth of local goroutines, before the local
+	// run queue drains.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	defer runtime.SetSchedFairness(runtime.SetSchedFairness(61))
+
+	const N = 200 // fits in the local run queue
+	ranBeforeYielder := func() int32 {
+		var n int32
+		var wg sync.WaitGroup
+		for i := 0; i < N; i++ {
+			wg.Add(1)
+			go func() {
+				atomic.AddInt32(&n, 1)
+				wg.Done()
+			}()
+		}
+		runtime.Gosched()
+		ran := atomic.LoadInt32(&n)
+		wg.Wait()
+		return ran
+	}
+
+	if n := ranBeforeYielder(); n >= N {
+		t.Errorf("with the default interval, the yielding goroutine ran after all %d local goroutines", n)
+	}
+	runtime.SetSchedFairness(4)
+	if n := ranBeforeYielder(); n > 8 {
+		t.Errorf("with interval 4, the yielding goroutine ran after %d local goroutines", n)
+	}
+}
+
+func TestPreemptDeferredWhileLocked(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	var ran uint32
+	go func() {
+		atomic.StoreUint32(&ran, 1)
+	}()
+
+	// While the M holds a lock, a preemption request must be
+	// remembered but not acted on.
+	runtime.LockM()
+	runtime.RequestPreempt()
+	runtime.PreemptPoint()
+	ranLocked := atomic.LoadUint32(&ran)
+	pending := runtime.PreemptPending()
+	runtime.UnlockM()
+	if ranLocked != 0 {
+		t.Fatal("goroutine was preempted while its M held a lock")
+	}
+	if !pending {
+		t.Fatal("preemption request was dropped while M held a lock")
+	}
+
+	// Once the lock count is back to zero, the next preemption
+	// point yields.
+	runtime.PreemptPoint()
+	if atomic.LoadUint32(&ran) == 0 {
+		t.Error("deferred preemption was not honored after the lock was released")
+	}
+	if runtime.PreemptPending() {
+		t.Error("preemption request still pending after yielding")
+	}
+}
+
+func TestPreemptionPoint(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	// Keep sysmon from asking this goroutine to yield on its own.
+	defer runtime.SetSysmonPreempt(runtime.SetSysmonPreempt(false))
+	// Consume any request left over from earlier tests.
+	runtime.PreemptionPoint()
+	var ran uint32
+	go func() {
+		atomic.StoreUint32(&ran, 1)
+	}()
+
+	// With no request pending, a preemption point does not yield.
+	runtime.PreemptionPoint()
+	if atomic.LoadUint32(&ran) != 0 {
+		t.Fatal("preemption point yielded with no request pending")
+	}
+
+	runtime.RequestPreempt()
+	runtime.PreemptionPoint()
+	if atomic.LoadUint32(&ran) == 0 {
+		t.Fatal("preemption point did not yield when requested")
+	}
+}
+
+func TestPreemptionPointLongRunning(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	var ran uint32
+	go func() {
+		atomic.StoreUint32(&ran, 1)
+	}()
+
+	// The scheduler asks a goroutine that has been running for a
+	// while to yield, which it does at its next preemption point.
+	start := time.Now()
+	for atomic.LoadUint32(&ran) == 0 {
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("long-running goroutine was never asked to yield")
+		}
+		runtime.PreemptionPoint()
+	}
+}
+
+func TestPreemptionPointPreemptOff(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	runtime.PreemptionPoint()
+	var ran uint32
+	go func() {
+		atomic.StoreUint32(&ran, 1)
+	}()
+
+	// With preemption turned off on the M the request stays
+	// pending instead of being honored...
+	old := runtime.SetPreemptOff("TestPreemptionPointPreemptOff")
+	runtime.RequestPreempt()
+	runtime.PreemptionPoint()
+	ranOff := atomic.LoadUint32(&ran)
+	pending := runtime.PreemptPending()
+	runtime.SetPreemptOff(old)
+	if ranOff != 0 {
+		t.Fatal("preemption point yielded with preemptoff set")
+	}
+	if !pending {
+		t.Fatal("preemption request dropped with preemptoff set")
+	}
+
+	// ...until the next preemption point after it is turned on.
+	runtime.PreemptionPoint()
+	if atomic.LoadUint32(&ran) == 0 {
+		t.Fatal("pending preemption request not honored")
+	}
+}
+
+var preemptSink *[16]uintptr
+
+func TestPreemptAllocatingLoop(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	// A loop that never blocks or yields, but allocates, must be
+	// preempted at its allocations so that other goroutines, and
+	// the garbage collector, still get to run.
+	var stop, bad uint32
+	done := make(chan bool)
+	go func() {
+		for i := uintptr(0); atomic.LoadUint32(&stop) == 0; i++ {
+			p := new([16]uintptr)
+			for j := range p {
+				p[j] = i
+			}
+			preemptSink = p
+			// Preempted in the next new, p must survive the
+			// collections run by the other goroutine.
+			q := new([16]uintptr)
+			for j := range p {
+				if p[j] != i {
+					atomic.StoreUint32(&bad, 1)
+				}
+			}
+			preemptSink = q
+		}
+		done <- true
+	}()
+
+	for i := 0; i < 10; i++ {
+		c := make(chan bool)
+		go func() {
+			c <- true
+		}()
+		<-c
+		runtime.GC()
+	}
+	atomic.StoreUint32(&stop, 1)
+	<-done
+	if atomic.LoadUint32(&bad) != 0 {
+		t.Fatal("object changed while its goroutine was preempted for GC")
+	}
+}
+
+func BenchmarkPreemptionPoint(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		runtime.PreemptionPoint()
+	}
+}
+
+// A pair of goroutines that keep readying each other must not starve
+// a third runnable goroutine on a single P. The pair hands the P back
+// and forth through runnext within one time slice; once the slice is
+// used up, the third goroutine gets its turn.
+func TestPingPongNoStarvation(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	var progress uint32
+	stop := make(chan bool)
+	ping := make(chan bool)
+	pong := make(chan bool)
 	go func() {
-		runtime.LockOSThread()
-		for i := 0; i < N; i++ {
-			c <- true
+		for {
+			select {
+			case <-stop:
+				return
+			case ping <- true:
+			}
+			<-pong
 		}
-		runtime.UnlockOSThread()
 	}()
-	for i := 0; i < N; i++ {
-		<-c
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				done <- true
+				return
+			case <-ping:
+			}
+			pong <- true
+		}
+	}()
+	go func() {
+		for i := 0; i < 100; i++ {
+			atomic.AddUint32(&progress, 1)
+			runtime.Gosched()
+		}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadUint32(&progress) < 100 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-done
+	if n := atomic.LoadUint32(&progress); n < 100 {
+		t.Fatalf("background goroutine made %d of 100 steps while a ping-pong pair ran", n)
 	}
 }
 
-func TestTimerFairness(t *testing.T) {
-	done := make(chan bool)
-	c := make(chan bool)
+// TestForEachP checks that forEachP runs its function exactly once
+// for each P, whether the P is running goroutines, idle, or held by
+// a goroutine blocked in a system call.
+func TestForEachP(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	var p [2]int
+	if err := syscall.Pipe(p[:]); err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(p[0])
+	defer syscall.Close(p[1])
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
 	for i := 0; i < 2; i++ {
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			for {
 				select {
-				case c <- true:
-				case <-done:
+				case <-stop:
 					return
+				default:
 				}
+				runtime.Gosched()
 			}
 		}()
 	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		var buf [1]byte
+		syscall.Read(p[0], buf[:])
+	}()
+	time.Sleep(10 * time.Millisecond)
 
-	timer := time.After(20 * time.Millisecond)
-	for {
-		select {
-		case <-c:
-		case <-timer:
-			close(done)
-			return
+	for i := 0; i < 10; i++ {
+		counts := make([]uint32, runtime.GOMAXPROCS(0))
+		runtime.ForEachP(counts)
+		for id, n := range counts {
+			if n != 1 {
+				t.Errorf("round %d: P %d ran the safe point function %d times, want 1", i, id, n)
+			}
 		}
 	}
+
+	close(stop)
+	syscall.Write(p[1], []byte{0})
+	wg.Wait()
 }
 
-func TestTimerFairness2(t *testing.T) {
-	done := make(chan bool)
-	c := make(chan bool)
-	for i := 0; i < 2; i++ {
+// TestParkParam checks that a goroutine woken by ready receives the
+// param set by its waker, and that the param is cleared once read so
+// that a later wakeup without one sees nil.
+func TestParkParam(t *testing.T) {
+	x := new(int)
+	for i, want := range []unsafe.Pointer{unsafe.Pointer(x), nil} {
+		type result struct {
+			got, after unsafe.Pointer
+		}
+		done := make(chan result)
 		go func() {
-			timer := time.After(20 * time.Millisecond)
-			var buf [1]byte
-			for {
-				syscall.Read(0, buf[0:0])
-				select {
-				case c <- true:
-				case <-c:
-				case <-timer:
-					done <- true
-					return
-				}
-			}
+			got := runtime.ParkParam()
+			done <- result{got, runtime.GParam()}
 		}()
+		for !runtime.ReadyParam(want) {
+			runtime.Gosched()
+		}
+		r := <-done
+		if r.got != want {
+			t.Errorf("wakeup %d: got param %p, want %p", i, r.got, want)
+		}
+		if r.after != nil {
+			t.Errorf("wakeup %d: param is %p after it was read, want nil", i, r.after)
+		}
 	}
-	<-done
-	<-done
 }
 
-// The function is used to test preemption at split stack checks.
-// Declaring a var avoids inlining at the call site.
-var preempt = func() int {
-	var a [128]int
-	sum := 0
-	for _, v := range a {
-		sum += v
+// TestParkParamChan checks that param is nil once a new goroutine
+// has read its argument and once a channel operation that was woken
+// with its sudog in param returns. Both sides of each operation are
+// checked, since either may be the one that blocks.
+func TestParkParamChan(t *testing.T) {
+	start := make(chan unsafe.Pointer)
+	go func() {
+		start <- runtime.GParam()
+	}()
+	if p := <-start; p != nil {
+		t.Errorf("new goroutine: param is %p, want nil", p)
+	}
+
+	tests := []struct {
+		name string
+		op   func(c, d chan int)
+		wake func(c, d chan int)
+	}{
+		{"recv", func(c, d chan int) { <-c }, func(c, d chan int) { c <- 1 }},
+		{"send", func(c, d chan int) { c <- 1 }, func(c, d chan int) { <-c }},
+		{"select recv", func(c, d chan int) {
+			select {
+			case <-c:
+			case <-d:
+			}
+		}, func(c, d chan int) { c <- 1 }},
+		{"select send", func(c, d chan int) {
+			select {
+			case c <- 1:
+			case d <- 1:
+			}
+		}, func(c, d chan int) { <-c }},
+	}
+	for _, tt := range tests {
+		c, d := make(chan int), make(chan int)
+		done := make(chan unsafe.Pointer)
+		op := tt.op
+		go func() {
+			op(c, d)
+			done <- runtime.GParam()
+		}()
+		// Give the goroutine a chance to block first.
+		time.Sleep(time.Millisecond)
+		tt.wake(c, d)
+		woken := runtime.GParam()
+		if p := <-done; p != nil {
+			t.Errorf("%s: param is %p after the operation, want nil", tt.name, p)
+		}
+		if woken != nil {
+			t.Errorf("%s: waker's param is %p after the operation, want nil", tt.name, woken)
+		}
 	}
-	return sum
 }
 
-func TestPreemption(t *testing.T) {
-	t.Skip("gccgo does not implement preemption")
-	// Test that goroutines are preempted at function calls.
-	N := 5
-	if testing.Short() {
-		N = 2
+// TestSchedTotalTime checks that the scheduler's integral of
+// GOMAXPROCS over time follows changes to GOMAXPROCS.
+func TestSchedTotalTime(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	const d = 50 * time.Millisecond
+	var want float64
+	start := runtime.SchedTotalTime()
+	t0 := time.Now()
+	for _, n := range []int{4, 2, 1} {
+		runtime.GOMAXPROCS(n)
+		t1 := time.Now()
+		time.Sleep(d)
+		want += float64(n) * float64(time.Since(t1))
 	}
-	c := make(chan bool)
-	var x uint32
-	for g := 0; g < 2; g++ {
-		go func(g int) {
-			for i := 0; i < N; i++ {
-				for atomic.LoadUint32(&x) != uint32(g) {
-					preempt()
-				}
-				atomic.StoreUint32(&x, uint32(1-g))
-			}
-			c <- true
-		}(g)
+	got := float64(runtime.SchedTotalTime() - start)
+	elapsed := float64(time.Since(t0))
+	if math.Abs(got-want) > 0.1*want {
+		t.Errorf("integral of GOMAXPROCS over %v = %v, want about %v", time.Duration(elapsed), got, want)
 	}
-	<-c
-	<-c
+	t.Logf("average parallelism %.2f", got/elapsed)
 }
 
-func TestPreemptionGC(t *testing.T) {
-	t.Skip("gccgo does not implement preemption")
-	// Test that pending GC preempts running goroutines.
-	P := 5
-	N := 10
-	if testing.Short() {
-		P = 3
-		N = 2
+// TestSysmonNetpoll checks that a goroutine waiting for network I/O
+// is woken while every P is kept busy. The busy goroutines yield, so
+// the run queues are never empty and no P polls the network itself;
+// sysmon has to do it.
+func TestSysmonNetpoll(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen: %v", err)
 	}
-	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(P + 1))
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	read := make(chan bool)
+	go func() {
+		var buf [1]byte
+		server.Read(buf[:])
+		read <- true
+	}()
+	// Let the reader block in the netpoller.
+	time.Sleep(10 * time.Millisecond)
+
 	var stop uint32
-	for i := 0; i < P; i++ {
+	var wg sync.WaitGroup
+	for i := 0; i < procs; i++ {
+		wg.Add(1)
 		go func() {
+			defer wg.Done()
 			for atomic.LoadUint32(&stop) == 0 {
-				preempt()
+				runtime.Gosched()
 			}
 		}()
 	}
-	for i := 0; i < N; i++ {
-		runtime.Gosched()
-		runtime.GC()
+	defer wg.Wait()
+	defer atomic.StoreUint32(&stop, 1)
+
+	start := time.Now()
+	if _, err := client.Write([]byte{0}); err != nil {
+		t.Fatal(err)
 	}
-	atomic.StoreUint32(&stop, 1)
+	select {
+	case <-read:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reader not woken after %v with %d busy goroutines", time.Since(start), procs)
+	}
+	t.Logf("reader woken after %v", time.Since(start))
 }
 
-func TestGCFairness(t *testing.T) {
-	output := runTestProg(t, "testprog", "GCFairness")
-	want := "OK\n"
-	if output != want {
-		t.Fatalf("want %s, got %s\n", want, output)
+// floatWork does a float computation whose result depends on every
+// intermediate value, yielding between steps if yield is set.
+func floatWork(seed float64, yield bool) float64 {
+	x := seed
+	for i := 0; i < 1000; i++ {
+		x = math.Sqrt(x*x+float64(i)) / 1.0001
+		if yield && i%10 == 0 {
+			runtime.Gosched()
+		}
 	}
+	return x
 }
 
-func TestGCFairness2(t *testing.T) {
-	output := runTestProg(t, "testprog", "GCFairness2")
-	want := "OK\n"
-	if output != want {
-		t.Fatalf("want %s, got %s\n", want, output)
+func TestFloatStateAcrossSwitches(t *testing.T) {
+	// Floating point state must survive goroutine switches and
+	// signal delivery. On soft-float targets gccgo does float
+	// arithmetic in libgcc and keeps no emulator state in the M
+	// (m.softfloat is always 0), so the same check applies.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	runtime.SetCPUProfileRate(1000) // deliver SIGPROF while computing
+	defer func() {
+		runtime.SetCPUProfileRate(0)
+		// Read the profile to the end, or no later test can
+		// start another one.
+		for runtime.CPUProfile() != nil {
+		}
+	}()
+
+	const n = 8
+	var want [n]float64
+	for i := range want {
+		want[i] = floatWork(float64(i+1), false)
+	}
+	var got [n]float64
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = floatWork(float64(i+1), true)
+		}(i)
+	}
+	wg.Wait()
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("goroutine %d computed %v, want %v", i, got[i], want[i])
+		}
 	}
 }
 
-func TestNumGoroutine(t *testing.T) {
-	output := runTestProg(t, "testprog", "NumGoroutine")
-	want := "1\n"
+func TestLockOSThreadExit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test requires /proc/self/task")
+	}
+	output := runTestProg(t, "testprog", "LockOSThreadExit")
+	want := "OK\n"
 	if output != want {
 		t.Fatalf("want %q, got %q", want, output)
 	}
-
-	buf := make([]byte, 1<<20)
-
-	// Try up to 10 times for a match before giving up.
-	// This is a fundamentally racy check but it's important
-	// to notice if NumGoroutine and Stack are _always_ out of sync.
-	for i := 0; ; i++ {
-		// Give goroutines about to exit a chance to exit.
-		// The NumGoroutine and Stack below need to see
-		// the same state of the world, so anything we can do
-		// to keep it quiet is good.
-		runtime.Gosched()
-
-		n := runtime.NumGoroutine()
-		buf = buf[:runtime.Stack(buf, true)]
-
-		nstk := strings.Count(string(buf), "goroutine ")
-		if n == nstk {
-			break
-		}
-		if i >= 10 {
-			t.Fatalf("NumGoroutine=%d, but found %d goroutines in stack dump: %s", n, nstk, buf)
-		}
-	}
 }
 
 func TestPingPongHog(t *testing.T) {
@@ -437,6 +1714,46 @@ func TestPingPongHog(t *testing.T) {
 	}
 }
 
+// BenchmarkPingPong measures a hop between two goroutines on an
+// unbuffered channel while other goroutines are runnable on the
+// same P. A readied goroutine runs next instead of waiting for them.
+func BenchmarkPingPong(b *testing.B) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	ping, pong := make(chan bool), make(chan bool)
+	go func() {
+		for v := range ping {
+			pong <- v
+		}
+	}()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ping <- true
+		<-pong
+	}
+	b.StopTimer()
+	close(ping)
+	close(stop)
+	wg.Wait()
+}
+
 func BenchmarkPingPongHog(b *testing.B) {
 	if b.N == 0 {
 		return
@@ -630,6 +1947,20 @@ func benchmarkCreateGoroutines(b *testing.B, procs int) {
 	}
 }
 
+// BenchmarkCreateGoroutinesRunParallel creates goroutines from every
+// P at once, so the Ps compete for batches of goroutine IDs.
+func BenchmarkCreateGoroutinesRunParallel(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		c := make(chan bool)
+		for pb.Next() {
+			go func() {
+				c <- true
+			}()
+			<-c
+		}
+	})
+}
+
 func BenchmarkCreateGoroutinesCapture(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
@@ -730,3 +2061,32 @@ func TestStealOrder(t *testing.T) {
 	runtime.RunStealOrderTest()
 }
 */
+
+func TestMutexProfileRuntimeLock(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	defer runtime.SetMutexProfileFraction(runtime.SetMutexProfileFraction(1))
+	runtime.ContendRuntimeLock(4, 100)
+
+	var records []runtime.BlockProfileRecord
+	n, ok := runtime.MutexProfile(nil)
+	for !ok {
+		records = make([]runtime.BlockProfileRecord, n+10)
+		n, ok = runtime.MutexProfile(records)
+	}
+	for _, r := range records[:n] {
+		frames := runtime.CallersFrames(r.Stack())
+		for {
+			frame, more := frames.Next()
+			if strings.Contains(frame.Function, "ContendRuntimeLock") {
+				if r.Count <= 0 || r.Cycles <= 0 {
+					t.Errorf("bad mutex profile record for a runtime lock: count %d, cycles %d", r.Count, r.Cycles)
+				}
+				return
+			}
+			if !more {
+				break
+			}
+		}
+	}
+	t.Error("no mutex profile record charged to the goroutines contending a runtime lock")
+}
@@ -338,20 +338,30 @@ type dbgVar struct {
 // definition.
 type debugVars struct {
 	allocfreetrace    int32
+	asyncfinalizers   int32
+	cgocallers        int32
 	cgocheck          int32
 	efence            int32
 	gccheckmark       int32
+	gcpacerate        int32
 	gcpacertrace      int32
 	gcshrinkstackoff  int32
 	gcstackbarrieroff int32
 	gcstackbarrierall int32
 	gcstoptheworld    int32
 	gctrace           int32
+	gfreecap          int32
 	invalidptr        int32
+	mcachehot         int32
+	poisonfree        int32
+	runsince          int32
 	sbrk              int32
 	scavenge          int32
+	schedcheck        int32
 	scheddetail       int32
+	schedfairness     int32
 	schedtrace        int32
+	syscallstall      int32
 	wbshadow          int32
 }
 
@@ -363,27 +373,41 @@ func runtime_setdebug(*debugVars)
 
 var dbgvars = []dbgVar{
 	{"allocfreetrace", &debug.allocfreetrace},
+	{"asyncfinalizers", &debug.asyncfinalizers},
+	{"cgocallers", &debug.cgocallers},
 	{"cgocheck", &debug.cgocheck},
 	{"efence", &debug.efence},
 	{"gccheckmark", &debug.gccheckmark},
+	{"gcpacerate", &debug.gcpacerate},
 	{"gcpacertrace", &debug.gcpacertrace},
 	{"gcshrinkstackoff", &debug.gcshrinkstackoff},
 	{"gcstackbarrieroff", &debug.gcstackbarrieroff},
 	{"gcstackbarrierall", &debug.gcstackbarrierall},
 	{"gcstoptheworld", &debug.gcstoptheworld},
 	{"gctrace", &debug.gctrace},
+	{"gfreecap", &debug.gfreecap},
 	{"invalidptr", &debug.invalidptr},
+	{"mcachehot", &debug.mcachehot},
+	{"poisonfree", &debug.poisonfree},
+	{"runsince", &debug.runsince},
 	{"sbrk", &debug.sbrk},
 	{"scavenge", &debug.scavenge},
+	{"schedcheck", &debug.schedcheck},
 	{"scheddetail", &debug.scheddetail},
+	{"schedfairness", &debug.schedfairness},
 	{"schedtrace", &debug.schedtrace},
+	{"syscallstall", &debug.syscallstall},
 	{"wbshadow", &debug.wbshadow},
 }
 
 func parsedebugvars() {
 	// defaults
 	debug.cgocheck = 1
+	debug.gcpacerate = 1
+	debug.gfreecap = 64
 	debug.invalidptr = 1
+	debug.mcachehot = 8
+	debug.schedfairness = 61
 
 	for p := gogetenv("GODEBUG"); p != ""; {
 		field := ""
@@ -497,12 +521,60 @@ func acquirem() *m {
 func releasem(mp *m) {
 	// _g_ := getg()
 	mp.locks--
+	// gccgo has no stack-check preemption: a request that arrived
+	// while locks were held stays in g.preempt and is honored at
+	// the next preemption point (runtime_checkpreempt).
 	// if mp.locks == 0 && _g_.preempt {
 	//	// restore the preemption request in case we've cleared it in newstack
 	//	_g_.stackguard0 = stackPreempt
 	// }
 }
 
+// Implemented in proc.c.
+func checkpreempt()
+
+// preemptionPoint yields the processor if the scheduler has asked
+// the current goroutine to, either because it has run for a long
+// time or because the world is being stopped. Goroutines are only
+// preempted when they block or call into the scheduler, so code that
+// can loop for a long time without doing either, such as an
+// interpreter's dispatch loop or generated code, should call this
+// from time to time. When no request is pending it costs a load and
+// a branch.
+//go:nosplit
+func preemptionPoint() {
+	if getg().preempt {
+		checkpreempt()
+	}
+}
+
+// mState is a snapshot of an M's scheduling and allocation state,
+// as returned by getMState.
+type mState struct {
+	spinning   bool
+	blocked    bool
+	inwb       bool
+	mallocing  int32
+	locks      int32
+	preemptoff string
+}
+
+// getMState returns the state of the calling M. It is for runtime
+// self-tests and diagnostics, to check invariants such as no GC
+// while mallocing and no rescheduling while locks > 0.
+//go:nosplit
+func getMState() mState {
+	mp := getg().m
+	return mState{
+		spinning:   mp.spinning,
+		blocked:    mp.blocked,
+		inwb:       mp.inwb,
+		mallocing:  mp.mallocing,
+		locks:      mp.locks,
+		preemptoff: mp.preemptoff,
+	}
+}
+
 //go:nosplit
 func gomcache() *mcache {
 	return getg().m.mcache
@@ -231,9 +231,11 @@ func (mp *muintptr) set(m *m) { *mp = muintptr(unsafe.Pointer(m)) }
 //
 // sudogs are allocated from a special pool. Use acquireSudog and
 // releaseSudog to allocate and free them.
-/*
-Commented out for gccgo for now.
-
+//
+// In gccgo this is the SudoG of the C code in chan.goc and sema.goc,
+// and the pool is runtime_acquireSudog and runtime_releaseSudog in
+// proc.c. gccgo does not shrink stacks, so nothing relies on the
+// hchan.lock rule below yet.
 type sudog struct {
 	// The following fields are protected by the hchan.lock of the
 	// channel this sudog is blocking on. shrinkstack depends on
@@ -250,10 +252,9 @@ type sudog struct {
 
 	releasetime int64
 	ticket      uint32
-	waitlink    *sudog // g.waiting list
-	c           *hchan // channel
+	waitlink    *sudog         // g.waiting list
+	c           unsafe.Pointer // channel; a *Hchan in chan.h
 }
-*/
 
 type gcstats struct {
 	// the struct must consist of only uint64's,
@@ -336,6 +337,7 @@ type g struct {
 	// Not for gccgo: stackLock      uint32 // sigprof/scang lock; TODO: fold in to atomicstatus
 	goid           int64
 	waitsince      int64  // approx time when the g become blocked
+	runsince       int64  // time when the g became runnable or started running, if GODEBUG=runsince=1
 	waitreason     string // if status==Gwaiting
 	schedlink      guintptr
 	preempt        bool     // preemption signal, duplicates stackguard0 = stackpreempt
@@ -403,6 +405,7 @@ type g struct {
 
 	issystem     bool // do not output in stack dump
 	isbackground bool // ignore in deadlock detector
+	admitted     bool // counts against the goroutine limit; see setMaxGoroutines
 
 	traceback *traceback // stack traceback buffer
 
@@ -430,7 +433,7 @@ type m struct {
 	throwing    int32
 	preemptoff  string // if != "", keep curg running on this m
 	locks       int32
-	softfloat   int32
+	softfloat   int32 // always 0 for gccgo: soft-float targets use libgcc, not runtime emulation
 	dying       int32
 	profilehz   int32
 	helpgc      int32
@@ -444,6 +447,10 @@ type m struct {
 	ncgo        int32  // number of cgo calls currently in progress
 	// Not for gccgo: cgoCallersUse uint32      // if non-zero, cgoCallers in use temporarily
 	// Not for gccgo: cgoCallers    *cgoCallers // cgo traceback if crashing in cgo call
+	cgocallers  [32]uintptr // Go callers of the outermost cgo call, if GODEBUG=cgocallers=1
+	ncgocallers int32
+	cgoctxt     [16]uintptr // SetCgoTraceback contexts of calls from C to Go, innermost last
+	ncgoctxt    int32       // number of calls from C to Go in progress
 	park        note
 	alllink     *m // on allm
 	schedlink   muintptr
@@ -481,11 +488,35 @@ type m struct {
 	gsignalstack     unsafe.Pointer // stack for gsignal
 	gsignalstacksize uintptr
 
+	// Signal stack of a C thread, replaced by minit when
+	// replacedSigstack is set and put back by unminit.
+	replacedSigstack bool // minit replaced a signal stack set up by C
+	oldsigstack      unsafe.Pointer
+	oldsigstacksize  uintptr
+
 	dropextram bool // drop after call is done
 
+	// An M whose thread is exiting after mexit is on sched.freem
+	// until its thread clears freeWait, as the M is no longer on
+	// allm but the thread still uses it.
+	freeWait uint32 // if == 0, the thread is done with the M (atomic)
+	freelink *m     // on sched.freem
+
 	gcing int32
 
 	cgomal *cgoMal // allocations via _cgo_allocate
+
+	syscallwhen int64   // nanotime at entersyscall, if GODEBUG=syscallstall is set (atomic)
+	syscallpc   uintptr // caller of entersyscall, if GODEBUG=syscallstall is set
+
+	syscallreported int64 // syscallwhen of the last stall sysmon reported; sysmon only
+
+	// A wait for a runtime lock that lock sampled for the mutex
+	// profile. Recording it takes the profiling lock, so unlock
+	// does that once the M holds no locks.
+	lockwaitcycles int64 // cycles waited, or 0 if none
+	lockwaitstack  [32]location
+	nlockwaitstack int32
 }
 
 type p struct {
@@ -500,10 +531,12 @@ type p struct {
 	mcache      *mcache
 	// Not for gccgo: racectx     uintptr
 
-	// Not for gccgo yet: deferpool    [5][]*_defer // pool of available defer structs of different sizes (see panic.go)
-	// Not for gccgo yet: deferpoolbuf [5][32]*_defer
-	// Temporary gccgo type for deferpool field.
-	deferpool *_defer
+	// Not for gccgo: deferpool    [5][]*_defer // pool of available defer structs of different sizes (see panic.go)
+	// Not for gccgo: deferpoolbuf [5][32]*_defer
+	// A gccgo defer record does not hold the deferred call's
+	// arguments, so all records have the same size and share a pool.
+	deferpool    []*_defer // pool of available defer structs (see panic.c)
+	deferpoolbuf [32]*_defer
 
 	// Cache of goroutine ids, amortizes accesses to runtime·sched.goidgen.
 	goidcache    uint64
@@ -528,12 +561,12 @@ type p struct {
 	gfree    *g
 	gfreecnt int32
 
-	// Not for gccgo for now: sudogcache []*sudog
-	// Not for gccgo for now: sudogbuf   [128]*sudog
+	sudogcache []*sudog
+	sudogbuf   [128]*sudog
 
-	// Not for gccgo for now: tracebuf traceBufPtr
+	tracebuf uintptr // *TraceBuf from trace.c; batched execution trace events
 
-	// Not for gccgo for now: palloc persistentAlloc // per-P to avoid mutex
+	palloc persistentAlloc // per-P to avoid mutex
 
 	// Per-P GC state
 	// Not for gccgo for now: gcAssistTime     int64 // Nanoseconds in assistAlloc
@@ -740,6 +773,9 @@ type _defer struct {
 	// Set to true if this defer stack entry is not part of the
 	// defer pool.
 	special bool
+
+	// The panic that is running this deferred function, if any.
+	panicking *_panic
 }
 
 // panics
@@ -754,6 +790,10 @@ type _panic struct {
 	// Whether this panic has been recovered.
 	recovered bool
 
+	// Whether this panic was aborted because one of the deferred
+	// functions it was running started a new panic.
+	aborted bool
+
 	// Whether this panic was pushed on the stack because of an
 	// exception thrown in some other language.
 	isforeign bool
@@ -834,6 +874,13 @@ type cgoMal struct {
 	alloc unsafe.Pointer
 }
 
+// persistentAlloc is a chunk of memory being carved up by
+// runtime_persistentalloc in malloc.goc.
+type persistentAlloc struct {
+	base unsafe.Pointer
+	off  uintptr
+}
+
 // sigset is the Go version of the C type sigset_t.
 // _sigset_t is defined by the Makefile from <signal.h>.
 type sigset _sigset_t
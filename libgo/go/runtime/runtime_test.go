@@ -6,9 +6,12 @@ package runtime_test
 
 import (
 	"io"
+	"reflect"
 	. "runtime"
 	"runtime/debug"
+	"syscall"
 	"testing"
+	"time"
 	"unsafe"
 )
 
@@ -90,6 +93,23 @@ func BenchmarkDeferMany(b *testing.B) {
 	}
 }
 
+// BenchmarkDeferDeep keeps more defers live than a per-P pool holds,
+// so records move through the central pool as well.
+func BenchmarkDeferDeep(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		deferDeep(64)
+	}
+}
+
+func deferDeep(n int) {
+	if n == 0 {
+		return
+	}
+	defer func() {}()
+	deferDeep(n - 1)
+}
+
 // golang.org/issue/7063
 func TestStopCPUProfilingWithProfilerOff(t *testing.T) {
 	SetCPUProfileRate(0)
@@ -164,6 +184,190 @@ func testSetPanicOnFault(t *testing.T, addr uintptr, nfault *int) {
 	t.Logf("addr %#x: %#x\n", addr, v)
 }
 
+// TestSetPanicOnFaultAddr checks that a fault on a guard page, with
+// SetPanicOnFault enabled, panics with a runtime.Error whose Addr
+// method reports the faulting address.
+func TestSetPanicOnFaultAddr(t *testing.T) {
+	size := syscall.Getpagesize()
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_NONE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		t.Skipf("mmap: %v", err)
+	}
+	defer syscall.Munmap(mem)
+
+	old := debug.SetPanicOnFault(true)
+	defer debug.SetPanicOnFault(old)
+
+	addr := uintptr(unsafe.Pointer(&mem[0])) + 8
+	defer func() {
+		err := recover()
+		if err == nil {
+			t.Fatalf("read of guard page did not panic")
+		}
+		if _, ok := err.(Error); !ok {
+			t.Fatalf("panic value %T is not a runtime.Error", err)
+		}
+		ae, ok := err.(interface {
+			Addr() uintptr
+		})
+		if !ok {
+			t.Fatalf("panic value %T has no Addr method", err)
+		}
+		if got := ae.Addr(); got != addr {
+			t.Errorf("Addr() = %#x, want %#x", got, addr)
+		}
+	}()
+	v := *(*byte)(unsafe.Pointer(addr))
+	t.Errorf("read of guard page returned %#x", v)
+}
+
+var divideZero int
+
+func testDivideByZero(t *testing.T, name string, f func()) {
+	defer func() {
+		err := recover()
+		if err == nil {
+			t.Errorf("%s: no panic", name)
+			return
+		}
+		re, ok := err.(Error)
+		if !ok {
+			t.Errorf("%s: panic value %T is not a runtime.Error", name, err)
+			return
+		}
+		if got, want := re.Error(), "runtime error: integer divide by zero"; got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}()
+	f()
+}
+
+func TestDivideByZero(t *testing.T) {
+	var sink int64
+	testDivideByZero(t, "int", func() { sink = int64(1 / divideZero) })
+	testDivideByZero(t, "int8", func() { sink = int64(int8(1) / int8(divideZero)) })
+	testDivideByZero(t, "int64", func() { sink = int64(1) % int64(divideZero) })
+	testDivideByZero(t, "uint32", func() { sink = int64(uint32(1) / uint32(divideZero)) })
+	testDivideByZero(t, "uint64", func() { sink = int64(uint64(1) % uint64(divideZero)) })
+	_ = sink
+}
+
+var indexOutOfRange = []int{5, -1}
+
+// testRuntimeError calls f, which must panic with a runtime.Error
+// whose message is want, and returns the panic value. name identifies
+// the case in failure messages.
+func testRuntimeError(t *testing.T, name, want string, f func()) (err interface{}) {
+	defer func() {
+		err = recover()
+		if err == nil {
+			t.Errorf("%s: no panic", name)
+			return
+		}
+		re, ok := err.(Error)
+		if !ok {
+			t.Errorf("%s: panic value %T is not a runtime.Error", name, err)
+			return
+		}
+		if got := re.Error(); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}()
+	f()
+	return nil
+}
+
+func TestIndexOutOfRange(t *testing.T) {
+	s := []int{1, 2, 3}
+	var a [4]byte
+	str := "hello"
+	i, neg := indexOutOfRange[0], indexOutOfRange[1]
+	u := uint64(1) << 63
+	var sink int
+
+	testRuntimeError(t, "s[i]", "runtime error: index out of range [5] with length 3", func() { sink = s[i] })
+	testRuntimeError(t, "s[neg]", "runtime error: index out of range [-1] with length 3", func() { s[neg] = 0 })
+	testRuntimeError(t, "a[i]", "runtime error: index out of range [5] with length 4", func() { sink = int(a[i]) })
+	testRuntimeError(t, "str[i]", "runtime error: index out of range [5] with length 5", func() { sink = int(str[i]) })
+	testRuntimeError(t, "s[u]", "runtime error: index out of range [9223372036854775808] with length 3", func() { sink = s[u] })
+	_ = sink
+}
+
+type nilDerefSmall struct {
+	a, b int
+}
+
+type nilDerefLarge struct {
+	pad [1 << 16]byte
+	x   int
+}
+
+func TestNilDeref(t *testing.T) {
+	const nilDeref = "runtime error: invalid memory address or nil pointer dereference"
+	var small *nilDerefSmall
+	var large *nilDerefLarge
+	var sink int
+
+	testRuntimeError(t, "read field", nilDeref, func() { sink = small.b })
+	testRuntimeError(t, "write field", nilDeref, func() { small.a = 1 })
+	// A field far from the start of the struct is checked by
+	// compiled code rather than by the signal handler.
+	testRuntimeError(t, "large struct", nilDeref, func() { sink = large.x })
+	_ = sink
+}
+
+// TestNilDerefDeferBlocks checks that the deferred calls run by a
+// nil dereference panic can block, and be scanned by the garbage
+// collector while they do, as they run on the goroutine stack.
+func TestNilDerefDeferBlocks(t *testing.T) {
+	var small *nilDerefSmall
+	ready := make(chan bool)
+	resume := make(chan bool)
+	done := make(chan interface{})
+	go func() {
+		defer func() {
+			ready <- true
+			<-resume
+			GC()
+			done <- recover()
+		}()
+		small.a = 1
+	}()
+	<-ready
+	GC()
+	resume <- true
+	if err := <-done; err == nil {
+		t.Fatal("nil dereference did not panic")
+	}
+}
+
+type typeAssertError struct{}
+
+func (typeAssertError) Error() string { return "typeAssertError" }
+
+func TestTypeAssertMessage(t *testing.T) {
+	var x interface{} = 1
+	var n interface{}
+	var e error = io.EOF
+	var sinkString string
+	var sinkError typeAssertError
+
+	for _, tc := range []struct {
+		name, want string
+		f          func()
+	}{
+		{"x.(string)", "interface conversion: interface {} is int, not string", func() { sinkString = x.(string) }},
+		{"n.(string)", "interface conversion: interface {} is nil, not string", func() { sinkString = n.(string) }},
+		{"e.(typeAssertError)", "interface conversion: error is *errors.errorString, not runtime_test.typeAssertError", func() { sinkError = e.(typeAssertError) }},
+	} {
+		err := testRuntimeError(t, tc.name, tc.want, tc.f)
+		if _, ok := err.(*TypeAssertionError); err != nil && !ok {
+			t.Errorf("%s: panic value %T is not a *runtime.TypeAssertionError", tc.name, err)
+		}
+	}
+	_, _ = sinkString, sinkError
+}
+
 func eqstring_generic(s1, s2 string) bool {
 	if len(s1) != len(s2) {
 		return false
@@ -331,3 +535,259 @@ func TestGoroutineProfileTrivial(t *testing.T) {
 		}
 	}
 }
+
+func chanReceiveWaits() map[int64]int64 {
+	m := make(map[int64]int64)
+	for _, w := range GoroutineWaits(false) {
+		if w.Reason == "chan receive" {
+			m[w.Goid] = w.BlockedNanos
+		}
+	}
+	return m
+}
+
+func TestGoroutineWaits(t *testing.T) {
+	before := chanReceiveWaits()
+
+	c := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		<-c
+		close(done)
+	}()
+	defer func() {
+		close(c)
+		<-done
+	}()
+
+	// Wait for the new goroutine to block.
+	var first map[int64]int64
+	for i := 0; ; i++ {
+		first = chanReceiveWaits()
+		for id := range before {
+			delete(first, id)
+		}
+		if len(first) > 0 {
+			break
+		}
+		if i > 1000 {
+			t.Fatal("blocked goroutine not reported")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for id, b := range first {
+		if b <= 0 {
+			t.Errorf("goroutine %d blocked for %d ns when first seen, want > 0", id, b)
+		}
+	}
+
+	const d = 50 * time.Millisecond
+	time.Sleep(d)
+	second := chanReceiveWaits()
+	for id, b1 := range first {
+		if b2, ok := second[id]; ok && b2 >= b1+int64(d) {
+			return
+		}
+	}
+	t.Errorf("blocked time did not grow by %v: first %v, then %v", d, first, second)
+}
+
+func TestNoteTsleepgTimeout(t *testing.T) {
+	var n Note
+	NoteClear(&n)
+	start := time.Now()
+	if r := NoteTsleepgResult(&n, 10e6); r != NoteResultTimeout {
+		t.Fatalf("NoteTsleepgResult = %d, want %d", r, NoteResultTimeout)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("NoteTsleepgResult returned after %v, want at least 10ms", d)
+	}
+	// A wakeup after the deadline is still allowed.
+	NoteWakeup(&n)
+}
+
+func TestNoteTsleepgWakeup(t *testing.T) {
+	var n Note
+	NoteClear(&n)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		NoteWakeup(&n)
+	}()
+	if r := NoteTsleepgResult(&n, 10e9); r != NoteResultWakeup {
+		t.Fatalf("NoteTsleepgResult = %d, want %d", r, NoteResultWakeup)
+	}
+	// Canceling after a wakeup does nothing.
+	if NoteCancel(&n) {
+		t.Error("NoteCancel after wakeup succeeded")
+	}
+	if r := NoteTsleepgResult(&n, 0); r != NoteResultWakeup {
+		t.Errorf("NoteTsleepgResult after NoteCancel = %d, want %d", r, NoteResultWakeup)
+	}
+}
+
+func TestNoteTsleepgCancel(t *testing.T) {
+	var n Note
+	NoteClear(&n)
+	done := make(chan bool)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		done <- NoteCancel(&n)
+	}()
+	if r := NoteTsleepgResult(&n, 10e9); r != NoteResultCanceled {
+		t.Fatalf("NoteTsleepgResult = %d, want %d", r, NoteResultCanceled)
+	}
+	if !<-done {
+		t.Error("NoteCancel reported that it did not cancel the wait")
+	}
+	if NoteCancel(&n) {
+		t.Error("second NoteCancel succeeded")
+	}
+	// A wakeup racing with the cancel must not be a double wakeup.
+	NoteWakeup(&n)
+}
+
+// multiReturnDefer has several return statements that all run the
+// same deferred call, which counts its runs in *n and sees the result
+// set by the return statement.
+func multiReturnDefer(i int, n *int) (r int) {
+	defer func() {
+		*n++
+		r *= 10
+	}()
+	if i == 0 {
+		return 1
+	}
+	if i == 1 {
+		return 2
+	}
+	for j := 0; j < i; j++ {
+		if j == 3 {
+			return 3
+		}
+	}
+	if i == 5 {
+		panic("multiReturnDefer")
+	}
+	return 4
+}
+
+func TestDeferMultipleReturns(t *testing.T) {
+	tests := []struct {
+		i, want int
+	}{
+		{0, 10},
+		{1, 20},
+		{2, 40},
+		{4, 30},
+	}
+	for k := 0; k < 3; k++ {
+		for _, test := range tests {
+			n := 0
+			if r := multiReturnDefer(test.i, &n); r != test.want {
+				t.Errorf("multiReturnDefer(%d) = %d, want %d", test.i, r, test.want)
+			}
+			if n != 1 {
+				t.Errorf("multiReturnDefer(%d) ran its defer %d times, want 1", test.i, n)
+			}
+		}
+	}
+
+	// Panicking through the function must run the defer once too.
+	n := 0
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("multiReturnDefer(5) did not panic")
+			}
+		}()
+		multiReturnDefer(5, &n)
+	}()
+	if n != 1 {
+		t.Errorf("multiReturnDefer(5) ran its defer %d times, want 1", n)
+	}
+}
+
+func deferLoopClosures() (byArg, byCopy, shared []int) {
+	defer func() {
+		// Reverse the slices so they are in loop order.
+		for _, s := range [][]int{byArg, byCopy, shared} {
+			for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+				s[i], s[j] = s[j], s[i]
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		defer func(i int) {
+			byArg = append(byArg, i)
+		}(i)
+		j := i
+		defer func() {
+			byCopy = append(byCopy, j)
+		}()
+		// All closures share the single loop variable i.
+		defer func() {
+			shared = append(shared, i)
+		}()
+	}
+	return
+}
+
+func TestDeferLoopClosures(t *testing.T) {
+	byArg, byCopy, shared := deferLoopClosures()
+	want := []int{0, 1, 2, 3}
+	if !reflect.DeepEqual(byArg, want) {
+		t.Errorf("closures given the loop variable as an argument saw %v, want %v", byArg, want)
+	}
+	if !reflect.DeepEqual(byCopy, want) {
+		t.Errorf("closures capturing a per-iteration copy saw %v, want %v", byCopy, want)
+	}
+	if want := []int{4, 4, 4, 4}; !reflect.DeepEqual(shared, want) {
+		t.Errorf("closures capturing the loop variable saw %v, want %v", shared, want)
+	}
+}
+
+func TestRecoverNoPanic(t *testing.T) {
+	if r := recover(); r != nil {
+		t.Errorf("recover outside a deferred call = %v, want nil", r)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("recover with no panic = %v, want nil", r)
+		}
+	}()
+}
+
+func recoverHelper() interface{} {
+	return recover()
+}
+
+func TestRecoverInHelper(t *testing.T) {
+	defer func() {
+		// The nested helper must not have stopped the panic.
+		if r := recover(); r != "helper" {
+			t.Errorf("recover after helper = %v, want %q", r, "helper")
+		}
+	}()
+	defer func() {
+		if r := recoverHelper(); r != nil {
+			t.Errorf("recover in a helper called by a deferred function = %v, want nil", r)
+		}
+	}()
+	panic("helper")
+}
+
+func TestRecoverInDefer(t *testing.T) {
+	recovered := false
+	func() {
+		defer func() {
+			if r := recover(); r != "direct" {
+				t.Errorf("recover in a deferred function = %v, want %q", r, "direct")
+			}
+			recovered = true
+		}()
+		panic("direct")
+	}()
+	if !recovered {
+		t.Error("deferred function did not run")
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package runtime
+
+// Functions in signal_unix.c.
+
+// sigblock blocks sig on the current thread and locks the calling
+// goroutine to that thread. The previous signal mask is stored in
+// *old, which must be passed to the matching sigunblock. Calls may
+// nest.
+func sigblock(sig uint32, old *sigset)
+
+// sigunblock restores the signal mask saved by sigblock and unlocks
+// the goroutine from its thread. Any signal that arrived while it was
+// blocked is delivered.
+func sigunblock(old *sigset)
+
+// raise sends sig to the current thread.
+func raise(sig int32)
@@ -275,17 +275,34 @@ const (
 	_MaxMem             = uintptr(1<<_MHeapMap_TotalBits - 1)
 )
 
+// Here for gccgo until we port malloc.go.
+const (
+	flagNoScan = 1 << 0 // GC doesn't have to scan object
+	flagNoZero = 1 << 3 // don't zero memory
+)
+
 // Here for gccgo until we port malloc.go.
 //extern runtime_mallocgc
 func c_mallocgc(size uintptr, typ uintptr, flag uint32) unsafe.Pointer
 func mallocgc(size uintptr, typ *_type, needzero bool) unsafe.Pointer {
 	flag := uint32(0)
+	if typ == nil || typ.kind&kindNoPointers != 0 {
+		flag |= flagNoScan
+	}
 	if !needzero {
-		flag = 1 << 3
+		flag |= flagNoZero
 	}
 	return c_mallocgc(size, uintptr(unsafe.Pointer(typ)), flag)
 }
 
+// rawbyteslice allocates a new byte slice that is not zeroed. It is
+// for callers that are about to overwrite every byte anyway.
+func rawbyteslice(size int) (b []byte) {
+	p := mallocgc(uintptr(size), nil, false)
+	*(*slice)(unsafe.Pointer(&b)) = slice{p, size, size}
+	return
+}
+
 // Here for gccgo unless and until we port string.go.
 func rawstring(size int) (p unsafe.Pointer, s string) {
 	p = mallocgc(uintptr(size), nil, false)
@@ -377,6 +394,10 @@ func casp(ptr *unsafe.Pointer, old, new unsafe.Pointer) bool {
 func lock(l *mutex)
 func unlock(l *mutex)
 
+// Here for gccgo until we port mprof.go.
+func lockwait(t0 int64)
+func lockwaitflush()
+
 // Here for gccgo for Solaris.
 func errno() int
 
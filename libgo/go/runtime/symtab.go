@@ -12,8 +12,10 @@ type Frames struct {
 	// The last PC we saw.
 	last uintptr
 
-	// The number of times we've seen last.
+	// The number of frames at last, counting calls inlined there,
+	// and the index of the next one to return.
 	lastCount int
+	lastIndex int
 }
 
 // Frame is the information returned by Frames for each call frame.
@@ -48,22 +50,27 @@ func CallersFrames(callers []uintptr) *Frames {
 // Next returns frame information for the next caller.
 // If more is false, there are no more callers (the Frame value is valid).
 func (ci *Frames) Next() (frame Frame, more bool) {
-	if len(ci.callers) == 0 {
-		return Frame{}, false
+	// Callers returns one PC for each real frame; the calls inlined
+	// at a PC are only found here, when it is symbolized. The stacks
+	// in the memory, block and goroutine profiles instead repeat the
+	// PC once for each call inlined there, so skip the copies of the
+	// PC that stand for the frames returned for it anyway.
+	if ci.lastIndex >= ci.lastCount {
+		if len(ci.callers) == 0 {
+			return Frame{}, false
+		}
+		ci.last = ci.callers[0]
+		ci.callers = ci.callers[1:]
+		ci.lastCount = funcframecount(ci.last)
+		ci.lastIndex = 0
+	} else if len(ci.callers) > 0 && ci.callers[0] == ci.last {
+		ci.callers = ci.callers[1:]
 	}
 
-	pc := ci.callers[0]
-	ci.callers = ci.callers[1:]
-
-	i := 0
-	if pc == ci.last {
-		ci.lastCount++
-		i = ci.lastCount
-	} else {
-		ci.last = pc
-		ci.lastCount = 0
-	}
-	more = len(ci.callers) > 0
+	pc := ci.last
+	i := ci.lastIndex
+	ci.lastIndex++
+	more = ci.lastIndex < ci.lastCount || len(ci.callers) > 0
 
 	f, file, line := funcframe(pc, i)
 	if f == nil {
@@ -127,3 +134,4 @@ func funcline_go(*Func, uintptr) (string, int)
 func funcname_go(*Func) string
 func funcentry_go(*Func) uintptr
 func funcframe(uintptr, int) (*Func, string, int)
+func funcframecount(uintptr) int
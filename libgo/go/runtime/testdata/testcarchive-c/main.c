@@ -0,0 +1,16 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include <stdio.h>
+
+#include "libtestcarchive.h"
+
+int main(void) {
+	if (AllocAndGC() != 0) {
+		fprintf(stderr, "FAIL\n");
+		return 1;
+	}
+	printf("OK\n");
+	return 0;
+}
@@ -0,0 +1,39 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "C"
+
+import (
+	"bytes"
+	"runtime"
+)
+
+var sink [][]byte
+
+// AllocAndGC is the first call made into Go by main.c. It allocates
+// heavily and forces a collection straight away, and then checks
+// that the runtime's background goroutines had already started.
+// It returns 0 on success.
+//export AllocAndGC
+func AllocAndGC() C.int {
+	for i := 0; i < 1000; i++ {
+		sink = append(sink, make([]byte, 64<<10))
+	}
+	sink = nil
+	runtime.GC()
+
+	// The scavenger is a system goroutine, so it only shows
+	// up with GOTRACEBACK=system.
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	if !bytes.Contains(buf, []byte("Scavenger")) {
+		println(string(buf))
+		return 1
+	}
+	return 0
+}
+
+func main() {}
@@ -11,6 +11,8 @@ import (
 
 func init() {
 	register("Crash", Crash)
+	register("ConcurrentPanics", ConcurrentPanics)
+	register("NilDeref", NilDeref)
 }
 
 func test(name string) {
@@ -43,3 +45,25 @@ func Crash() {
 	testInNewThread("second-new-thread")
 	test("main-again")
 }
+
+// ConcurrentPanics has several goroutines panic at about the same
+// time. Only one of them should get to print a crash report.
+func ConcurrentPanics() {
+	runtime.GOMAXPROCS(4)
+	start := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			<-start
+			panic(fmt.Sprintf("concurrent panic %d", i))
+		}(i)
+	}
+	close(start)
+	select {}
+}
+
+var nilDerefPtr *int
+
+// NilDeref dies of an unrecovered nil pointer dereference.
+func NilDeref() {
+	*nilDerefPtr = 1
+}
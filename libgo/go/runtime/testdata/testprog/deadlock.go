@@ -6,8 +6,10 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"runtime"
 	"runtime/debug"
+	"sync"
 	"time"
 )
 
@@ -16,13 +18,21 @@ func init() {
 	registerInit("NoHelperGoroutines", NoHelperGoroutines)
 
 	register("SimpleDeadlock", SimpleDeadlock)
+	register("BlockedDeadlock", BlockedDeadlock)
+	register("MainReturnWhileSleeping", MainReturnWhileSleeping)
+	register("ExitFromGoroutine", ExitFromGoroutine)
 	register("LockedDeadlock", LockedDeadlock)
 	register("LockedDeadlock2", LockedDeadlock2)
+	register("LockedIdleNoDeadlock", LockedIdleNoDeadlock)
 	register("GoexitDeadlock", GoexitDeadlock)
 	register("StackOverflow", StackOverflow)
+	register("StackOverflowGuard", StackOverflowGuard)
 	register("ThreadExhaustion", ThreadExhaustion)
+	register("ThreadExhaustionCreateStack", ThreadExhaustionCreateStack)
 	register("RecursivePanic", RecursivePanic)
 	register("GoexitExit", GoexitExit)
+	register("GoexitMainRunning", GoexitMainRunning)
+	register("GoexitMainBlocked", GoexitMainBlocked)
 	register("GoNil", GoNil)
 	register("MainGoroutineID", MainGoroutineID)
 	register("Breakpoint", Breakpoint)
@@ -32,6 +42,8 @@ func init() {
 	register("PanicTraceback", PanicTraceback)
 	register("GoschedInPanic", GoschedInPanic)
 	register("SyscallInPanic", SyscallInPanic)
+	register("DoublePanic", DoublePanic)
+	register("RecoveredDoublePanic", RecoveredDoublePanic)
 }
 
 func SimpleDeadlock() {
@@ -39,6 +51,43 @@ func SimpleDeadlock() {
 	panic("not reached")
 }
 
+// BlockedDeadlock blocks main and another goroutine on channels that
+// nothing will ever send on.
+func BlockedDeadlock() {
+	c1 := make(chan int)
+	c2 := make(chan int)
+	go func() {
+		<-c1
+	}()
+	<-c2
+	panic("not reached")
+}
+
+// MainReturnWhileSleeping returns from main while another goroutine
+// is sleeping; the program must exit without waiting for it.
+func MainReturnWhileSleeping() {
+	started := make(chan bool)
+	go func() {
+		started <- true
+		time.Sleep(time.Hour)
+		fmt.Println("goroutine woke up")
+	}()
+	<-started
+	fmt.Println("OK")
+}
+
+// ExitFromGoroutine calls os.Exit from a goroutine while main is
+// blocked; the program must exit at once with the given status.
+func ExitFromGoroutine() {
+	c := make(chan int)
+	go func() {
+		fmt.Println("exiting")
+		os.Exit(7)
+	}()
+	<-c
+	panic("not reached")
+}
+
 func InitDeadlock() {
 	select {}
 	panic("not reached")
@@ -58,6 +107,44 @@ func LockedDeadlock2() {
 	select {}
 }
 
+// LockedIdleNoDeadlock parks a locked goroutine, leaving its M idle
+// and locked, while unlocked goroutines keep making progress.
+func LockedIdleNoDeadlock() {
+	release := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		runtime.LockOSThread()
+		<-release
+		runtime.UnlockOSThread()
+		done <- true
+	}()
+	time.Sleep(time.Millisecond)
+
+	const workers = 4
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := make(chan int)
+			go func() {
+				for j := 0; j < 20; j++ {
+					time.Sleep(5 * time.Millisecond)
+					c <- j
+				}
+				close(c)
+			}()
+			for range c {
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	<-done
+	fmt.Println("OK")
+}
+
 func GoexitDeadlock() {
 	F := func() {
 		for i := 0; i < 10; i++ {
@@ -79,6 +166,23 @@ func StackOverflow() {
 	f()
 }
 
+// StackOverflowGuard recurses with small frames until the goroutine
+// runs into the guard page at the end of its fixed size stack.
+func StackOverflowGuard() {
+	var f func(int) int
+	f = func(n int) int {
+		var buf [64]byte
+		buf[n%len(buf)] = byte(n)
+		return int(buf[0]) + f(n+1)
+	}
+	done := make(chan bool)
+	go func() {
+		f(0)
+		done <- true
+	}()
+	<-done
+}
+
 func ThreadExhaustion() {
 	debug.SetMaxThreads(10)
 	c := make(chan int)
@@ -92,6 +196,23 @@ func ThreadExhaustion() {
 	}
 }
 
+// ThreadExhaustionCreateStack leaves a P idle so that starting each
+// goroutine creates a thread from this function, which should be
+// reported as the site creating the most threads.
+func ThreadExhaustionCreateStack() {
+	runtime.GOMAXPROCS(4)
+	debug.SetMaxThreads(10)
+	c := make(chan int)
+	for i := 0; i < 100; i++ {
+		go func() {
+			runtime.LockOSThread()
+			c <- 0
+			select {}
+		}()
+		<-c
+	}
+}
+
 func RecursivePanic() {
 	func() {
 		defer func() {
@@ -120,6 +241,38 @@ func GoexitExit() {
 	runtime.Goexit()
 }
 
+// GoexitMainRunning calls runtime.Goexit from main while another
+// goroutine is still working. Main's defers must run and the program
+// must stay alive until the other goroutine exits it.
+func GoexitMainRunning() {
+	done := make(chan bool)
+	go func() {
+		<-done
+		for i := 0; i < 100; i++ {
+			time.Sleep(time.Millisecond)
+			runtime.Gosched()
+		}
+		fmt.Println("goroutine done")
+		os.Exit(0)
+	}()
+	defer func() {
+		fmt.Println("main deferred")
+		close(done)
+	}()
+	runtime.Goexit()
+	panic("not reached")
+}
+
+// GoexitMainBlocked calls runtime.Goexit from main while the only
+// other goroutine is blocked forever, which is a deadlock.
+func GoexitMainBlocked() {
+	c := make(chan int)
+	go func() {
+		<-c
+	}()
+	runtime.Goexit()
+}
+
 func GoNil() {
 	defer func() {
 		recover()
@@ -197,6 +350,21 @@ func RecoveredPanicAfterGoexit() {
 	runtime.Goexit()
 }
 
+func DoublePanic() {
+	defer func() {
+		panic("B")
+	}()
+	panic("A")
+}
+
+func RecoveredDoublePanic() {
+	defer func() {
+		recover()
+		panic("B")
+	}()
+	panic("A")
+}
+
 func PanicTraceback() {
 	pt1()
 }
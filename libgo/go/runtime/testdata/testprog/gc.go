@@ -9,14 +9,19 @@ import (
 	"os"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
 func init() {
 	register("GCFairness", GCFairness)
 	register("GCFairness2", GCFairness2)
 	register("GCSys", GCSys)
+	register("AsyncFinalizers", AsyncFinalizers)
+	register("InvalidPointer", InvalidPointer)
+	register("PoisonFree", PoisonFree)
 }
 
 func GCSys() {
@@ -107,3 +112,109 @@ func GCFairness2() {
 	}
 	fmt.Println("OK")
 }
+
+type invalidPointerHolder struct {
+	p *int
+	n int
+}
+
+var invalidPointerSink *invalidPointerHolder
+
+// InvalidPointer stores the value 1 in a pointer field of a heap
+// object and runs a collection, which should notice it.
+func InvalidPointer() {
+	h := new(invalidPointerHolder)
+	*(*uintptr)(unsafe.Pointer(&h.p)) = 1
+	invalidPointerSink = h
+	runtime.GC()
+	invalidPointerSink = nil
+	fmt.Println("OK")
+}
+
+type poisonFreeHolder struct {
+	next *poisonFreeHolder
+	p    *int
+	q    *int
+}
+
+var poisonFreeSink *poisonFreeHolder
+
+// PoisonFree writes the GODEBUG=poisonfree pattern over an object that
+// is still reachable, as the collector does when it frees an object
+// that is in fact still in use, and runs a collection.
+func PoisonFree() {
+	h := new(poisonFreeHolder)
+	b := (*[unsafe.Sizeof(*h)]byte)(unsafe.Pointer(h))
+	for i := unsafe.Sizeof(h.next); i < uintptr(len(b)); i++ {
+		b[i] = 0xdb
+	}
+	poisonFreeSink = h
+	runtime.GC()
+	poisonFreeSink = nil
+	fmt.Println("OK")
+}
+
+type finalizerObj struct {
+	p *int
+	n [4]int
+}
+
+func setBlockingFinalizer(started chan<- bool) {
+	runtime.SetFinalizer(new(finalizerObj), func(*finalizerObj) {
+		select {
+		case started <- true:
+		default:
+		}
+		select {}
+	})
+}
+
+func setCountingFinalizer(ran *uint32) {
+	runtime.SetFinalizer(new(finalizerObj), func(*finalizerObj) {
+		atomic.AddUint32(ran, 1)
+	})
+}
+
+// waitGC runs the collector until done reports true, giving up after
+// ten seconds.
+func waitGC(done func() bool) bool {
+	for start := time.Now(); time.Since(start) < 10*time.Second; {
+		if done() {
+			return true
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return done()
+}
+
+// AsyncFinalizers checks that with GODEBUG=asyncfinalizers=1 a
+// finalizer that never returns does not stop the others from running.
+func AsyncFinalizers() {
+	started := make(chan bool, 1)
+	for i := 0; i < 10; i++ {
+		setBlockingFinalizer(started)
+	}
+	if !waitGC(func() bool { return len(started) > 0 }) {
+		fmt.Println("blocking finalizer did not run")
+		return
+	}
+
+	// The stuck finalizer's goroutine is not a system goroutine,
+	// so it shows up in a stack dump.
+	buf := make([]byte, 1<<20)
+	if !strings.Contains(string(buf[:runtime.Stack(buf, true)]), "[select (no cases)") {
+		fmt.Println("blocking finalizer missing from stack dump")
+		return
+	}
+
+	var ran uint32
+	for i := 0; i < 100; i++ {
+		setCountingFinalizer(&ran)
+	}
+	if !waitGC(func() bool { return atomic.LoadUint32(&ran) > 0 }) {
+		fmt.Println("finalizers blocked behind a blocking finalizer")
+		return
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,52 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+func init() {
+	register("LockOSThreadExit", LockOSThreadExit)
+}
+
+// LockOSThreadExit checks that a goroutine that exits while locked
+// to its thread takes the thread with it, and that the thread's M is
+// no longer listed by the thread-create profile.
+func LockOSThreadExit() {
+	const N = 10
+	before, _ := runtime.ThreadCreateProfile(nil)
+	tids := make(chan int)
+	for i := 0; i < N; i++ {
+		go func() {
+			runtime.LockOSThread()
+			tids <- syscall.Gettid()
+			runtime.Goexit()
+		}()
+		tid := <-tids
+		task := "/proc/self/task/" + strconv.Itoa(tid)
+		for j := 0; ; j++ {
+			if _, err := os.Stat(task); os.IsNotExist(err) {
+				break
+			}
+			if j > 500 {
+				println("thread", tid, "still running after its locked goroutine exited")
+				os.Exit(1)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// Each exit may start a new M to take over the P, but the
+	// exited ones must be gone.
+	if after, _ := runtime.ThreadCreateProfile(nil); after >= before+N {
+		println("thread-create profile grew from", before, "to", after, "Ms")
+		os.Exit(1)
+	}
+	println("OK")
+}
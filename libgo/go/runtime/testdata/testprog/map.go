@@ -0,0 +1,94 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func init() {
+	register("ConcurrentMapWrites", ConcurrentMapWrites)
+	register("ConcurrentMapReadWrite", ConcurrentMapReadWrite)
+	register("ConcurrentMapIterateWrite", ConcurrentMapIterateWrite)
+}
+
+// The map access detector only notices a conflict when the two
+// accesses actually overlap, so each of these keeps going for a
+// while before giving up.
+const concurrentMapIterations = 1e6
+
+func ConcurrentMapWrites() {
+	runtime.GOMAXPROCS(2)
+	m := map[int]int{}
+	c := make(chan struct{})
+	for _, k := range []int{5, 6} {
+		go func(k int) {
+			for i := 0; i < concurrentMapIterations; i++ {
+				m[k] = i
+				if i%100 == 0 {
+					runtime.Gosched()
+				}
+			}
+			c <- struct{}{}
+		}(k)
+	}
+	<-c
+	<-c
+	fmt.Println("no conflict detected")
+}
+
+func ConcurrentMapReadWrite() {
+	runtime.GOMAXPROCS(2)
+	m := map[int]int{}
+	c := make(chan struct{})
+	go func() {
+		for i := 0; i < concurrentMapIterations; i++ {
+			m[5] = i
+			if i%100 == 0 {
+				runtime.Gosched()
+			}
+		}
+		c <- struct{}{}
+	}()
+	go func() {
+		for i := 0; i < concurrentMapIterations; i++ {
+			_ = m[6]
+			if i%100 == 0 {
+				runtime.Gosched()
+			}
+		}
+		c <- struct{}{}
+	}()
+	<-c
+	<-c
+	fmt.Println("no conflict detected")
+}
+
+func ConcurrentMapIterateWrite() {
+	runtime.GOMAXPROCS(2)
+	m := map[int]int{}
+	c := make(chan struct{})
+	go func() {
+		for i := 0; i < concurrentMapIterations; i++ {
+			m[5] = i
+			if i%100 == 0 {
+				runtime.Gosched()
+			}
+		}
+		c <- struct{}{}
+	}()
+	go func() {
+		for i := 0; i < concurrentMapIterations/100; i++ {
+			for range m {
+			}
+			runtime.Gosched()
+		}
+		c <- struct{}{}
+	}()
+	<-c
+	<-c
+	fmt.Println("no conflict detected")
+}
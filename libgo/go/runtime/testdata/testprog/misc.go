@@ -4,12 +4,50 @@
 
 package main
 
-import "runtime"
+import (
+	"runtime"
+	"sync"
+	"time"
+)
 
 func init() {
 	register("NumGoroutine", NumGoroutine)
+	register("NumGoroutineSystem", NumGoroutineSystem)
+	register("PrintInterleave", PrintInterleave)
 }
 
 func NumGoroutine() {
 	println(runtime.NumGoroutine())
 }
+
+// NumGoroutineSystem starts the runtime's helper goroutines before
+// counting.
+func NumGoroutineSystem() {
+	// The timer goroutine.
+	time.Sleep(time.Millisecond)
+
+	// The finalizer goroutine, started by the first SetFinalizer,
+	// and the background sweeper, started by the first GC.
+	runtime.SetFinalizer(new([16]byte), func(*[16]byte) {})
+	runtime.GC()
+	runtime.Gosched()
+
+	println(runtime.NumGoroutine())
+}
+
+// PrintInterleave has several goroutines print multi-token lines
+// at the same time. Each line must come out whole.
+func PrintInterleave() {
+	runtime.GOMAXPROCS(4)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				println("start", i, "line", j, true, "end")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,40 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+func init() {
+	register("PanicError", PanicError)
+	register("PanicStringer", PanicStringer)
+	register("PanicErrorPanics", PanicErrorPanics)
+}
+
+// errorAndStringer implements both error and fmt.Stringer; a panic
+// prints the Error method's result.
+type errorAndStringer struct{}
+
+func (errorAndStringer) Error() string  { return "error value" }
+func (errorAndStringer) String() string { return "stringer value" }
+
+func PanicError() {
+	panic(errorAndStringer{})
+}
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer value" }
+
+func PanicStringer() {
+	panic(stringer{})
+}
+
+type panickyError struct{}
+
+func (panickyError) Error() string {
+	panic("Error method panicked")
+}
+
+func PanicErrorPanics() {
+	panic(panickyError{})
+}
@@ -7,12 +7,18 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"syscall"
 	"time"
+	"unsafe"
 )
 
 func init() {
 	register("SignalExitStatus", SignalExitStatus)
+	register("SignalGoroutine", SignalGoroutine)
+	register("SignalPipe", SignalPipe)
+	register("SignalPipeStdout", SignalPipeStdout)
 }
 
 func SignalExitStatus() {
@@ -27,3 +33,55 @@ func SignalExitStatus() {
 	// shouldn't matter--we'll never really sleep this long.
 	time.Sleep(time.Second)
 }
+
+// SignalGoroutine faults on an inaccessible page in a goroutine
+// other than the main one. The address is not near zero, so the
+// fault is fatal rather than a nil pointer panic.
+func SignalGoroutine() {
+	mem, err := syscall.Mmap(-1, 0, syscall.Getpagesize(), syscall.PROT_NONE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	p := (*int)(unsafe.Pointer(&mem[0]))
+	done := make(chan bool)
+	go func() {
+		*p = 1
+		done <- true
+	}()
+	<-done
+	fmt.Println("did not fault")
+}
+
+// SignalPipe writes to a pipe with no reader. The SIGPIPE must be
+// ignored and the write must fail with EPIPE.
+func SignalPipe() {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	r.Close()
+	errc := make(chan error)
+	go func() {
+		_, err := w.Write([]byte("x"))
+		errc <- err
+	}()
+	err = <-errc
+	if pe, ok := err.(*os.PathError); !ok || pe.Err != syscall.EPIPE {
+		fmt.Printf("write to broken pipe returned %v, want EPIPE\n", err)
+		return
+	}
+	fmt.Println("OK")
+}
+
+// SignalPipeStdout writes to standard output, which the caller has
+// connected to a pipe with no reader. The program must die of
+// SIGPIPE.
+func SignalPipeStdout() {
+	for i := 0; i < 100; i++ {
+		fmt.Println("x")
+		time.Sleep(10 * time.Millisecond)
+	}
+	fmt.Fprintln(os.Stderr, "write to broken stdout did not raise SIGPIPE")
+}
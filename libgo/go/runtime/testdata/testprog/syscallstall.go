@@ -0,0 +1,21 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !windows,!plan9,!nacl
+
+package main
+
+import "syscall"
+
+func init() {
+	register("SyscallStall", SyscallStall)
+}
+
+// SyscallStall sits in a non-blocking system call for long enough
+// that GODEBUG=syscallstall should report it.
+func SyscallStall() {
+	ts := syscall.NsecToTimespec(200 * 1000 * 1000)
+	syscall.Nanosleep(&ts, nil)
+	println("OK")
+}
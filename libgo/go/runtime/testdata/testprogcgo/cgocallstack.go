@@ -0,0 +1,51 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9,!windows
+
+package main
+
+// This program dumps the stack of a goroutine that is blocked in a C
+// function, to check that the dump shows the cgo call.
+
+/*
+#include <unistd.h>
+
+static void blockInC(void) {
+	for (;;)
+		sleep(1);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+func init() {
+	register("CgoCallStack", CgoCallStack)
+}
+
+func CgoCallStack() {
+	started := make(chan bool)
+	go cgoCallStack1(started)
+	<-started
+	// Give the goroutine time to enter the C function.
+	time.Sleep(100 * time.Millisecond)
+	buf := make([]byte, 1<<16)
+	fmt.Printf("%s\n", buf[:runtime.Stack(buf, true)])
+}
+
+func cgoCallStack1(started chan bool) {
+	cgoCallStack2(started)
+	panic("not reached")
+}
+
+func cgoCallStack2(started chan bool) {
+	started <- true
+	C.blockInC()
+	panic("not reached")
+}
@@ -0,0 +1,47 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// Test the cgo pointer passing checks.
+
+/*
+void cgoCheckUse(void* p) {}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func init() {
+	register("CgoCheckNested", CgoCheckNested)
+	register("CgoCheckFlat", CgoCheckFlat)
+}
+
+type cgoCheckNode struct {
+	p *int
+	n int
+}
+
+var cgoCheckSink *cgoCheckNode
+
+// CgoCheckNested passes C a pointer to Go memory that itself holds a
+// Go pointer, which the cgo checks must reject.
+func CgoCheckNested() {
+	cgoCheckSink = &cgoCheckNode{p: new(int)}
+	C.cgoCheckUse(unsafe.Pointer(cgoCheckSink))
+	fmt.Println("OK")
+}
+
+// CgoCheckFlat passes C pointers to Go memory that holds no Go
+// pointers, which is permitted.
+func CgoCheckFlat() {
+	cgoCheckSink = &cgoCheckNode{n: 1}
+	C.cgoCheckUse(unsafe.Pointer(cgoCheckSink))
+	b := make([]byte, 16)
+	C.cgoCheckUse(unsafe.Pointer(&b[0]))
+	fmt.Println("OK")
+}
@@ -0,0 +1,32 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This program will crash in C code, with no cgo traceback
+// functions registered. The C frames should still appear in the
+// stack trace.
+
+/*
+#cgo CFLAGS: -g -O0
+
+static char *crashCPointer;
+
+static void crashInCFunction(void) {
+	*crashCPointer = 0;
+}
+
+static void callCrashInCFunction(void) {
+	crashInCFunction();
+}
+*/
+import "C"
+
+func init() {
+	register("CrashInCFunction", CrashInCFunction)
+}
+
+func CrashInCFunction() {
+	C.callCrashInCFunction()
+}
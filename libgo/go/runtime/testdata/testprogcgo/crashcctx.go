@@ -0,0 +1,37 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// This program will crash in C code called from a Go function that
+// was itself called from C. The traceback function reports the
+// context recorded by the context function as the only C frame.
+
+/*
+// Defined in crashcctx_c.c.
+extern void crashCtxCallGo(void);
+extern void crashCtxCrash(void);
+extern void crashCtxContext(void*);
+extern void crashCtxTraceback(void*);
+*/
+import "C"
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+func init() {
+	register("CrashInCFunctionContext", CrashInCFunctionContext)
+}
+
+func CrashInCFunctionContext() {
+	runtime.SetCgoTraceback(0, unsafe.Pointer(C.crashCtxTraceback), unsafe.Pointer(C.crashCtxContext), nil)
+	C.crashCtxCallGo()
+}
+
+//export CrashCtxGo
+func CrashCtxGo() {
+	C.crashCtxCrash()
+}
@@ -0,0 +1,50 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The C definitions for crashcctx.go.
+
+#include <stdint.h>
+
+#include "_cgo_export.h"
+
+struct cgoContextArg {
+	uintptr_t context;
+};
+
+struct cgoTracebackArg {
+	uintptr_t  context;
+	uintptr_t  sigContext;
+	uintptr_t* buf;
+	uintptr_t  max;
+};
+
+static char * volatile crashCtxPointer;
+
+void crashCtxCallGo(void) {
+	CrashCtxGo();
+}
+
+void crashCtxCrash(void) {
+	*crashCtxPointer = 0;
+}
+
+// Record a fake context for each call from C to Go.
+void crashCtxContext(void* p) {
+	struct cgoContextArg* arg = (struct cgoContextArg*)(p);
+
+	if (arg->context == 0) {
+		arg->context = 0x1234;
+	}
+}
+
+// Report the context as the only C frame.
+void crashCtxTraceback(void* p) {
+	struct cgoTracebackArg* arg = (struct cgoTracebackArg*)(p);
+
+	if (arg->max < 2) {
+		return;
+	}
+	arg->buf[0] = arg->context;
+	arg->buf[1] = 0;
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9,!windows
+
+package main
+
+// Test that a cgo call that changes the signal mask does not break
+// Go's signal handling once it returns.
+
+/*
+#include <signal.h>
+#include <pthread.h>
+
+// Block SIGSEGV and report whether the block took effect.
+static int blockSIGSEGV(void) {
+	sigset_t set;
+
+	sigemptyset(&set);
+	sigaddset(&set, SIGSEGV);
+	pthread_sigmask(SIG_BLOCK, &set, NULL);
+	pthread_sigmask(SIG_SETMASK, NULL, &set);
+	return sigismember(&set, SIGSEGV);
+}
+
+static int isSIGSEGVBlocked(void) {
+	sigset_t set;
+
+	pthread_sigmask(SIG_SETMASK, NULL, &set);
+	return sigismember(&set, SIGSEGV);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func init() {
+	register("CgoSigmask", CgoSigmask)
+}
+
+var sigmaskNilPtr *int
+
+func sigmaskFault() (err interface{}) {
+	defer func() {
+		err = recover()
+	}()
+	*sigmaskNilPtr = 0
+	return nil
+}
+
+func CgoSigmask() {
+	// Stay on the thread whose mask the C code changes.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if C.blockSIGSEGV() == 0 {
+		fmt.Println("C code could not block SIGSEGV")
+		return
+	}
+	if C.isSIGSEGVBlocked() != 0 {
+		fmt.Println("SIGSEGV still blocked after returning to Go")
+		return
+	}
+	if err := sigmaskFault(); err == nil {
+		fmt.Println("nil dereference did not panic")
+		return
+	}
+	fmt.Println("OK")
+}
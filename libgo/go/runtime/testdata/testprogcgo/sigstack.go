@@ -0,0 +1,106 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !plan9,!windows
+
+// Test that a C thread with its own signal stack gets that stack
+// back after calling into Go, and can still handle signals on it.
+
+package main
+
+/*
+#include <signal.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+#include <pthread.h>
+
+extern void GoSigstackCallback(void);
+
+#define SIGSTACK_SIZE (64 * 1024)
+
+static char* sigstackBuf;
+static volatile uintptr_t sigstackHandlerSP;
+
+static void sigstackHandler(int sig __attribute__ ((unused))) {
+	char c;
+
+	sigstackHandlerSP = (uintptr_t)&c;
+}
+
+static const char* sigstackCheck(void) {
+	stack_t ss;
+	struct sigaction sa, osa;
+	uintptr_t lo, hi;
+
+	memset(&ss, 0, sizeof ss);
+	ss.ss_sp = sigstackBuf;
+	ss.ss_size = SIGSTACK_SIZE;
+	if (sigaltstack(&ss, NULL) < 0) {
+		return "sigaltstack failed";
+	}
+
+	GoSigstackCallback();
+
+	if (sigaltstack(NULL, &ss) < 0) {
+		return "sigaltstack failed";
+	}
+	if ((ss.ss_flags & SS_DISABLE) != 0 || ss.ss_sp != sigstackBuf) {
+		return "signal stack not restored after call to Go";
+	}
+
+	memset(&sa, 0, sizeof sa);
+	sa.sa_handler = sigstackHandler;
+	sa.sa_flags = SA_ONSTACK;
+	sigemptyset(&sa.sa_mask);
+	if (sigaction(SIGUSR2, &sa, &osa) < 0) {
+		return "sigaction failed";
+	}
+	pthread_kill(pthread_self(), SIGUSR2);
+	sigaction(SIGUSR2, &osa, NULL);
+
+	lo = (uintptr_t)sigstackBuf;
+	hi = lo + SIGSTACK_SIZE;
+	if (sigstackHandlerSP < lo || sigstackHandlerSP >= hi) {
+		return "signal not handled on the thread's signal stack";
+	}
+	return NULL;
+}
+
+static const char* sigstackErr;
+
+static void* sigstackThread(void* arg __attribute__ ((unused))) {
+	sigstackErr = sigstackCheck();
+	return NULL;
+}
+
+static const char* runSigstackThread(void) {
+	pthread_t tid;
+
+	sigstackBuf = malloc(SIGSTACK_SIZE);
+	pthread_create(&tid, NULL, sigstackThread, NULL);
+	pthread_join(tid, NULL);
+	free(sigstackBuf);
+	return sigstackErr;
+}
+*/
+import "C"
+
+import "fmt"
+
+func init() {
+	register("CgoSigstack", CgoSigstack)
+}
+
+//export GoSigstackCallback
+func GoSigstackCallback() {
+}
+
+func CgoSigstack() {
+	if msg := C.runSigstackThread(); msg != nil {
+		fmt.Println(C.GoString(msg))
+		return
+	}
+	fmt.Println("OK")
+}
@@ -0,0 +1,44 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Go execution tracer.
+// The tracer captures goroutine creation/blocking/unblocking and
+// syscall enter/exit/block events and writes them to a buffer in a
+// compact form. A precise timestamp is attached to each event.
+// See https://golang.org/s/go15trace for more info.
+//
+// The tracer itself is written in C, in libgo/runtime/trace.c.
+// Unlike the gc runtime, gccgo does not record stack traces,
+// garbage collection, heap or processor start/stop events,
+// and goroutines running cgo callbacks on threads not created
+// by Go are not traced.
+
+package runtime
+
+// StartTrace enables tracing for the current process.
+// While tracing, the data will be buffered and available via ReadTrace.
+// StartTrace returns an error if tracing is already enabled.
+// The data can be parsed with the internal/trace package.
+func StartTrace() error {
+	if !startTrace() {
+		return errorString("tracing is already enabled")
+	}
+	return nil
+}
+
+// startTrace stops the world, emits the initial state of every
+// goroutine and enables tracing. It reports false if tracing is
+// already enabled or still shutting down. Implemented in trace.c.
+func startTrace() bool
+
+// StopTrace stops tracing, if it was previously enabled.
+// StopTrace only returns after all the reads for the trace have completed.
+func StopTrace()
+
+// ReadTrace returns the next chunk of binary tracing data, blocking until data
+// is available. If tracing is turned off and all the data accumulated while it
+// was on has been returned, ReadTrace returns nil. The caller must copy the
+// returned data before calling ReadTrace again.
+// ReadTrace must be called from one goroutine at a time.
+func ReadTrace() []byte
@@ -0,0 +1,343 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime_test
+
+import (
+	"bytes"
+	"internal/trace"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordTrace runs f with tracing enabled and returns the parsed
+// trace.
+func recordTrace(t *testing.T, f func()) []*trace.Event {
+	if err := runtime.StartTrace(); err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	var buf bytes.Buffer
+	done := make(chan bool)
+	go func() {
+		for {
+			data := runtime.ReadTrace()
+			if data == nil {
+				break
+			}
+			buf.Write(data)
+		}
+		close(done)
+	}()
+	f()
+	runtime.StopTrace()
+	<-done
+
+	events, err := trace.Parse(&buf, "")
+	if err == trace.ErrTimeOrder {
+		t.Skipf("skipping trace: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("failed to parse trace: %v", err)
+	}
+	return events
+}
+
+func TestTraceGoroutineEvents(t *testing.T) {
+	id := make(chan int64, 1)
+	c := make(chan bool)
+	done := make(chan bool)
+	var gid int64
+	events := recordTrace(t, func() {
+		go func() {
+			id <- runtime.Goid()
+			<-c
+			// Long enough for the P to be retaken.
+			ts := syscall.NsecToTimespec(int64(50 * time.Millisecond))
+			syscall.Nanosleep(&ts, nil)
+			close(done)
+		}()
+		gid = <-id
+		// Wait for the goroutine to block in the receive.
+		for {
+			if _, ok := chanReceiveWaits()[gid]; ok {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		c <- true
+		<-done
+	})
+
+	var create, start, block, unblock, sys bool
+	for _, ev := range events {
+		switch ev.Type {
+		case trace.EvGoCreate:
+			create = create || ev.Args[0] == uint64(gid)
+		case trace.EvGoStart:
+			start = start || ev.G == uint64(gid)
+		case trace.EvGoBlockRecv:
+			block = block || ev.G == uint64(gid)
+		case trace.EvGoUnblock:
+			unblock = unblock || ev.Args[0] == uint64(gid)
+		case trace.EvGoSysCall:
+			sys = sys || ev.G == uint64(gid)
+		}
+	}
+	if !create {
+		t.Errorf("no GoCreate event for goroutine %d", gid)
+	}
+	if !start {
+		t.Errorf("no GoStart event for goroutine %d", gid)
+	}
+	if !block {
+		t.Errorf("no GoBlockRecv event for goroutine %d", gid)
+	}
+	if !unblock {
+		t.Errorf("no GoUnblock event for goroutine %d", gid)
+	}
+	if !sys {
+		t.Errorf("no GoSysCall event for goroutine %d", gid)
+	}
+}
+
+func TestStartTraceTwice(t *testing.T) {
+	if err := runtime.StartTrace(); err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	if err := runtime.StartTrace(); err == nil {
+		t.Errorf("StartTrace while tracing succeeded")
+	}
+	done := make(chan bool)
+	go func() {
+		for runtime.ReadTrace() != nil {
+		}
+		close(done)
+	}()
+	runtime.StopTrace()
+	<-done
+}
+
+// TestTraceGoroutineMigration checks that the P recorded for each
+// event of a goroutine woken alternately by goroutines on different
+// Ps is the P it started on, and that it is started exactly once per
+// wakeup, whether the start was traced as a P switch or not.
+func TestTraceGoroutineMigration(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	const N = 100
+	id := make(chan int64, 1)
+	c := make(chan bool)
+	done := make(chan bool)
+	var gid int64
+	events := recordTrace(t, func() {
+		go func() {
+			id <- runtime.Goid()
+			for i := 0; i < N; i++ {
+				<-c
+			}
+			close(done)
+		}()
+		gid = <-id
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < N/2; j++ {
+					c <- true
+				}
+			}()
+		}
+		wg.Wait()
+		<-done
+	})
+
+	p := -1
+	var starts, blocks, switches int
+	for _, ev := range events {
+		if ev.G != uint64(gid) {
+			continue
+		}
+		switch ev.Type {
+		case trace.EvGoStart:
+			starts++
+			if p != -1 && ev.P != p {
+				switches++
+			}
+			p = ev.P
+			continue
+		case trace.EvGoBlockRecv:
+			blocks++
+		}
+		if ev.P != p {
+			t.Errorf("%v event of goroutine %d on P %d, but it last started on P %d",
+				trace.EventDescriptions[ev.Type].Name, gid, ev.P, p)
+		}
+	}
+	if starts != blocks+1 {
+		t.Errorf("goroutine %d started %d times after blocking %d times, want %d starts",
+			gid, starts, blocks, blocks+1)
+	}
+	t.Logf("goroutine %d switched P %d times in %d starts", gid, switches, starts)
+}
+
+// TestTraceGoroutineSeq checks that the sequence numbers of the events
+// of a goroutine that blocks, sleeps and yields while migrating
+// between Ps count up by one from GoCreate, with no gaps or reuse.
+// The start and unblock events that were emitted on the same P as the
+// goroutine's previous event record no sequence number (the parser
+// leaves it 0), but still take one.
+func TestTraceGoroutineSeq(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	const N = 50
+	id := make(chan int64, 1)
+	c := make(chan bool)
+	done := make(chan bool)
+	var gid int64
+	events := recordTrace(t, func() {
+		go func() {
+			id <- runtime.Goid()
+			for i := 0; i < N; i++ {
+				<-c
+				time.Sleep(time.Microsecond)
+				runtime.Gosched()
+			}
+			close(done)
+		}()
+		gid = <-id
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < N/2; j++ {
+					c <- true
+				}
+			}()
+		}
+		wg.Wait()
+		<-done
+	})
+
+	var next, full uint64
+	created := false
+	for _, ev := range events {
+		var seq uint64
+		switch ev.Type {
+		case trace.EvGoCreate:
+			if ev.Args[0] == uint64(gid) {
+				created = true
+				next = 1
+			}
+			continue
+		case trace.EvGoStart:
+			if ev.G != uint64(gid) {
+				continue
+			}
+			seq = ev.Args[1]
+		case trace.EvGoUnblock:
+			if ev.Args[0] != uint64(gid) {
+				continue
+			}
+			seq = ev.Args[1]
+		case trace.EvGoSysExit:
+			// A GoSysExit parsed from a GoSysExitLocal has the
+			// timestamp where the sequence number would be.
+			if ev.Args[0] == uint64(gid) {
+				next++
+			}
+			continue
+		default:
+			continue
+		}
+		if !created {
+			t.Fatalf("%v event for goroutine %d before its GoCreate",
+				trace.EventDescriptions[ev.Type].Name, gid)
+		}
+		if seq != 0 {
+			full++
+			if seq != next {
+				t.Errorf("%v event for goroutine %d has seq %d, want %d",
+					trace.EventDescriptions[ev.Type].Name, gid, seq, next)
+			}
+		}
+		next++
+	}
+	if !created {
+		t.Fatalf("no GoCreate event for goroutine %d", gid)
+	}
+	t.Logf("goroutine %d: %d sequenced events, %d of them on a new P", gid, next-1, full)
+}
+
+// TestTraceManyEvents checks that no events are lost when goroutines
+// on several Ps fill many trace buffers, with GCs stopping the world
+// and flushing the buffers in between, and that the events of each
+// goroutine alternate between starting and yielding.
+func TestTraceManyEvents(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	const W, N = 8, 5000
+	ids := make(chan int64, W)
+	events := recordTrace(t, func() {
+		var wg sync.WaitGroup
+		for i := 0; i < W; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ids <- runtime.Goid()
+				for j := 0; j < N; j++ {
+					runtime.Gosched()
+				}
+			}()
+		}
+		for i := 0; i < 3; i++ {
+			runtime.GC()
+		}
+		wg.Wait()
+	})
+	close(ids)
+
+	running := make(map[uint64]bool)
+	scheds := make(map[uint64]int)
+	ended := make(map[uint64]bool)
+	for id := range ids {
+		running[uint64(id)] = false
+	}
+	for _, ev := range events {
+		r, ok := running[ev.G]
+		if !ok {
+			continue
+		}
+		name := trace.EventDescriptions[ev.Type].Name
+		if ended[ev.G] {
+			t.Fatalf("%v event of goroutine %d after it ended", name, ev.G)
+		}
+		switch ev.Type {
+		case trace.EvGoStart:
+			if r {
+				t.Fatalf("goroutine %d started while running", ev.G)
+			}
+			running[ev.G] = true
+		case trace.EvGoSched, trace.EvGoEnd:
+			if !r {
+				t.Fatalf("%v event of goroutine %d while not running", name, ev.G)
+			}
+			running[ev.G] = false
+			if ev.Type == trace.EvGoSched {
+				scheds[ev.G]++
+			} else {
+				ended[ev.G] = true
+			}
+		}
+	}
+	for g := range running {
+		if scheds[g] != N || !ended[g] {
+			t.Errorf("goroutine %d: %d GoSched events, ended %v; want %d, true", g, scheds[g], ended[g], N)
+		}
+	}
+}
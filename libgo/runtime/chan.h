@@ -3,23 +3,12 @@
 // license that can be found in the LICENSE file.
 
 typedef	struct	WaitQ	WaitQ;
-typedef	struct	SudoG	SudoG;
 typedef	struct	Select	Select;
 typedef	struct	Scase	Scase;
 
 typedef struct	__go_type_descriptor	Type;
 typedef struct	__go_channel_type	ChanType;
 
-struct	SudoG
-{
-	G*	g;
-	uint32*	selectdone;
-	SudoG*	link;
-	int64	releasetime;
-	byte*	elem;		// data element
-	uint32  ticket;
-};
-
 struct	WaitQ
 {
 	SudoG*	first;
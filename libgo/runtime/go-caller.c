@@ -117,6 +117,74 @@ __go_get_backtrace_state ()
   return back_state;
 }
 
+/* A cache of the results of __go_file_line.  Profiles and tracebacks
+   look up the same PCs over and over, and each lookup searches the
+   DWARF line tables.  A PC always maps to the same place, and the
+   strings belong to the backtrace state, which is never freed, so
+   entries never go stale.  The cache is set associative, replacing
+   the least recently used entry of a set.  */
+
+#define FILE_LINE_CACHE_SETS 256
+#define FILE_LINE_CACHE_WAYS 4
+
+struct file_line_entry
+{
+  uintptr pc;		/* 0 if the entry is unused.  */
+  int index;
+  uint32 used;		/* Value of the clock at the last use.  */
+  _Bool ok;
+  String fn;
+  String file;
+  intgo line;
+};
+
+static struct
+{
+  /* This is only ever tried, never waited for, so that a lookup from
+     a signal handler or while crashing can't deadlock.  If it is
+     held the lookup simply bypasses the cache.  */
+  uint32 lock;
+  uint32 clock;
+  _Bool disabled;
+  struct file_line_entry entries[FILE_LINE_CACHE_SETS][FILE_LINE_CACHE_WAYS];
+} file_line_cache;
+
+/* Look up PC and INDEX in the cache, which must be locked.  */
+
+static struct file_line_entry *
+file_line_cache_find (uintptr pc, int index)
+{
+  struct file_line_entry *set;
+  int i;
+
+  set = file_line_cache.entries[(pc ^ (pc >> 12)) % FILE_LINE_CACHE_SETS];
+  for (i = 0; i < FILE_LINE_CACHE_WAYS; i++)
+    if (set[i].pc == pc && set[i].index == index)
+      return &set[i];
+  return NULL;
+}
+
+/* Return the entry to replace for PC, which must be locked.  */
+
+static struct file_line_entry *
+file_line_cache_victim (uintptr pc)
+{
+  struct file_line_entry *set;
+  struct file_line_entry *victim;
+  int i;
+
+  set = file_line_cache.entries[(pc ^ (pc >> 12)) % FILE_LINE_CACHE_SETS];
+  victim = &set[0];
+  for (i = 0; i < FILE_LINE_CACHE_WAYS; i++)
+    {
+      if (set[i].pc == 0)
+	return &set[i];
+      if ((int32) (set[i].used - victim->used) < 0)
+	victim = &set[i];
+    }
+  return victim;
+}
+
 /* Return function/file/line information for PC.  The index parameter
    is the entry on the stack of inlined functions; -1 means the last
    one.  */
@@ -125,6 +193,26 @@ _Bool
 __go_file_line (uintptr pc, int index, String *fn, String *file, intgo *line)
 {
   struct caller c;
+  struct file_line_entry *e;
+  _Bool ok;
+
+  if (pc != 0 && runtime_cas (&file_line_cache.lock, 0, 1))
+    {
+      e = NULL;
+      if (!file_line_cache.disabled)
+	e = file_line_cache_find (pc, index);
+      if (e != NULL)
+	{
+	  e->used = ++file_line_cache.clock;
+	  *fn = e->fn;
+	  *file = e->file;
+	  *line = e->line;
+	  ok = e->ok;
+	  runtime_atomicstore (&file_line_cache.lock, 0);
+	  return ok;
+	}
+      runtime_atomicstore (&file_line_cache.lock, 0);
+    }
 
   runtime_memclr (&c, sizeof c);
   c.index = index;
@@ -133,9 +221,49 @@ __go_file_line (uintptr pc, int index, String *fn, String *file, intgo *line)
   *fn = c.fn;
   *file = c.file;
   *line = c.line;
+
+  if (pc != 0 && runtime_cas (&file_line_cache.lock, 0, 1))
+    {
+      if (!file_line_cache.disabled
+	  && file_line_cache_find (pc, index) == NULL)
+	{
+	  e = file_line_cache_victim (pc);
+	  e->pc = pc;
+	  e->index = index;
+	  e->used = ++file_line_cache.clock;
+	  e->ok = c.file.len > 0;
+	  e->fn = c.fn;
+	  e->file = c.file;
+	  e->line = c.line;
+	}
+      runtime_atomicstore (&file_line_cache.lock, 0);
+    }
+
   return c.file.len > 0;
 }
 
+/* For testing: set whether __go_file_line uses its cache, returning
+   whether it did.  Turning the cache off empties it.  */
+
+_Bool runtime_setfilelinecache (_Bool)
+  __asm__ (GOSYM_PREFIX "runtime.setfilelinecache");
+
+_Bool
+runtime_setfilelinecache (_Bool on)
+{
+  _Bool old;
+
+  while (!runtime_cas (&file_line_cache.lock, 0, 1))
+    runtime_osyield ();
+  old = !file_line_cache.disabled;
+  file_line_cache.disabled = !on;
+  if (!on)
+    runtime_memclr (&file_line_cache.entries[0][0],
+		    sizeof file_line_cache.entries);
+  runtime_atomicstore (&file_line_cache.lock, 0);
+  return old;
+}
+
 /* Collect symbol information.  */
 
 static void
@@ -264,6 +392,33 @@ runtime_funcentry_go (Func *f)
   return f->entry;
 }
 
+/* Count the frames for Frames.Next at PC: one for the function
+   containing PC, and one for each call inlined at PC.  */
+
+static int
+count_callback (void *data, uintptr_t pc __attribute__ ((unused)),
+		const char *filename __attribute__ ((unused)),
+		int lineno __attribute__ ((unused)),
+		const char *function __attribute__ ((unused)))
+{
+  ++*(intgo *) data;
+  return 0;
+}
+
+intgo
+runtime_funcframecount (uintptr pc)
+{
+  intgo n;
+
+  // Subtract 1 from PC to undo the 1 we added in go-callers.c.
+  n = 0;
+  backtrace_pcinfo (__go_get_backtrace_state (), pc - 1, count_callback,
+		    error_callback, &n);
+  if (n < 1)
+    n = 1;
+  return n;
+}
+
 /* Look up file and line information for Frames.Next.  */
 
 struct funcframe_return
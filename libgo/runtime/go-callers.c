@@ -29,54 +29,92 @@ struct callers_data
   int keep_thunks;
 };
 
-/* Callback function for backtrace_full.  Just collect the locations.
-   Return zero to continue, non-zero to stop.  */
+/* Report whether a frame in FUNCTION, from FILENAME if known, is
+   one to leave out of a traceback.  */
 
-static int
-callback (void *data, uintptr_t pc, const char *filename, int lineno,
-	  const char *function)
+static _Bool
+skip_frame (const char *function, const char *filename, int keep_thunks)
 {
-  struct callers_data *arg = (struct callers_data *) data;
-  Location *loc;
+  const char *p;
 
   /* Skip split stack functions.  */
   if (function != NULL)
     {
-      const char *p;
-
       p = function;
       if (__builtin_strncmp (p, "___", 3) == 0)
 	++p;
       if (__builtin_strncmp (p, "__morestack_", 12) == 0)
-	return 0;
+	return 1;
     }
   else if (filename != NULL)
     {
-      const char *p;
-
       p = strrchr (filename, '/');
       if (p == NULL)
 	p = filename;
       if (__builtin_strncmp (p, "/morestack.S", 12) == 0)
-	return 0;
+	return 1;
     }
 
   /* Skip thunks and recover functions.  There is no equivalent to
      these functions in the gc toolchain, so returning them here means
      significantly different results for runtime.Caller(N).  */
-  if (function != NULL && !arg->keep_thunks)
+  if (function != NULL && !keep_thunks)
     {
-      const char *p;
-
       p = __builtin_strchr (function, '.');
       if (p != NULL && __builtin_strncmp (p + 1, "$thunk", 6) == 0)
-	return 0;
+	return 1;
       p = __builtin_strrchr (function, '$');
       if (p != NULL && __builtin_strcmp(p, "$recover") == 0)
-	return 0;
+	return 1;
       if (p != NULL && __builtin_strncmp(p, "$stub", 5) == 0)
+	return 1;
+    }
+
+  return 0;
+}
+
+/* Report whether a frame in FUNCTION, from FILENAME if known, is the
+   last one worth reporting.  There is no point to tracing past
+   certain runtime functions.  Stopping the backtrace here can avoid
+   problems on systems that don't provide proper unwind information
+   for makecontext, such as Solaris (http://gcc.gnu.org/PR52583
+   comment #21).  Without a file name, as when only the symbol table
+   was consulted, the function name alone decides.  */
+
+static _Bool
+last_frame (const char *function, const char *filename)
+{
+  const char *p;
+
+  if (function == NULL)
+    return 0;
+  if (__builtin_strcmp (function, "makecontext") == 0)
+    return 1;
+  if (filename != NULL)
+    {
+      p = strrchr (filename, '/');
+      if (p == NULL)
+	p = filename;
+      if (__builtin_strcmp (p, "/proc.c") != 0)
 	return 0;
     }
+  return (__builtin_strcmp (function, "kickoff") == 0
+	  || __builtin_strcmp (function, "runtime_mstart") == 0
+	  || __builtin_strcmp (function, "runtime_main") == 0);
+}
+
+/* Callback function for backtrace_full.  Just collect the locations.
+   Return zero to continue, non-zero to stop.  */
+
+static int
+callback (void *data, uintptr_t pc, const char *filename, int lineno,
+	  const char *function)
+{
+  struct callers_data *arg = (struct callers_data *) data;
+  Location *loc;
+
+  if (skip_frame (function, filename, arg->keep_thunks))
+    return 0;
 
   if (arg->skip > 0)
     {
@@ -110,30 +148,8 @@ callback (void *data, uintptr_t pc, const char *filename, int lineno,
   loc->lineno = lineno;
   ++arg->index;
 
-  /* There is no point to tracing past certain runtime functions.
-     Stopping the backtrace here can avoid problems on systems that
-     don't provide proper unwind information for makecontext, such as
-     Solaris (http://gcc.gnu.org/PR52583 comment #21).  */
-  if (function != NULL)
-    {
-      if (__builtin_strcmp (function, "makecontext") == 0)
-	return 1;
-      if (filename != NULL)
-	{
-	  const char *p;
-
-	  p = strrchr (filename, '/');
-	  if (p == NULL)
-	    p = filename;
-	  if (__builtin_strcmp (p, "/proc.c") == 0)
-	    {
-	      if (__builtin_strcmp (function, "kickoff") == 0
-		  || __builtin_strcmp (function, "runtime_mstart") == 0
-		  || __builtin_strcmp (function, "runtime_main") == 0)
-		return 1;
-	    }
-	}
-    }
+  if (last_frame (function, filename))
+    return 1;
 
   return arg->index >= arg->max;
 }
@@ -173,26 +189,272 @@ runtime_callers (int32 skip, Location *locbuf, int32 m, bool keep_thunks)
   return data.index;
 }
 
-int Callers (int, struct __go_open_array)
-  __asm__ (GOSYM_PREFIX "runtime.Callers");
+/* Argument passed to pcs_callback.  */
+
+struct callers_pcs_data
+{
+  uintptr *pcs;
+  int skip;
+  int index;
+  int max;
+  const char *from;
+};
+
+/* Callback for backtrace_syminfo: record the symbol name.  */
+
+static void
+symname_callback (void *data, uintptr_t pc __attribute__ ((unused)),
+		  const char *symname,
+		  uintptr_t symval __attribute__ ((unused)),
+		  uintptr_t symsize __attribute__ ((unused)))
+{
+  *(const char **) data = symname;
+}
+
+/* Callback function for backtrace_simple.  Collect just the PC,
+   using the symbol table, which is much cheaper to search than the
+   line tables, to leave out the same frames as callback.  */
+
+static int
+pcs_callback (void *data, uintptr_t pc)
+{
+  struct callers_pcs_data *arg = (struct callers_pcs_data *) data;
+  const char *function;
+
+  function = NULL;
+  backtrace_syminfo (__go_get_backtrace_state (), pc, symname_callback,
+		     error_callback, &function);
+
+  if (skip_frame (function, NULL, 0))
+    return 0;
+
+  /* Skip frames up to and including the first one in FROM.  */
+  if (arg->from != NULL)
+    {
+      if (function != NULL && __builtin_strcmp (function, arg->from) == 0)
+	arg->from = NULL;
+      return 0;
+    }
+
+  /* Count skipped frames the way runtime_callers does, including the
+     calls inlined at PC, so that Callers(skip) agrees with
+     Caller(skip).  If the count ends among the calls inlined at PC,
+     the whole frame is kept.  */
+  if (arg->skip > 0)
+    {
+      int n;
+
+      n = runtime_funcframecount (pc + 1);
+      if (n <= arg->skip)
+	{
+	  arg->skip -= n;
+	  return 0;
+	}
+      arg->skip = 0;
+    }
+
+  /* As in callback, undo the decrement done by backtrace_simple.  */
+  arg->pcs[arg->index] = pc + 1;
+  ++arg->index;
+
+  if (last_frame (function, NULL))
+    return 1;
+
+  return arg->index >= arg->max;
+}
+
+/* Gather caller PC's without looking up their file and line.  Unlike
+   runtime_callers, this returns one PC for each real frame; calls
+   inlined at a PC are found when the PC is symbolized, by
+   runtime.CallersFrames.  */
+
+int32
+runtime_callerspcs (int32 skip, uintptr *pcs, int32 m)
+{
+  struct callers_pcs_data data;
+
+  data.pcs = pcs;
+  data.skip = skip + 1;
+  data.index = 0;
+  data.max = m;
+  data.from = NULL;
+  runtime_xadd (&runtime_in_callers, 1);
+  backtrace_simple (__go_get_backtrace_state (), 0, pcs_callback,
+		    error_callback, &data);
+  runtime_xadd (&runtime_in_callers, -1);
+  return data.index;
+}
+
+/* Like runtime_callerspcs, but starting with the caller of the
+   innermost active call to the C function FUNCTION.  Returns 0 if
+   FUNCTION is not on the stack.  */
+
+int32
+runtime_callerspcsfrom (const char *function, uintptr *pcs, int32 m)
+{
+  struct callers_pcs_data data;
+
+  data.pcs = pcs;
+  data.skip = 0;
+  data.index = 0;
+  data.max = m;
+  data.from = function;
+  runtime_xadd (&runtime_in_callers, 1);
+  backtrace_simple (__go_get_backtrace_state (), 0, pcs_callback,
+		    error_callback, &data);
+  runtime_xadd (&runtime_in_callers, -1);
+  return data.index;
+}
+
+/* Unwinding by following the frame pointer chain is much faster than
+   unwinding with the DWARF call frame information, which matters for
+   the memory and block profiles that gather a stack on every sampled
+   event.  It is only correct if every function on the stack keeps a
+   frame pointer, so it is off unless libgo is configured with
+   --enable-frame-pointer-unwind, which builds it with
+   -fno-omit-frame-pointer; the program must be built that way too.
+   Both amd64 and arm64 store the caller's frame pointer at the frame
+   pointer and the return address just above it.  */
+
+#if defined (GO_FRAME_POINTER_UNWIND) \
+  && (defined (__x86_64__) || defined (__aarch64__))
+#define USE_FP_UNWIND 1
+#else
+#define USE_FP_UNWIND 0
+#endif
+
+/* Whether runtime_callersfp uses frame pointers.  Tests turn this off
+   to compare against the DWARF unwinder.  */
+
+static _Bool fpunwind = USE_FP_UNWIND;
+
+#if USE_FP_UNWIND
+
+/* Walk the frame pointer chain starting with the caller of this
+   function, skipping SKIP frames and recording up to M return
+   addresses in LOCBUF.  The addresses are not looked up: the
+   profiles only keep the PC's, which are symbolized when the profile
+   is written.  The walk stops at the base of the stack or at a frame
+   pointer that does not lead further up the stack, which includes
+   the boundary of a split stack segment.  */
+
+static int32 fpcallers (int32, Location *, int32)
+  __attribute__ ((noinline));
+
+static int32
+fpcallers (int32 skip, Location *locbuf, int32 m)
+{
+  uintptr *fp;
+  uintptr *next;
+  uintptr pc;
+  int32 n;
+
+  n = 0;
+  fp = (uintptr *) __builtin_frame_address (0);
+  while (fp != NULL && n < m)
+    {
+      pc = fp[1];
+      if (pc == 0)
+	break;
+      if (skip > 0)
+	--skip;
+      else
+	{
+	  __builtin_memset (&locbuf[n], 0, sizeof locbuf[n]);
+	  locbuf[n].pc = pc;
+	  ++n;
+	}
+      next = (uintptr *) fp[0];
+      if (next <= fp
+	  || (uintptr) next - (uintptr) fp > (1 << 20)
+	  || ((uintptr) next & (sizeof (uintptr) - 1)) != 0)
+	break;
+      fp = next;
+    }
+  return n;
+}
+
+#endif /* USE_FP_UNWIND */
+
+/* Gather caller PC's like runtime_callers, using frame pointers if
+   they are available.  With frame pointers only the PC of each
+   Location is set, and there is one Location for each real frame.
+   This is not used for the CPU profile, because the frame pointer
+   chain does not describe the signal frame.  */
+
+int32
+runtime_callersfp (int32 skip, Location *locbuf, int32 m)
+{
+#if USE_FP_UNWIND
+  /* fpcallers starts with this function, so skip it.  */
+  if (fpunwind)
+    return fpcallers (skip + 1, locbuf, m);
+#endif
+  return runtime_callers (skip + 1, locbuf, m, false);
+}
+
+/* For testing: set whether runtime_callersfp uses frame pointers,
+   returning whether it did.  Frame pointers are never used if they
+   are not supported.  */
+
+_Bool runtime_setfpunwind (_Bool)
+  __asm__ (GOSYM_PREFIX "runtime.setfpunwind");
+
+_Bool
+runtime_setfpunwind (_Bool on)
+{
+  _Bool old;
+
+  old = fpunwind;
+  fpunwind = on && USE_FP_UNWIND;
+  return old;
+}
+
+/* For testing: like Callers, using runtime_callersfp.  */
+
+int callersfp (int, struct __go_open_array)
+  __asm__ (GOSYM_PREFIX "runtime.callersfp");
 
 int
-Callers (int skip, struct __go_open_array pc)
+callersfp (int skip, struct __go_open_array pc)
 {
   Location *locbuf;
   int ret;
   int i;
 
   locbuf = (Location *) runtime_mal (pc.__count * sizeof (Location));
+  ret = runtime_callersfp (skip, locbuf, pc.__count);
+  for (i = 0; i < ret; i++)
+    ((uintptr *) pc.__values)[i] = locbuf[i].pc;
+  return ret;
+}
+
+/* Implement panicCallers.  While a panic runs deferred functions,
+   the frames that called panic are still on the stack below
+   __go_panic, so they are only found when asked for, rather than
+   slowing down every panic.  */
+
+intgo panicpcs (struct __go_open_array)
+  __asm__ (GOSYM_PREFIX "runtime.panicpcs");
+
+intgo
+panicpcs (struct __go_open_array pc)
+{
+  if (pc.__count == 0)
+    return 0;
+  return runtime_callerspcsfrom ("__go_panic", (uintptr *) pc.__values,
+				 pc.__count);
+}
+
+int Callers (int, struct __go_open_array)
+  __asm__ (GOSYM_PREFIX "runtime.Callers");
 
+int
+Callers (int skip, struct __go_open_array pc)
+{
   /* In the Go 1 release runtime.Callers has an off-by-one error,
      which we can not correct because it would break backward
      compatibility.  Normally we would add 1 to SKIP here, but we
      don't so that we are compatible.  */
-  ret = runtime_callers (skip, locbuf, pc.__count, false);
-
-  for (i = 0; i < ret; i++)
-    ((uintptr *) pc.__values)[i] = locbuf[i].pc;
-
-  return ret;
+  return runtime_callerspcs (skip, (uintptr *) pc.__values, pc.__count);
 }
@@ -44,6 +44,15 @@ syscall_cgocall ()
 
   m = runtime_m ();
   ++m->ncgocall;
+  if (m->ncgo == 0)
+    {
+      /* Record the Go stack for tracebacks, which can't walk it while
+	 the goroutine is running C code.  */
+      m->ncgocallers = 0;
+      if (runtime_debug.cgocallers)
+	m->ncgocallers = runtime_callerspcs (1, &m->cgocallers[0],
+					     nelem (m->cgocallers));
+    }
   ++m->ncgo;
   runtime_entersyscall (0);
 }
@@ -54,6 +63,7 @@ void
 syscall_cgocalldone ()
 {
   G* g;
+  sigset_t cur;
 
   g = runtime_g ();
   __go_assert (g != NULL);
@@ -64,6 +74,19 @@ syscall_cgocalldone ()
 	 Let the garbage collector clean up any unreferenced
 	 memory.  */
       g->m->cgomal = NULL;
+      g->m->ncgocallers = 0;
+
+      /* Restore the Go signal mask, in case the C code changed it.
+	 Go relies on being able to receive signals such as SIGSEGV
+	 and SIGPROF.  m->sigmask is set up by minit and kept current
+	 by runtime_sigblock, so nothing needs saving on the way in,
+	 and the mask is only set when it actually differs.  Start
+	 from a copy so that any bits the kernel does not report
+	 compare equal.  */
+      cur = *(sigset_t *) &g->m->sigmask;
+      pthread_sigmask (SIG_SETMASK, NULL, &cur);
+      if (__builtin_memcmp (&cur, &g->m->sigmask, sizeof cur) != 0)
+	pthread_sigmask (SIG_SETMASK, (sigset_t *) &g->m->sigmask, NULL);
     }
 
   /* If we are invoked because the C function called _cgo_panic, then
@@ -74,6 +97,9 @@ syscall_cgocalldone ()
   runtime_unlockOSThread();
 }
 
+static void cgo_context_push (M *);
+static void cgo_context_pop (M *);
+
 /* Call back from C/C++ code to Go code.  */
 
 void
@@ -89,6 +115,8 @@ syscall_cgocallback ()
       mp->dropextram = true;
     }
 
+  cgo_context_push (mp);
+
   runtime_exitsyscall (0);
 
   if (runtime_m ()->ncgo == 0)
@@ -117,6 +145,7 @@ syscall_cgocallbackdone ()
 
   runtime_entersyscall (0);
   mp = runtime_m ();
+  cgo_context_pop (mp);
   if (mp->dropextram && mp->ncgo == 0)
     {
       mp->dropextram = false;
@@ -124,6 +153,199 @@ syscall_cgocallbackdone ()
     }
 }
 
+/* The functions registered by runtime.SetCgoTraceback.  */
+
+static void *cgo_traceback;
+static void *cgo_context;
+static void *cgo_symbolizer;
+
+/* Must match the C structs documented for runtime.SetCgoTraceback.  */
+
+struct cgo_traceback_arg
+{
+  uintptr context;
+  uintptr sig_context;
+  uintptr *buf;
+  uintptr max;
+};
+
+struct cgo_context_arg
+{
+  uintptr context;
+};
+
+struct cgo_symbolizer_arg
+{
+  uintptr pc;
+  const char *file;
+  uintptr lineno;
+  const char *func_name;
+  uintptr entry;
+  uintptr more;
+  uintptr data;
+};
+
+void SetCgoTraceback (intgo, void *, void *, void *)
+  __asm__ (GOSYM_PREFIX "runtime.SetCgoTraceback");
+
+void
+SetCgoTraceback (intgo version, void *traceback, void *context,
+		 void *symbolizer)
+{
+  if (version != 0)
+    runtime_panicstring ("unsupported version");
+
+  if ((cgo_traceback != NULL && cgo_traceback != traceback)
+      || (cgo_context != NULL && cgo_context != context)
+      || (cgo_symbolizer != NULL && cgo_symbolizer != symbolizer))
+    runtime_panicstring ("call SetCgoTraceback only once");
+
+  cgo_traceback = traceback;
+  cgo_context = context;
+  cgo_symbolizer = symbolizer;
+}
+
+/* Record the C context of a call from C to Go on MP, by calling the
+   context function.  A context is pushed for every call, 0 if there
+   is no context function, so that pushes and pops match even if
+   SetCgoTraceback is called in between.  */
+
+static void
+cgo_context_push (M *mp)
+{
+  struct cgo_context_arg arg;
+
+  arg.context = 0;
+  if (cgo_context != NULL)
+    ((void (*) (void *)) cgo_context) (&arg);
+  if (mp->ncgoctxt < (int32) nelem (mp->cgoctxt))
+    mp->cgoctxt[mp->ncgoctxt] = arg.context;
+  else if (arg.context != 0)
+    {
+      /* Too deeply nested to remember; release it now.  */
+      ((void (*) (void *)) cgo_context) (&arg);
+    }
+  mp->ncgoctxt++;
+}
+
+/* Release the context recorded by the matching cgo_context_push, as
+   the call from C to Go returns.  */
+
+static void
+cgo_context_pop (M *mp)
+{
+  struct cgo_context_arg arg;
+
+  __go_assert (mp->ncgoctxt > 0);
+  mp->ncgoctxt--;
+  if (mp->ncgoctxt >= (int32) nelem (mp->cgoctxt))
+    return;
+  arg.context = mp->cgoctxt[mp->ncgoctxt];
+  mp->cgoctxt[mp->ncgoctxt] = 0;
+  if (arg.context != 0 && cgo_context != NULL)
+    ((void (*) (void *)) cgo_context) (&arg);
+}
+
+/* Print one C frame at PC, using the symbolizer if there is one and
+   the debug info found by libbacktrace if not.  ARG carries the
+   symbolizer's state from one call to the next.  */
+
+static void
+print_cgo_frame (uintptr pc, struct cgo_symbolizer_arg *arg)
+{
+  String fn, file;
+  intgo line;
+
+  if (cgo_symbolizer == NULL)
+    {
+      if (__go_file_line (pc, -1, &fn, &file, &line) && fn.len > 0)
+	{
+	  runtime_printf ("%S\n", fn);
+	  runtime_printf ("\t%S:%D pc=%p\n", file, (int64) line,
+			  (void *) pc);
+	}
+      else
+	runtime_printf ("non-Go function\n\tpc=%p\n", (void *) pc);
+      return;
+    }
+
+  arg->pc = pc;
+  do
+    {
+      ((void (*) (void *)) cgo_symbolizer) (arg);
+      if (arg->func_name != NULL)
+	runtime_printf ("%s\n", arg->func_name);
+      else
+	runtime_printf ("non-Go function\n");
+      runtime_printf ("\t");
+      if (arg->file != NULL)
+	runtime_printf ("%s:%D ", arg->file, (int64) arg->lineno);
+      runtime_printf ("pc=%p\n", (void *) pc);
+    }
+  while (arg->more != 0);
+}
+
+/* Print the C frames of a crash that happened while the current M was
+   running C code.  SIGCONTEXT and SIGPC describe the signal.  With a
+   traceback function from SetCgoTraceback, it finds the frames;
+   otherwise they are the frames libbacktrace finds between the signal
+   and the first Go function.  */
+
+void
+runtime_printcgotraceback (void *sigcontext, uintptr sigpc)
+{
+  M *mp;
+  struct cgo_traceback_arg targ;
+  struct cgo_symbolizer_arg sarg;
+  uintptr pcs[100];
+  Location locbuf[100];
+  int32 c;
+  int32 i;
+
+  mp = runtime_m ();
+  __builtin_memset (&sarg, 0, sizeof sarg);
+
+  if (cgo_traceback != NULL)
+    {
+      __builtin_memset (&pcs[0], 0, sizeof pcs);
+      /* The context of the innermost call from C to Go, describing
+	 the C frames below it.  */
+      targ.context = 0;
+      if (mp->ncgoctxt > 0 && mp->ncgoctxt <= (int32) nelem (mp->cgoctxt))
+	targ.context = mp->cgoctxt[mp->ncgoctxt - 1];
+      targ.sig_context = (uintptr) sigcontext;
+      targ.buf = &pcs[0];
+      targ.max = nelem (pcs);
+      ((void (*) (void *)) cgo_traceback) (&targ);
+      for (i = 0; i < (int32) nelem (pcs) && pcs[i] != 0; ++i)
+	print_cgo_frame (pcs[i], &sarg);
+    }
+  else
+    {
+      c = runtime_callers (0, &locbuf[0], nelem (locbuf), true);
+
+      /* runtime_callers adds one to each PC.  */
+      for (i = 0; i < c; ++i)
+	if (locbuf[i].pc - 1 == sigpc)
+	  break;
+      for (; i < c; ++i)
+	{
+	  if (__builtin_memchr (locbuf[i].function.str, '.',
+				locbuf[i].function.len) != NULL)
+	    break;
+	  print_cgo_frame (locbuf[i].pc - 1, &sarg);
+	}
+    }
+
+  /* Let the symbolizer release anything it kept in sarg.data.  */
+  if (cgo_symbolizer != NULL)
+    {
+      sarg.pc = 0;
+      ((void (*) (void *)) cgo_symbolizer) (&sarg);
+    }
+  runtime_printf ("\n");
+}
+
 /* Allocate memory and save it in a list visible to the Go garbage
    collector.  */
 
@@ -23,7 +23,8 @@ __go_check_interface_type (
     {
       struct __go_empty_interface panic_arg;
 
-      runtime_newTypeAssertionError(NULL, NULL, lhs_descriptor->__reflection,
+      runtime_newTypeAssertionError(rhs_inter_descriptor->__reflection,
+				    NULL, lhs_descriptor->__reflection,
 				    NULL, &panic_arg);
       __go_panic(panic_arg);
     }
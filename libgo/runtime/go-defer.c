@@ -28,6 +28,7 @@ __go_defer (_Bool *frame, void (*pfn) (void *), void *arg)
   n->retaddr = 0;
   n->makefunccanrecover = 0;
   n->special = 0;
+  n->panicking = NULL;
   g->_defer = n;
 }
 
@@ -30,6 +30,10 @@ __go_make_slice2 (const struct __go_type_descriptor *td, uintptr_t len,
   uintptr_t size;
   struct __go_open_array ret;
 
+  /* A preemption point, as in __go_new.  */
+  if (runtime_g ()->preempt)
+    runtime_checkpreempt ();
+
   __go_assert ((td->__code & GO_CODE_MASK) == GO_SLICE);
   std = (const struct __go_slice_type *) td;
 
@@ -10,9 +10,16 @@
 #include "malloc.h"
 #include "go-type.h"
 
+/* The compiler calls this for new and composite literals, so it is
+   also where a goroutine that the scheduler has asked to yield
+   notices the request, much as it would in the stack check on
+   function entry with the gc compiler.  */
+
 void *
 __go_new (const struct __go_type_descriptor *td, uintptr_t size)
 {
+  if (runtime_g ()->preempt)
+    runtime_checkpreempt ();
   return runtime_mallocgc (size,
 			   (uintptr) td | TypeInfo_SingleObject,
 			   td->__code & GO_NO_POINTERS ? FlagNoScan : 0);
@@ -61,17 +61,29 @@ __go_panic (struct __go_empty_interface arg)
       pfn = (void (*) (void *)) d->pfn;
       d->pfn = 0;
 
+      /* If an earlier panic was running this defer function, then
+	 the function has panicked in turn, and the earlier panic
+	 will never get control back.  */
+      if (pfn == NULL && d->panicking != NULL)
+	d->panicking->aborted = 1;
+
       if (pfn != NULL)
 	{
+	  d->panicking = n;
+
 	  (*pfn) (d->arg);
 
 	  if (n->recovered)
 	    {
 	      /* Some defer function called recover.  That means that
-		 we should stop running this panic.  */
+		 we should stop running this panic.  Unwinding the
+		 stack will also discard any earlier panics that this
+		 one aborted.  */
 
 	      g->_panic = n->next;
 	      __go_free (n);
+	      while (g->_panic != NULL && g->_panic->aborted)
+		g->_panic = g->_panic->next;
 
 	      /* Now unwind the stack by throwing an exception.  The
 		 compiler has arranged to create exception handlers in
@@ -105,6 +117,22 @@ __go_panic (struct __go_empty_interface arg)
 
   /* The panic was not recovered.  */
 
+  runtime_preprintpanics (g->_panic);
+
+  if (runtime_issystemg (g))
+    {
+      /* A goroutine started by the runtime itself, such as the
+	 sweeper or the timer goroutine, never runs user code that
+	 could panic, so this is a runtime bug.  Report it as a fatal
+	 error rather than as an ordinary panic.  */
+      if (runtime_m ()->throwing == 0)
+	runtime_m ()->throwing = 1;
+      runtime_startpanic ();
+      __printpanics (g->_panic);
+      runtime_printf ("fatal error: runtime: system goroutine panic\n");
+      runtime_dopanic (0);
+    }
+
   runtime_startpanic ();
   __printpanics (g->_panic);
   runtime_dopanic (0);
@@ -70,7 +70,10 @@ __go_runtime_error (int32 i)
       runtime_panicstring ("slice bounds out of range");
 
     case NIL_DEREFERENCE:
-      runtime_panicstring ("nil pointer dereference");
+      /* Use the same message as for a nil pointer dereference caught
+	 by the signal handler, so that it does not matter which way
+	 it was detected.  */
+      runtime_panicstring ("invalid memory address or nil pointer dereference");
 
     case MAKE_SLICE_OUT_OF_BOUNDS:
       runtime_panicstring ("make slice len or cap out of range");
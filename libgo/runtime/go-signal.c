@@ -141,12 +141,168 @@ SigTab runtime_sigtab[] = {
 #undef P
 #undef D
 
+/* Return the name and description of a signal, as printed in crash
+   reports, or NULL for a signal that we have no name for.  */
+
+const char *
+runtime_signame (int32 sig)
+{
+  switch (sig)
+    {
+#ifdef SIGHUP
+    case SIGHUP:
+      return "SIGHUP: terminal line hangup";
+#endif
+#ifdef SIGINT
+    case SIGINT:
+      return "SIGINT: interrupt";
+#endif
+#ifdef SIGQUIT
+    case SIGQUIT:
+      return "SIGQUIT: quit";
+#endif
+#ifdef SIGILL
+    case SIGILL:
+      return "SIGILL: illegal instruction";
+#endif
+#ifdef SIGTRAP
+    case SIGTRAP:
+      return "SIGTRAP: trace trap";
+#endif
+#ifdef SIGABRT
+    case SIGABRT:
+      return "SIGABRT: abort";
+#endif
+#ifdef SIGBUS
+    case SIGBUS:
+      return "SIGBUS: bus error";
+#endif
+#ifdef SIGFPE
+    case SIGFPE:
+      return "SIGFPE: floating-point exception";
+#endif
+#ifdef SIGSEGV
+    case SIGSEGV:
+      return "SIGSEGV: segmentation violation";
+#endif
+#ifdef SIGPIPE
+    case SIGPIPE:
+      return "SIGPIPE: write to broken pipe";
+#endif
+#ifdef SIGTERM
+    case SIGTERM:
+      return "SIGTERM: termination";
+#endif
+    default:
+      return NULL;
+    }
+}
+
+/* Return the PC at which a signal arrived, from the context passed
+   to the signal handler, or 0 if we don't know how to find it on
+   this system.  */
+
+static uintptr
+getsigpc (void *context __attribute__ ((unused)))
+{
+#if defined(__linux__) && defined(__x86_64__) && defined(REG_RIP)
+  return ((ucontext_t *) context)->uc_mcontext.gregs[REG_RIP];
+#elif defined(__linux__) && defined(__i386__) && defined(REG_EIP)
+  return ((ucontext_t *) context)->uc_mcontext.gregs[REG_EIP];
+#else
+  return 0;
+#endif
+}
+
+/* Report a fatal signal SIG, which interrupted GP, and exit.  */
+
+static void sig_crash (int, Siginfo *, void *, G *)
+  __attribute__ ((noreturn));
+
+static void
+sig_crash (int sig, Siginfo *info, void *context, G *gp)
+{
+  M *m;
+  bool crash;
+
+  m = runtime_m ();
+
+  runtime_startpanic ();
+
+  {
+    const char *name = NULL;
+
+#ifdef HAVE_STRSIGNAL
+    name = strsignal (sig);
+#endif
+
+    if (name == NULL)
+      runtime_printf ("Signal %d\n", sig);
+    else
+      runtime_printf ("%s\n", name);
+  }
+
+  if (m->lockedg != NULL && m->ncgo > 0 && gp == m->g0)
+    {
+      runtime_printf("signal arrived during cgo execution\n");
+      gp = m->lockedg;
+    }
+  else if (m->ncgo > 0 && gp != NULL && gp->atomicstatus == _Gsyscall)
+    runtime_printf("signal arrived during cgo execution\n");
+
+  /* Record and report the goroutine that the signal interrupted,
+     so that it can be picked out of the goroutine dump.  */
+  if (gp != m->g0 && gp != m->gsignal)
+    m->caughtsig = (uintptr) gp;
+  {
+    const char *signame;
+
+    signame = runtime_signame (sig);
+    if (signame != NULL)
+      runtime_printf ("[signal %s", signame);
+    else
+      runtime_printf ("[signal %x", sig);
+  }
+#ifdef SA_SIGINFO
+  if (info != NULL)
+    runtime_printf (" code=%p addr=%p", (void *) (uintptr_t) info->si_code,
+		    info->si_addr);
+#endif
+  if (context != NULL)
+    runtime_printf (" pc=%p", (void *) getsigpc (context));
+  runtime_printf ("]");
+  if (m->caughtsig != 0)
+    runtime_printf (" goroutine %D running", gp->goid);
+  runtime_printf ("\n\n");
+
+  if (runtime_gotraceback (&crash))
+    {
+      G *g;
+
+      g = runtime_g ();
+      /* A signal in C code called through cgo; print the C frames
+	 that runtime_traceback leaves out.  */
+      if (m->ncgo > 0 && g->atomicstatus == _Gsyscall && context != NULL)
+	runtime_printcgotraceback (context, getsigpc (context));
+      runtime_traceback ();
+      runtime_tracebackothers (g);
+
+      /* The gc library calls runtime_dumpregs here, and provides
+	 a function that prints the registers saved in context in
+	 a readable form.  */
+    }
+
+  if (crash)
+    runtime_crash ();
+
+  runtime_exit (2);
+}
+
 /* Handle a signal, for cases where we don't panic.  We can split the
    stack here.  */
 
 void
-runtime_sighandler (int sig, Siginfo *info,
-		    void *context __attribute__ ((unused)), G *gp)
+runtime_sighandler (int sig, Siginfo *info, void *context, G *gp)
 {
   M *m;
   int i;
@@ -171,7 +327,7 @@ runtime_sighandler (int sig, Siginfo *info,
   for (i = 0; runtime_sigtab[i].sig != -1; ++i)
     {
       SigTab *t;
-      bool notify, crash;
+      bool notify;
 
       t = &runtime_sigtab[i];
 
@@ -192,46 +348,7 @@ runtime_sighandler (int sig, Siginfo *info,
       if ((t->flags & _SigThrow) == 0)
 	return;
 
-      runtime_startpanic ();
-
-      {
-	const char *name = NULL;
-
-#ifdef HAVE_STRSIGNAL
-	name = strsignal (sig);
-#endif
-
-	if (name == NULL)
-	  runtime_printf ("Signal %d\n", sig);
-	else
-	  runtime_printf ("%s\n", name);
-      }
-
-      if (m->lockedg != NULL && m->ncgo > 0 && gp == m->g0)
-	{
-	  runtime_printf("signal arrived during cgo execution\n");
-	  gp = m->lockedg;
-	}
-
-      runtime_printf ("\n");
-
-      if (runtime_gotraceback (&crash))
-	{
-	  G *g;
-
-	  g = runtime_g ();
-	  runtime_traceback ();
-	  runtime_tracebackothers (g);
-
-	  /* The gc library calls runtime_dumpregs here, and provides
-	     a function that prints the registers saved in context in
-	     a readable form.  */
-	}
-
-      if (crash)
-	runtime_crash ();
-
-      runtime_exit (2);
+      sig_crash (sig, info, context, gp);
     }
 
   __builtin_unreachable ();
@@ -255,11 +372,122 @@ sig_panic_leadin (G *gp)
   __go_assert (i == 0);
 }
 
+#ifdef SA_SIGINFO
+static void sig_panic_info_dispatch (G *) __attribute__ ((noreturn));
+#endif
+
+/* Without split stacks a goroutine that runs off the end of its fixed
+   size stack gets a SIGSEGV with no stack left to handle it, so the
+   SIGSEGV handler runs on the signal stack.  A fault that is not a
+   stack overflow must still panic on the goroutine stack, since
+   deferred functions run as part of the panic, so the handler
+   redirects the interrupted context to sig_panic_trampoline.  This is
+   only done where we know how to redirect the context.  */
+
+#if !defined (USING_SPLIT_STACK) && defined (SIGSEGV) && defined (SA_SIGINFO) \
+  && defined (__linux__) \
+  && ((defined (__x86_64__) && defined (REG_RIP)) \
+      || (defined (__i386__) && defined (REG_EIP)))
+#define SIGSEGV_ON_SIGNAL_STACK 1
+#endif
+
+#ifdef SIGSEGV_ON_SIGNAL_STACK
+
+/* Report whether ADDR is in the guard page at the low end of the
+   stack of GP, which means that GP has run off the end of its stack.
+   A gcstacksize of zero means that GP is running on a thread stack
+   whose bounds we do not know.  */
+
+static _Bool
+sig_stack_overflow (G *gp, uintptr_t addr)
+{
+  uintptr_t lo;
+  uintptr_t guard;
+
+  if (gp->gcstacksize == 0)
+    return 0;
+  lo = (uintptr_t) gp->gcinitialsp;
+  guard = (uintptr_t) getpagesize ();
+  return addr >= lo - guard && addr < lo + guard;
+}
+
+/* Throw a stack overflow error if the fault at ADDR was caused by GP
+   running off the end of its stack.  This is called on the signal
+   stack, as there is no room left on the goroutine stack.  */
+
+static void
+sig_check_stack_overflow (G *gp, uintptr_t addr)
+{
+  if (!sig_stack_overflow (gp, addr))
+    return;
+  runtime_printf ("runtime: goroutine stack exceeds %D-byte limit\n",
+		  (int64) gp->gcstacksize);
+  runtime_m ()->caughtsig = (uintptr) gp;
+  runtime_throw ("stack overflow");
+}
+
+/* Where a SIGSEGV handled on the signal stack resumes, on the
+   goroutine stack, to panic as though the faulting instruction had
+   called it.  The stack may not be aligned for a call.  */
+
+static void sig_panic_trampoline (void)
+  __attribute__ ((noreturn, force_align_arg_pointer));
+
+static void
+sig_panic_trampoline (void)
+{
+  G *g;
+
+  g = runtime_g ();
+  sig_panic_leadin (g);
+  sig_panic_info_dispatch (g);
+}
+
+/* Change CONTEXT so that returning from the signal handler calls
+   sig_panic_trampoline, with the return address set so that the
+   unwinder finds the faulting instruction.  If the fault was a call
+   to a nil function, there is no code at the PC, and the return
+   address pushed by the call is left to show the caller instead.  */
+
+static void
+sig_panic_redirect (void *context)
+{
+  greg_t *gregs;
+  uintptr_t pc;
+  uintptr_t sp;
+
+  gregs = ((ucontext_t *) context)->uc_mcontext.gregs;
+#ifdef __x86_64__
+  pc = gregs[REG_RIP];
+  sp = gregs[REG_RSP];
+#else
+  pc = gregs[REG_EIP];
+  sp = gregs[REG_ESP];
+#endif
+  if (pc >= 0x1000)
+    {
+      sp -= sizeof (uintptr_t);
+      *(uintptr_t *) sp = pc + 1;
+    }
+#ifdef __x86_64__
+  gregs[REG_RSP] = sp;
+  gregs[REG_RIP] = (uintptr_t) sig_panic_trampoline;
+#else
+  gregs[REG_ESP] = sp;
+  gregs[REG_EIP] = (uintptr_t) sig_panic_trampoline;
+#endif
+}
+
+#endif /* defined (SIGSEGV_ON_SIGNAL_STACK) */
+
 #ifdef SA_SIGINFO
 
 /* Signal dispatch for signals which panic, on systems which support
    SA_SIGINFO.  This is called on the thread stack, and as such it is
-   permitted to split the stack.  */
+   permitted to split the stack.  The exception is a SIGSEGV when
+   SIGSEGV_ON_SIGNAL_STACK is defined: that is handled on the signal
+   stack, and panics on the goroutine stack by way of
+   sig_panic_trampoline.  */
 
 static void
 sig_panic_info_handler (int sig, Siginfo *info, void *context)
@@ -273,43 +501,73 @@ sig_panic_info_handler (int sig, Siginfo *info, void *context)
       return;
     }
 
+  /* A fault in C code called through cgo can't become a Go panic,
+     since the C frames can't be unwound; report it and exit, as the
+     gc library does.  */
+  if (g->m != NULL && g->m->ncgo > 0 && g->atomicstatus == _Gsyscall)
+    sig_crash (sig, info, context, g);
+
   g->sig = sig;
   g->sigcode0 = info->si_code;
   g->sigcode1 = (uintptr_t) info->si_addr;
+  g->sigpc = getsigpc (context);
 
-  /* It would be nice to set g->sigpc here as the gc library does, but
-     I don't know how to get it portably.  */
+#ifdef SIGSEGV_ON_SIGNAL_STACK
+  if (sig == SIGSEGV)
+    {
+      sig_check_stack_overflow (g, (uintptr_t) info->si_addr);
+      sig_panic_redirect (context);
+      return;
+    }
+#endif
 
   sig_panic_leadin (g);
+  sig_panic_info_dispatch (g);
+}
 
-  switch (sig)
+/* Panic for the signal recorded in G by sig_panic_info_handler.  */
+
+static void
+sig_panic_info_dispatch (G *g)
+{
+  switch (g->sig)
     {
 #ifdef SIGBUS
     case SIGBUS:
-      if ((info->si_code == BUS_ADRERR && (uintptr_t) info->si_addr < 0x1000)
-	  || g->paniconfault)
+      if ((int) g->sigcode0 == BUS_ADRERR && g->sigcode1 < 0x1000)
 	runtime_panicstring ("invalid memory address or "
 			     "nil pointer dereference");
-      runtime_printf ("unexpected fault address %p\n", info->si_addr);
+      /* A goroutine that asked for debug.SetPanicOnFault gets a
+	 panic that reports the faulting address.  */
+      if (g->paniconfault)
+	runtime_panicmemAddr (g->sigcode1);
+      runtime_printf ("unexpected fault address %p\n", (void *) g->sigcode1);
+      runtime_m ()->caughtsig = (uintptr) g;
       runtime_throw ("fault");
 #endif
 
 #ifdef SIGSEGV
     case SIGSEGV:
-      if (((info->si_code == 0
-	    || info->si_code == SEGV_MAPERR
-	    || info->si_code == SEGV_ACCERR)
-	   && (uintptr_t) info->si_addr < 0x1000)
-	  || g->paniconfault)
+      if ((((int) g->sigcode0 == 0
+	    || (int) g->sigcode0 == SEGV_MAPERR
+	    || (int) g->sigcode0 == SEGV_ACCERR)
+	   && g->sigcode1 < 0x1000))
 	runtime_panicstring ("invalid memory address or "
 			     "nil pointer dereference");
-      runtime_printf ("unexpected fault address %p\n", info->si_addr);
+      if (g->paniconfault)
+	runtime_panicmemAddr (g->sigcode1);
+      runtime_printf ("unexpected fault address %p\n", (void *) g->sigcode1);
+      runtime_m ()->caughtsig = (uintptr) g;
       runtime_throw ("fault");
 #endif
 
 #ifdef SIGFPE
     case SIGFPE:
-      switch (info->si_code)
+      /* The compiler normally checks for division by zero and calls
+	 runtime_panicdivide, but code compiled with
+	 -fno-go-check-divide-zero gets here instead.  Use the same
+	 recoverable runtime error.  */
+      switch ((int) g->sigcode0)
 	{
 	case FPE_INTDIV:
 	  runtime_panicstring ("integer divide by zero");
@@ -469,6 +727,10 @@ runtime_setsig (int32 i, GoSighandler *fn, bool restart)
     {
 #ifdef SA_SIGINFO
       sa.sa_flags = SA_SIGINFO;
+#ifdef SIGSEGV_ON_SIGNAL_STACK
+      if (t->sig == SIGSEGV)
+	sa.sa_flags |= SA_ONSTACK;
+#endif
       if (fn == runtime_sighandler)
 	fn = (void *) sig_panic_info_handler;
       sa.sa_sigaction = (void *) fn;
@@ -518,7 +780,12 @@ runtime_getsig (int32 i)
   return (void *) sa.sa_handler;
 }
 
-/* Used by the os package to raise SIGPIPE.  */
+/* Used by the os package to raise SIGPIPE.  The signal handler
+   ignores a SIGPIPE that nobody asked to be notified of, so that a
+   write to a broken pipe or socket just fails with EPIPE.  The os
+   package calls this when such a write was to standard output or
+   standard error, in which case the program dies of SIGPIPE like a
+   typical Unix command line program.  */
 
 void os_sigpipe (void) __asm__ (GOSYM_PREFIX "os.sigpipe");
 
@@ -526,6 +793,7 @@ void
 os_sigpipe (void)
 {
   struct sigaction sa;
+  sigset_t mask;
   int i;
 
   if (__go_sigsend (SIGPIPE))
@@ -541,7 +809,19 @@ os_sigpipe (void)
   if (sigaction (SIGPIPE, &sa, NULL) != 0)
     abort ();
 
+  /* The signal may be blocked on this thread; make sure that the
+     default action is taken.  */
+  i = sigemptyset (&mask);
+  __go_assert (i == 0);
+  i = sigaddset (&mask, SIGPIPE);
+  __go_assert (i == 0);
+  i = pthread_sigmask (SIG_UNBLOCK, &mask, NULL);
+  __go_assert (i == 0);
+
   raise (SIGPIPE);
+
+  /* We should be dead by now.  */
+  runtime_exit (2);
 }
 
 void
@@ -615,7 +615,7 @@ runtime_debug_WriteHeapDump(uintptr fd)
 	G *g;
 
 	// Stop the world.
-	runtime_semacquire(&runtime_worldsema, false);
+	runtime_semacquire(&runtime_worldsema, 0);
 	m = runtime_m();
 	m->gcing = 1;
 	m->locks++;
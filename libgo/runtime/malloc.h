@@ -87,6 +87,7 @@ typedef struct MStats	MStats;
 typedef struct mlink	MLink;
 typedef struct mtypes	MTypes;
 typedef struct gcstats	GCStats;
+typedef struct Arena	Arena;
 
 enum
 {
@@ -192,6 +193,22 @@ void	runtime_SysMap(void *v, uintptr nbytes, bool reserved, uint64 *stat);
 void*	runtime_SysReserve(void *v, uintptr nbytes, bool *reserved);
 void	runtime_SysFault(void *v, uintptr nbytes);
 
+// An Arena is the header at the start of a user arena; see
+// arenaNew in malloc.goc.  The header is included in used, so the
+// garbage collector can scan from the start of the region.
+struct Arena
+{
+	Lock;		// protects used
+	Arena*	next;	// list of live arenas
+	uintptr	size;	// size of the region
+	uintptr	used;	// bytes in use, including this header
+};
+
+enum
+{
+	MaxArenaAlign = 16,	// largest alignment arenaAlloc supports
+};
+
 // FixAlloc is a simple free-list allocator for fixed size objects.
 // Malloc uses a FixAlloc wrapped around SysAlloc to manages its
 // MCache and MSpan objects.
@@ -397,7 +414,10 @@ struct MCentral
 
 void	runtime_MCentral_Init(MCentral *c, int32 sizeclass);
 MSpan*	runtime_MCentral_CacheSpan(MCentral *c);
+int32	runtime_MCentral_CacheSpans(MCentral *c, MSpan **spans, int32 n);
+void	runtime_MCentral_UseSpare(MCentral *c, MSpan *old, MSpan *spare);
 void	runtime_MCentral_UncacheSpan(MCentral *c, MSpan *s);
+void	runtime_MCentral_UncacheSpare(MCentral *c, MSpan *s);
 bool	runtime_MCentral_FreeSpan(MCentral *c, MSpan *s, int32 n, MLink *start, MLink *end);
 void	runtime_MCentral_FreeList(MCentral *c, MLink *start); // TODO: need this?
 
@@ -470,10 +490,12 @@ int32	runtime_mlookup(void *v, byte **base, uintptr *size, MSpan **s);
 void	runtime_gc(int32 force);
 uintptr	runtime_sweepone(void);
 void	runtime_markscan(void *v);
+bool	runtime_isscan(void *v);
 void	runtime_marknogc(void *v);
 void	runtime_checkallocated(void *v, uintptr n);
 void	runtime_markfreed(void *v);
 void	runtime_checkfreed(void *v, uintptr n);
+bool	runtime_lookuproot(void *v, byte **base, uintptr *size);
 extern	int32	runtime_checking;
 void	runtime_markspan(void *v, uintptr size, uintptr n, bool leftover);
 void	runtime_unmarkspan(void *v, uintptr size);
@@ -512,6 +534,7 @@ void	runtime_MProf_GC(void);
 void	runtime_iterate_memprof(void (*callback)(Bucket*, uintptr, Location*, uintptr, uintptr, uintptr));
 int32	runtime_gcprocs(void);
 void	runtime_helpgc(int32 nproc);
+int64	runtime_schedtotaltime(int64 now);
 void	runtime_gchelper(void);
 void	runtime_createfing(void);
 G*	runtime_wakefing(void);
@@ -578,6 +601,7 @@ void	runtime_gc_itab_ptr(Eface*);
 
 void	runtime_memorydump(void);
 int32	runtime_setgcpercent(int32);
+extern	uint64	runtime_gcheadroom;
 
 // Value we use to mark dead pointers when GODEBUG=gcdead=1.
 #define PoisonGC ((uintptr)0xf969696969696969ULL)
@@ -588,3 +612,4 @@ void	runtime_MProf_Mark(struct Workbuf**, void (*)(struct Workbuf**, Obj));
 void	runtime_proc_scan(struct Workbuf**, void (*)(struct Workbuf**, Obj));
 void	runtime_time_scan(struct Workbuf**, void (*)(struct Workbuf**, Obj));
 void	runtime_netpoll_scan(struct Workbuf**, void (*)(struct Workbuf**, Obj));
+void	runtime_arena_scan(struct Workbuf**, void (*)(struct Workbuf**, Obj));
@@ -56,15 +56,23 @@ MSpan*
 runtime_MCache_Refill(MCache *c, int32 sizeclass)
 {
 	MCacheList *l;
-	MSpan *s;
+	MSpan *s, *old, *spans[2];
+	int32 hot, n;
 
 	runtime_m()->locks++;
-	// Return the current cached span to the central lists.
-	s = c->alloc[sizeclass];
-	if(s->freelist != nil)
+	// Return the current cached span to the central lists,
+	// switching to the spare span at the same time if there is one.
+	old = c->alloc[sizeclass];
+	if(old->freelist != nil)
 		runtime_throw("refill on a nonempty span");
-	if(s != &emptymspan)
-		runtime_MCentral_UncacheSpan(&runtime_mheap.central[sizeclass], s);
+	if(old == &emptymspan)
+		old = nil;
+	s = c->spare[sizeclass];
+	if(s != nil) {
+		c->spare[sizeclass] = nil;
+		runtime_MCentral_UseSpare(&runtime_mheap.central[sizeclass], old, s);
+	} else if(old != nil)
+		runtime_MCentral_UncacheSpan(&runtime_mheap.central[sizeclass], old);
 
 	// Push any explicitly freed objects to the central lists.
 	// Not required, but it seems like a good time to do it.
@@ -75,8 +83,20 @@ runtime_MCache_Refill(MCache *c, int32 sizeclass)
 		l->nlist = 0;
 	}
 
-	// Get a new cached span from the central lists.
-	s = runtime_MCentral_CacheSpan(&runtime_mheap.central[sizeclass]);
+	// Unless the spare span was used, get a new cached span from
+	// the central lists, taking a spare span as well if the size
+	// class is hot.
+	hot = runtime_debug.mcachehot;
+	if(s == nil && hot > 0 && c->nrefill[sizeclass] >= (uint32)hot) {
+		n = runtime_MCentral_CacheSpans(&runtime_mheap.central[sizeclass], spans, 2);
+		if(n > 0)
+			s = spans[0];
+		if(n == 2)
+			c->spare[sizeclass] = spans[1];
+	} else if(s == nil)
+		s = runtime_MCentral_CacheSpan(&runtime_mheap.central[sizeclass]);
+	if(c->nrefill[sizeclass] < (uint32)hot)
+		c->nrefill[sizeclass]++;
 	if(s == nil)
 		runtime_throw("out of memory");
 	if(s->freelist == nil) {
@@ -121,6 +141,12 @@ runtime_MCache_ReleaseAll(MCache *c)
 			runtime_MCentral_UncacheSpan(&runtime_mheap.central[i], s);
 			c->alloc[i] = &emptymspan;
 		}
+		s = c->spare[i];
+		if(s != nil) {
+			runtime_MCentral_UncacheSpare(&runtime_mheap.central[i], s);
+			c->spare[i] = nil;
+		}
+		c->nrefill[i] /= 2;
 		l = &c->free[i];
 		if(l->nlist > 0) {
 			runtime_MCentral_FreeList(&runtime_mheap.central[i], l->list);
@@ -18,6 +18,9 @@
 #include "arch.h"
 #include "malloc.h"
 
+static MSpan* MCentral_CacheSpan(MCentral *c);
+static void MCentral_TakeSpan(MCentral *c, MSpan *s);
+static void MCentral_UncacheSpan(MCentral *c, MSpan *s, bool spare);
 static bool MCentral_Grow(MCentral *c);
 static void MCentral_Free(MCentral *c, MLink *v);
 static void MCentral_ReturnToHeap(MCentral *c, MSpan *s);
@@ -34,12 +37,73 @@ runtime_MCentral_Init(MCentral *c, int32 sizeclass)
 // Allocate a span to use in an MCache.
 MSpan*
 runtime_MCentral_CacheSpan(MCentral *c)
+{
+	MSpan *s;
+
+	runtime_lock(c);
+	s = MCentral_CacheSpan(c);
+	if(s != nil)
+		MCentral_TakeSpan(c, s);
+	runtime_unlock(c);
+	return s;
+}
+
+// Allocate up to n spans to use in an MCache, taking the lock once.
+// Returns the number of spans stored in spans.  Only spans[0] is
+// allocated from; the others are spares, whose free objects are
+// still counted in c->nfree until runtime_MCentral_UseSpare.
+int32
+runtime_MCentral_CacheSpans(MCentral *c, MSpan **spans, int32 n)
+{
+	int32 i;
+
+	runtime_lock(c);
+	for(i = 0; i < n; i++) {
+		spans[i] = MCentral_CacheSpan(c);
+		if(spans[i] == nil)
+			break;
+	}
+	if(i > 0)
+		MCentral_TakeSpan(c, spans[0]);
+	runtime_unlock(c);
+	return i;
+}
+
+// Start allocating from a spare span returned by
+// runtime_MCentral_CacheSpans, and return old, the span it replaces,
+// if it is not nil.  Takes the lock once for both.
+void
+runtime_MCentral_UseSpare(MCentral *c, MSpan *old, MSpan *spare)
+{
+	runtime_lock(c);
+	MCentral_TakeSpan(c, spare);
+	if(old != nil)
+		MCentral_UncacheSpan(c, old, false); // unlocks c
+	else
+		runtime_unlock(c);
+}
+
+// Stop counting the free objects of the cached span s as available
+// in c.  c must be locked.
+static void
+MCentral_TakeSpan(MCentral *c, MSpan *s)
+{
+	int32 cap;
+
+	cap = (s->npages << PageShift) / s->elemsize;
+	c->nfree -= cap - s->ref;
+}
+
+// Find a span with free objects and hand it to an MCache.
+// c must be locked; it is unlocked and relocked while sweeping
+// or growing, and is locked again on return.
+static MSpan*
+MCentral_CacheSpan(MCentral *c)
 {
 	MSpan *s;
 	int32 cap, n;
 	uint32 sg;
 
-	runtime_lock(c);
 	sg = runtime_mheap.sweepgen;
 retry:
 	for(s = c->nonempty.next; s != &c->nonempty; s = s->next) {
@@ -81,10 +145,8 @@ retry:
 	}
 
 	// Replenish central list if empty.
-	if(!MCentral_Grow(c)) {
-		runtime_unlock(c);
+	if(!MCentral_Grow(c))
 		return nil;
-	}
 	goto retry;
 
 havespan:
@@ -94,11 +156,9 @@ havespan:
 		runtime_throw("empty span");
 	if(s->freelist == nil)
 		runtime_throw("freelist empty");
-	c->nfree -= n;
 	runtime_MSpanList_Remove(s);
 	runtime_MSpanList_InsertBack(&c->mempty, s);
 	s->incache = true;
-	runtime_unlock(c);
 	return s;
 }
 
@@ -106,21 +166,41 @@ havespan:
 void
 runtime_MCentral_UncacheSpan(MCentral *c, MSpan *s)
 {
-	MLink *v;
-	int32 cap, n;
+	runtime_lock(c);
+	MCentral_UncacheSpan(c, s, false);
+}
 
+// Return a spare span from an MCache without having allocated from it.
+void
+runtime_MCentral_UncacheSpare(MCentral *c, MSpan *s)
+{
 	runtime_lock(c);
+	MCentral_UncacheSpan(c, s, true);
+}
+
+// Return span from an MCache.  If spare is set, the free objects it
+// had when it was cached are still counted in c->nfree.
+// c must be locked; it is unlocked on return.
+static void
+MCentral_UncacheSpan(MCentral *c, MSpan *s, bool spare)
+{
+	MLink *v;
+	int32 cap, n, nbuf;
 
 	s->incache = false;
 
 	// Move any explicitly freed items from the freebuf to the freelist.
+	nbuf = 0;
 	while((v = s->freebuf) != nil) {
 		s->freebuf = v->next;
 		runtime_markfreed(v);
 		v->next = s->freelist;
 		s->freelist = v;
 		s->ref--;
+		nbuf++;
 	}
+	if(spare)
+		c->nfree += nbuf;
 
 	if(s->ref == 0) {
 		// Free back to heap.  Unlikely, but possible.
@@ -131,7 +211,8 @@ runtime_MCentral_UncacheSpan(MCentral *c, MSpan *s)
 	cap = (s->npages << PageShift) / s->elemsize;
 	n = cap - s->ref;
 	if(n > 0) {
-		c->nfree += n;
+		if(!spare)
+			c->nfree += n;
 		runtime_MSpanList_Remove(s);
 		runtime_MSpanList_Insert(&c->nonempty, s);
 	}
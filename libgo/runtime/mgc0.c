@@ -72,6 +72,9 @@ typedef struct __go_map Hmap;
 // PtrType aka __go_ptr_type
 #define elem __element_type
 
+// A pointer-sized word of PoisonFreeByte.
+#define PoisonFreeWord ((uintptr)0xdbdbdbdbdbdbdbdbULL)
+
 #ifdef USING_SPLIT_STACK
 
 extern void * __splitstack_find (void *, void *, size_t *, void **, void **,
@@ -93,6 +96,9 @@ enum {
 	handoffThreshold = 4,
 	IntermediateBufferCapacity = 64,
 
+	// Byte written over freed objects when GODEBUG=poisonfree=1.
+	PoisonFreeByte = 0xdb,
+
 	// Bits in type information
 	PRECISE = 1,
 	LOOP = 2,
@@ -111,6 +117,22 @@ enum {
 // Initialized from $GOGC.  GOGC=off means no gc.
 static int32 gcpercent = GcpercentUnknown;
 
+// Allocation-rate pacing.  Other goroutines keep allocating from the
+// time a collection is triggered until the world has stopped, so a
+// program that allocates in bursts runs past next_gc by roughly its
+// allocation rate times the length of that window.  Nothing allocates
+// once the world has stopped.  runtime_gcheadroom is that many bytes,
+// as measured over the last cycle, and collections start that much
+// before next_gc.  GODEBUG=gcpacerate=0 sets it to 0, so that
+// collections start at next_gc.  See pacegc.
+uint64 runtime_gcheadroom;
+static struct
+{
+	int64	end;	// nanotime at the end of the last collection
+	uint64	heap;	// heap_alloc at the end of the last collection
+	intgo	maxover;	// largest overshoot of next_gc, in percent, for testing
+} pace;
+
 static FuncVal* poolcleanup;
 
 void sync_runtime_registerPoolCleanup(FuncVal*)
@@ -141,9 +163,11 @@ clearpools(void)
 			c->tiny = nil;
 			c->tinysize = 0;
 		}
-		// clear defer pools
-		p->deferpool = nil;
 	}
+
+	runtime_cleardeferpool();
+	runtime_clearsudogcache();
+	runtime_gfreerelease();
 }
 
 // Holding worldsema grants an M the right to try to stop the world.
@@ -200,6 +224,7 @@ bool	runtime_fingwake;
 
 static Lock	gclock;
 static G*	fing;
+static bool	fingrunning;	// fing is running a finalizer
 
 static void	runfinq(void*);
 static void	bgsweep(void*);
@@ -219,8 +244,10 @@ static struct {
 	int64	tstart;
 	volatile uint32	nwait;
 	volatile uint32	ndone;
+	volatile uint32	nmarking;  // helpers that have not yet left runtime_gchelper
 	Note	alldone;
 	ParFor	*markfor;
+	int64	totaltime;  // gomaxprocs * pause ns, summed over all GCs
 
 	Lock;
 	byte	*chunk;
@@ -710,6 +737,43 @@ checkptr(void *obj, uintptr objti)
 	}
 }					
 
+// badpointer reports a value that cannot be a pointer, found at offset
+// off in the block b in a word that the type information says holds a
+// pointer.  This almost always means that memory has been corrupted.
+// It is disabled by GODEBUG=invalidptr=0.
+static void
+badpointer(byte *b, uintptr off, void *obj)
+{
+	uintptr type;
+	Type *t;
+
+	runtime_printf("runtime: bad pointer %p at *(%p+%p)", obj, b, off);
+	type = runtime_gettype(b);
+	t = (Type*)(type & ~(uintptr)(PtrSize-1));
+	if(t != nil && t->string != nil)
+		runtime_printf(" in object of type %S", *t->string);
+	runtime_printf("\n");
+	runtime_throw("found bad pointer in Go heap");
+}
+
+// usedfree reports a pointer-typed word at offset off in the block b
+// that holds the pattern written over freed objects by
+// GODEBUG=poisonfree=1: b was freed while something still pointed to it.
+static void
+usedfree(byte *b, uintptr off)
+{
+	uintptr type;
+	Type *t;
+
+	runtime_printf("runtime: found freed memory pattern %p at *(%p+%p)", PoisonFreeWord, b, off);
+	type = runtime_gettype(b);
+	t = (Type*)(type & ~(uintptr)(PtrSize-1));
+	if(t != nil && t->string != nil)
+		runtime_printf(" in object of type %S", *t->string);
+	runtime_printf("\n");
+	runtime_throw("use of freed object");
+}
+
 // scanblock scans a block of n bytes starting at pointer b for references
 // to other objects, scanning any it finds recursively until there are no
 // unscanned objects left.  Instead of using an explicit recursion, it keeps
@@ -883,6 +947,10 @@ scanblock(Workbuf *wbuf, bool keepworking)
 			objti = pc[2];
 			if(Debug > 2)
 				runtime_printf("gc_ptr @%p: %p ti=%p\n", stack_top.b+pc[1], obj, objti);
+			if(obj != nil && (uintptr)obj < PageSize && runtime_debug.invalidptr)
+				badpointer(b, stack_top.b + pc[1] - (uintptr)b, obj);
+			if((uintptr)obj == PoisonFreeWord && runtime_debug.poisonfree)
+				usedfree(b, stack_top.b + pc[1] - (uintptr)b);
 			pc += 3;
 			if(Debug)
 				checkptr(obj, objti);
@@ -907,6 +975,8 @@ scanblock(Workbuf *wbuf, bool keepworking)
 			obj = *(void**)(stack_top.b + pc[1]);
 			if(Debug > 2)
 				runtime_printf("gc_aptr @%p: %p\n", stack_top.b+pc[1], obj);
+			if((uintptr)obj == PoisonFreeWord && runtime_debug.poisonfree)
+				usedfree(b, stack_top.b + pc[1] - (uintptr)b);
 			pc += 2;
 			break;
 
@@ -1187,6 +1257,26 @@ __go_register_gc_roots (struct root_list* r)
 	roots = r;
 }
 
+// If v points into a registered global variable, return the
+// variable's address and size.  Used by the cgo pointer checks.
+bool
+runtime_lookuproot(void *v, byte **base, uintptr *size)
+{
+	struct root_list *pl;
+	struct root *pr;
+
+	for(pl = roots; pl != nil; pl = pl->next) {
+		for(pr = &pl->roots[0]; pr->decl != nil; pr++) {
+			if((byte*)v >= (byte*)pr->decl && (byte*)v < (byte*)pr->decl + pr->size) {
+				*base = pr->decl;
+				*size = pr->size;
+				return true;
+			}
+		}
+	}
+	return false;
+}
+
 // Append obj to the work buffer.
 // _wbuf, _wp, _nobj are input/output parameters and are specifying the work buffer.
 static void
@@ -1298,6 +1388,7 @@ markroot(ParFor *desc, uint32 i)
 		runtime_MProf_Mark(&wbuf, enqueue1);
 		runtime_time_scan(&wbuf, enqueue1);
 		runtime_netpoll_scan(&wbuf, enqueue1);
+		runtime_arena_scan(&wbuf, enqueue1);
 		break;
 
 	case RootFinalizers:
@@ -1754,8 +1845,11 @@ runtime_MSpan_Sweep(MSpan *s)
 			// See note about SysFault vs SysFree in malloc.goc.
 			if(runtime_debug.efence)
 				runtime_SysFault(p, size);
-			else
+			else {
+				if(runtime_debug.poisonfree)
+					__builtin_memset(p, PoisonFreeByte, size);
 				runtime_MHeap_Free(&runtime_mheap, s, 1);
+			}
 			c->local_nlargefree++;
 			c->local_largefree += size;
 			runtime_xadd64(&mstats.next_gc, -(uint64)(size * (gcpercent + 100)/100));
@@ -1770,7 +1864,9 @@ runtime_MSpan_Sweep(MSpan *s)
 				*(byte*)type_data = 0;
 				break;
 			}
-			if(size > 2*sizeof(uintptr))
+			if(size > 2*sizeof(uintptr) && runtime_debug.poisonfree)
+				__builtin_memset(p+sizeof(uintptr), PoisonFreeByte, size-sizeof(uintptr));	// also marks as "needs to be zeroed"
+			else if(size > 2*sizeof(uintptr))
 				((uintptr*)p)[1] = (uintptr)0xdeaddeaddeaddeadll;	// mark as "needs to be zeroed"
 			else if(size > sizeof(uintptr))
 				((uintptr*)p)[1] = 0;
@@ -1823,7 +1919,7 @@ static struct
 static void
 bgsweep(void* dummy __attribute__ ((unused)))
 {
-	runtime_g()->issystem = 1;
+	runtime_setsystemg(runtime_g());
 	for(;;) {
 		while(runtime_sweepone() != (uintptr)-1) {
 			gcstats.nbgsweep++;
@@ -1958,9 +2054,12 @@ runtime_memorydump(void)
 void
 runtime_gchelper(void)
 {
+	M *m;
 	uint32 nproc;
 
-	runtime_m()->traceback = 2;
+	m = runtime_m();
+	m->traceback = 2;
+	runtime_xadd(&work.nmarking, +1);
 	gchelperstart();
 
 	// parallel mark for over gc roots
@@ -1969,11 +2068,21 @@ runtime_gchelper(void)
 	// help other threads scan secondary blocks
 	scanblock(nil, true);
 
-	bufferList[runtime_m()->helpgc].busy = 0;
+	bufferList[m->helpgc].busy = 0;
+
+	// Stop looking like a GC helper before reporting that we are
+	// done.  Once the last helper increments work.ndone the world
+	// can be restarted and another stop the world can begin, and
+	// this M must not still hold a P's mcache or be counted as
+	// marking at that point.
+	m->helpgc = 0;
+	m->mcache = nil;
+	m->traceback = 0;
+	runtime_xadd(&work.nmarking, -1);
+
 	nproc = work.nproc;  // work.nproc can change right after we increment work.ndone
 	if(runtime_xadd(&work.ndone, +1) == nproc-1)
 		runtime_notewakeup(&work.alldone);
-	runtime_m()->traceback = 0;
 }
 
 static void
@@ -2091,11 +2200,16 @@ runtime_updatememstats(GCStats *stats)
 // This allows the arguments to be passed via runtime_mcall.
 struct gc_args
 {
+	int64 trigger_time; // when the collection was triggered
 	int64 start_time; // start time of GC in ns (just before stoptheworld)
+	int64 stop_time; // when the world had stopped
+	uint64 heap_start; // heap_alloc when the collection was started
+	uint64 goal; // next_gc when the collection was started
 	bool  eagersweep;
 };
 
 static void gc(struct gc_args *args);
+static void pacegc(struct gc_args *args, int64 end, uint64 heap0);
 static void mgc(G *gp);
 
 static int32
@@ -2143,8 +2257,9 @@ runtime_gc(int32 force)
 	// problems, don't bother trying to run gc
 	// while holding a lock.  The next mallocgc
 	// without a lock will do the gc instead.
+	// Likewise never collect in the middle of an allocation.
 	m = runtime_m();
-	if(!mstats.enablegc || runtime_g() == m->g0 || m->locks > 0 || runtime_panicking)
+	if(!mstats.enablegc || runtime_g() == m->g0 || m->locks > 0 || m->mallocing || runtime_panicking)
 		return;
 
 	if(gcpercent == GcpercentUnknown) {	// first time through
@@ -2156,8 +2271,9 @@ runtime_gc(int32 force)
 	if(gcpercent < 0)
 		return;
 
-	runtime_semacquire(&runtime_worldsema, false);
-	if(force==0 && mstats.heap_alloc < mstats.next_gc) {
+	a.trigger_time = runtime_nanotime();
+	runtime_semacquire(&runtime_worldsema, 0);
+	if(force==0 && mstats.heap_alloc + runtime_gcheadroom < mstats.next_gc) {
 		// typically threads which lost the race to grab
 		// worldsema exit here when gc is done.
 		runtime_semrelease(&runtime_worldsema);
@@ -2166,9 +2282,12 @@ runtime_gc(int32 force)
 
 	// Ok, we're doing it!  Stop everybody else
 	a.start_time = runtime_nanotime();
+	a.heap_start = mstats.heap_alloc;
+	a.goal = mstats.next_gc;
 	a.eagersweep = force >= 2;
 	m->gcing = 1;
 	runtime_stoptheworld();
+	a.stop_time = runtime_nanotime();
 	
 	clearpools();
 
@@ -2215,6 +2334,154 @@ mgc(G *gp)
 	runtime_gogo(gp);
 }
 
+// pacegc sets runtime_gcheadroom at the end of the collection
+// described by args, which ended at end.  heap0 is the estimated live
+// heap after the previous collection.
+static void
+pacegc(struct gc_args *args, int64 end, uint64 heap0)
+{
+	float64 rate, headroom, max;
+	intgo over;
+
+	// mstats.heap_alloc is still the heap as it was when the
+	// world stopped.
+	if(args->goal != 0 && mstats.heap_alloc > args->goal) {
+		over = (mstats.heap_alloc - args->goal) * 100 / args->goal;
+		if(over > pace.maxover)
+			pace.maxover = over;
+	}
+
+	headroom = 0;
+	if(runtime_debug.gcpacerate > 0 && pace.end != 0 && args->start_time > pace.end && args->heap_start > pace.heap) {
+		// Bytes allocated per nanosecond since the last collection.
+		rate = (float64)(args->heap_start - pace.heap) / (args->start_time - pace.end);
+		headroom = rate * (args->stop_time - args->trigger_time);
+		// Don't give up more than a quarter of what GOGC allows
+		// the heap to grow by.
+		max = (float64)heap0 * gcpercent / 100 / 4;
+		if(headroom > max)
+			headroom = max;
+	}
+	runtime_gcheadroom = headroom;
+	pace.end = end;
+	pace.heap = mstats.heap_alloc;
+}
+
+// For testing: the largest amount by which the heap has exceeded
+// next_gc when a collection stopped the world, as a percentage of
+// next_gc, since the last call.
+intgo runtime_gcmaxovershoot(void)
+  __asm__ (GOSYM_PREFIX "runtime.gcmaxovershoot");
+
+intgo
+runtime_gcmaxovershoot(void)
+{
+	intgo over;
+
+	over = pace.maxover;
+	pace.maxover = 0;
+	return over;
+}
+
+// For testing the checkmark pass: an object whose mark from the
+// first pass is forgotten, as though the first pass had missed it.
+static void *checkmarkdrop;
+
+void runtime_setcheckmarkdrop(void*)
+  __asm__ (GOSYM_PREFIX "runtime.setcheckmarkdrop");
+
+void
+runtime_setcheckmarkdrop(void *p)
+{
+	checkmarkdrop = p;
+}
+
+// For testing: the number of Ms that are still inside
+// runtime_gchelper or still look like GC helpers.  Once a GC has
+// returned this must be zero.
+intgo runtime_gchelpersmarking(void)
+  __asm__ (GOSYM_PREFIX "runtime.gchelpersmarking");
+
+intgo
+runtime_gchelpersmarking(void)
+{
+	M *mp;
+	intgo n;
+
+	n = work.nmarking;
+	for(mp=runtime_atomicloadp(&runtime_allm); mp; mp=mp->alllink) {
+		if(mp->helpgc > 0)
+			n++;
+	}
+	return n;
+}
+
+// Verify the mark phase, for GODEBUG=gccheckmark=1.  Called with the
+// world stopped after the parallel mark has finished.  Saves and
+// clears the mark bits, marks everything again using just this
+// thread, and throws if that finds a reachable object that the
+// first pass did not mark.  Leaves behind the union of both passes.
+static void
+checkmark(void)
+{
+	M *m;
+	uintptr *b, *saved, n, i, j, markmask, x, off, missed;
+
+	m = runtime_m();
+	n = runtime_mheap.bitmap_mapped / sizeof(uintptr);
+	if(n == 0)
+		return;
+	saved = runtime_SysAlloc(n * sizeof(uintptr), &mstats.gc_sys);
+	if(saved == nil)
+		runtime_throw("gccheckmark: out of memory");
+
+	// The bitmap words run backward from arena_start; the
+	// bitMarked bits for all the words one describes are adjacent.
+	markmask = (((uintptr)1<<bitShift) - 1) * bitMarked;
+	b = (uintptr*)runtime_mheap.arena_start - n;
+	for(i = 0; i < n; i++) {
+		saved[i] = b[i] & markmask;
+		b[i] &= ~markmask;
+	}
+
+	if(checkmarkdrop != nil) {
+		off = (uintptr*)checkmarkdrop - (uintptr*)runtime_mheap.arena_start;
+		saved[n - 1 - off/wordsPerBitmapWord] &= ~(bitMarked<<(off % wordsPerBitmapWord));
+	}
+
+	work.nwait = 0;
+	work.ndone = 0;
+	work.nproc = 1;
+	runtime_parforsetup(work.markfor, 1, RootCount + runtime_allglen, false, &markroot_funcval);
+	gchelperstart();
+	runtime_parfordo(work.markfor);
+	scanblock(nil, true);
+	bufferList[m->helpgc].busy = 0;
+
+	missed = 0;
+	for(i = 0; i < n; i++) {
+		x = b[i] & ~saved[i] & markmask;
+		if(x != 0) {
+			for(j = 0; j < wordsPerBitmapWord; j++) {
+				if((x & (bitMarked<<j)) == 0)
+					continue;
+				off = (n - 1 - i)*wordsPerBitmapWord + j;
+				if(missed < 10)
+					runtime_printf("runtime: gccheckmark: object %p is reachable but was not marked\n",
+						(uintptr*)runtime_mheap.arena_start + off);
+				missed++;
+			}
+		}
+		b[i] |= saved[i];
+	}
+	runtime_SysFree(saved, n * sizeof(uintptr), &mstats.gc_sys);
+
+	if(missed > 0) {
+		runtime_printf("runtime: gccheckmark: %D reachable objects not marked\n", (int64)missed);
+		runtime_throw("gccheckmark: mark phase missed reachable objects");
+	}
+}
+
 static void
 gc(struct gc_args *args)
 {
@@ -2274,6 +2541,11 @@ gc(struct gc_args *args)
 	bufferList[m->helpgc].busy = 0;
 	if(work.nproc > 1)
 		runtime_notesleep(&work.alldone);
+	if(work.nmarking != 0)
+		runtime_throw("gc: helper still marking after mark phase");
+
+	if(runtime_debug.gccheckmark > 0)
+		checkmark();
 
 	cachestats();
 	// next_gc calculation is tricky with concurrent sweep since we don't know size of live heap
@@ -2284,11 +2556,17 @@ gc(struct gc_args *args)
 	mstats.next_gc = mstats.heap_alloc+(mstats.heap_alloc-runtime_stacks_sys)*gcpercent/100;
 
 	t4 = runtime_nanotime();
+	pacegc(args, t4, heap0);
 	mstats.last_gc = runtime_unixnanotime();  // must be Unix time to make sense to user
 	mstats.pause_ns[mstats.numgc%nelem(mstats.pause_ns)] = t4 - t0;
 	mstats.pause_end[mstats.numgc%nelem(mstats.pause_end)] = mstats.last_gc;
 	mstats.pause_total_ns += t4 - t0;
 	mstats.numgc++;
+	// The world was stopped for the whole pause, so every P was
+	// given over to the collector.  Sweeping done after the pause
+	// is not counted.
+	work.totaltime += (t4 - t0) * runtime_gomaxprocs;
+	mstats.gc_cpu_fraction = (float64)work.totaltime / runtime_schedtotaltime(t4);
 	if(mstats.debuggc)
 		runtime_printf("pause %D\n", t4-t0);
 
@@ -2362,7 +2640,7 @@ gc(struct gc_args *args)
 	if(ConcurrentSweep && !args->eagersweep) {
 		runtime_lock(&gclock);
 		if(sweep.g == nil)
-			sweep.g = __go_go(bgsweep, nil);
+			sweep.g = runtime_gonolimit(bgsweep, nil);
 		else if(sweep.parked) {
 			sweep.parked = false;
 			runtime_ready(sweep.g);
@@ -2395,7 +2673,7 @@ runtime_ReadMemStats(MStats *stats)
 	// because stoptheworld can only be used by
 	// one goroutine at a time, and there might be
 	// a pending garbage collection already calling it.
-	runtime_semacquire(&runtime_worldsema, false);
+	runtime_semacquire(&runtime_worldsema, 0);
 	m = runtime_m();
 	m->gcing = 1;
 	runtime_stoptheworld();
@@ -2473,32 +2751,70 @@ gchelperstart(void)
 		runtime_throw("gchelper not running on g0 stack");
 }
 
+// Call the finalizer f.
+static void
+callfinalizer(Finalizer *f)
+{
+	const Type *fint;
+	void *param;
+	Eface ef;
+	Iface iface;
+
+	fint = ((const Type**)f->ft->__in.array)[0];
+	if((fint->__code & kindMask) == kindPtr) {
+		// direct use of pointer
+		param = &f->arg;
+	} else if(((const InterfaceType*)fint)->__methods.__count == 0) {
+		// convert to empty interface
+		ef.__type_descriptor = (const Type*)f->ot;
+		ef.__object = f->arg;
+		param = &ef;
+	} else {
+		// convert to interface with methods
+		iface.__methods = __go_convert_interface_2((const Type*)fint,
+							   (const Type*)f->ot,
+							   1);
+		iface.__object = f->arg;
+		if(iface.__methods == nil)
+			runtime_throw("invalid type conversion in runfinq");
+		param = &iface;
+	}
+	reflect_call(f->ft, f->fn, 0, 0, &param, nil);
+}
+
+// Run one finalizer in its own goroutine, for GODEBUG=asyncfinalizers=1.
+// The goroutine runs only user code, so it is not a system goroutine:
+// it shows up in stack dumps, and its panics are ordinary panics.
+static void
+runfin1(void *arg)
+{
+	callfinalizer((Finalizer*)arg);
+}
+
 static void
 runfinq(void* dummy __attribute__ ((unused)))
 {
-	Finalizer *f;
+	Finalizer *f, *f1;
 	FinBlock *fb, *next;
 	uint32 i;
-	Eface ef;
-	Iface iface;
 
 	// This function blocks for long periods of time, and because it is written in C
 	// we have no liveness information. Zero everything so that uninitialized pointers
 	// do not cause memory leaks.
 	f = nil;
+	f1 = nil;
 	fb = nil;
 	next = nil;
 	i = 0;
-	ef.__type_descriptor = nil;
-	ef.__object = nil;
 	
 	// force flush to memory
 	USED(&f);
+	USED(&f1);
 	USED(&fb);
 	USED(&next);
 	USED(&i);
-	USED(&ef);
 
+	runtime_setsystemg(runtime_g());
 	for(;;) {
 		runtime_lock(&finlock);
 		fb = finq;
@@ -2514,39 +2830,38 @@ runfinq(void* dummy __attribute__ ((unused)))
 		for(; fb; fb=next) {
 			next = fb->next;
 			for(i=0; i<(uint32)fb->cnt; i++) {
-				const Type *fint;
-				void *param;
-
 				f = &fb->fin[i];
-				fint = ((const Type**)f->ft->__in.array)[0];
-				if((fint->__code & kindMask) == kindPtr) {
-					// direct use of pointer
-					param = &f->arg;
-				} else if(((const InterfaceType*)fint)->__methods.__count == 0) {
-					// convert to empty interface
-					ef.__type_descriptor = (const Type*)f->ot;
-					ef.__object = f->arg;
-					param = &ef;
+				if(runtime_debug.asyncfinalizers) {
+					// A finalizer that blocks must not hold
+					// up the others, so give each its own
+					// goroutine.  The copy keeps the object
+					// alive until the finalizer runs.
+					f1 = runtime_mal(sizeof *f1);
+					*f1 = *f;
+					runtime_gonolimit(runfin1, f1);
+					f1 = nil;
 				} else {
-					// convert to interface with methods
-					iface.__methods = __go_convert_interface_2((const Type*)fint,
-										   (const Type*)f->ot,
-										   1);
-					iface.__object = f->arg;
-					if(iface.__methods == nil)
-						runtime_throw("invalid type conversion in runfinq");
-					param = &iface;
+					fingrunning = true;
+					callfinalizer(f);
+					fingrunning = false;
 				}
-				reflect_call(f->ft, f->fn, 0, 0, &param, nil);
 				f->fn = nil;
 				f->arg = nil;
 				f->ot = nil;
+
+				// Don't hold up a garbage collection.
+				runtime_checkpreempt();
 			}
 			fb->cnt = 0;
 			runtime_lock(&finlock);
 			fb->next = finc;
 			finc = fb;
 			runtime_unlock(&finlock);
+
+			// Give other goroutines a chance to run between
+			// blocks, so that a long queue of finalizers
+			// doesn't starve them.
+			runtime_gosched();
 		}
 
 		// Zero everything that's dead, to avoid memory leaks.
@@ -2555,8 +2870,6 @@ runfinq(void* dummy __attribute__ ((unused)))
 		fb = nil;
 		next = nil;
 		i = 0;
-		ef.__type_descriptor = nil;
-		ef.__object = nil;
 		runtime_gc(1);	// trigger another gc to clean up the finalized objects, if possible
 	}
 }
@@ -2571,10 +2884,19 @@ runtime_createfing(void)
 	// which can queue finalizers, which would deadlock.
 	runtime_lock(&gclock);
 	if(fing == nil)
-		fing = __go_go(runfinq, nil);
+		fing = runtime_gonolimit(runfinq, nil);
 	runtime_unlock(&gclock);
 }
 
+// Report whether gp is a system goroutine.  The finalizer goroutine
+// is one, except while it runs a finalizer: that is user code, whose
+// panics are ordinary panics and which shows up in stack dumps.
+bool
+runtime_issystemg(G *gp)
+{
+	return gp->issystem && !(gp == fing && fingrunning);
+}
+
 G*
 runtime_wakefing(void)
 {
@@ -2613,6 +2935,19 @@ runtime_markscan(void *v)
 	*b |= bitScan<<shift;
 }
 
+// report whether the block at v was allocated as possibly
+// containing pointers.
+bool
+runtime_isscan(void *v)
+{
+	uintptr *b, off, shift;
+
+	off = (uintptr*)v - (uintptr*)runtime_mheap.arena_start;  // word offset
+	b = (uintptr*)runtime_mheap.arena_start - off/wordsPerBitmapWord - 1;
+	shift = off % wordsPerBitmapWord;
+	return ((*b>>shift) & bitScan) != 0;
+}
+
 // mark the block at v as freed.
 void
 runtime_markfreed(void *v)
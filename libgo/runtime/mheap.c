@@ -455,6 +455,7 @@ forcegchelper(void *vnote)
 {
 	Note *note = (Note*)vnote;
 
+	runtime_setsystemg(runtime_g());
 	runtime_gc(1);
 	runtime_notewakeup(note);
 }
@@ -529,8 +530,9 @@ runtime_MHeap_Scavenger(void* dummy)
 	USED(dummy);
 
 	g = runtime_g();
-	g->issystem = true;
+	runtime_setsystemg(g);
 	g->isbackground = true;
+	runtime_bgready();
 
 	// If we go two minutes without a garbage collection, force one to run.
 	forcegc = 2*60*1e9;
@@ -557,7 +559,7 @@ runtime_MHeap_Scavenger(void* dummy)
 			// GC blocks other goroutines via the runtime_worldsema.
 			runtime_noteclear(&note);
 			notep = &note;
-			__go_go(forcegchelper, (void*)notep);
+			runtime_gonolimit(forcegchelper, (void*)notep);
 			runtime_notetsleepg(&note, -1);
 			if(runtime_debug.gctrace > 0)
 				runtime_printf("scvg%d: GC forced\n", k);
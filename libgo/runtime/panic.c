@@ -11,19 +11,62 @@
 uint32 runtime_panicking;
 static Lock paniclk;
 
+// The M whose fatal error is being reported.  Only one crash report
+// is printed: an M that hits a fatal error while another M's report
+// is in progress waits for that M to exit the process.
+static M *panicm;
+
+// Wait forever without chewing up cpu.
+static void
+waitforever(void)
+{
+	static Lock deadlock;
+
+	runtime_lock(&deadlock);
+	runtime_lock(&deadlock);
+}
+
+// Central pool of available defer structs, used when a P's own pool
+// runs dry or overflows.  It is emptied by runtime_cleardeferpool at
+// the start of every garbage collection, so it need not be a root.
+// The gc runtime keeps a pool per size class, as its defer records
+// hold the deferred call's arguments; a gccgo Defer only points to its
+// arguments, so every record has the same size and one pool will do.
+static Lock deferlock;
+static Defer *deferpool;
+
 // Allocate a Defer, usually using per-P pool.
 // Each defer must be released with freedefer.
 Defer*
 runtime_newdefer()
 {
-	Defer *d;
+	Defer *d, **buf;
+	M *mp;
 	P *p;
+	Slice *pool;
 
 	d = nil;
-	p = (P*)runtime_m()->p;
-	d = p->deferpool;
-	if(d)
-		p->deferpool = d->next;
+	mp = runtime_m();
+	mp->locks++;
+	p = (P*)mp->p;
+	pool = &p->deferpool;
+	buf = (Defer**)pool->__values;
+	if(pool->__count == 0 && deferpool != nil) {
+		runtime_lock(&deferlock);
+		while(pool->__count < pool->__capacity/2 && deferpool != nil) {
+			d = deferpool;
+			deferpool = d->next;
+			d->next = nil;
+			buf[pool->__count++] = d;
+		}
+		runtime_unlock(&deferlock);
+	}
+	if(pool->__count > 0) {
+		d = buf[pool->__count-1];
+		buf[pool->__count-1] = nil;
+		pool->__count--;
+	}
+	mp->locks--;
 	if(d == nil) {
 		// deferpool is empty
 		d = runtime_malloc(sizeof(Defer));
@@ -36,15 +79,65 @@ runtime_newdefer()
 void
 runtime_freedefer(Defer *d)
 {
+	Defer *first, *last, *x, **buf;
+	M *mp;
 	P *p;
+	Slice *pool;
 
 	if(d->special)
 		return;
-	p = (P*)runtime_m()->p;
-	d->next = p->deferpool;
-	p->deferpool = d;
-	// No need to wipe out pointers in argp/pc/fn/args,
-	// because we empty the pool before GC.
+	// Clear the pointers so that a pooled defer does not keep the
+	// deferred call's argument alive until the next GC empties the
+	// pool, and so that a stale function can never be called.
+	d->next = nil;
+	d->frame = nil;
+	d->_panic = nil;
+	d->pfn = 0;
+	d->arg = nil;
+	d->panicking = nil;
+	mp = runtime_m();
+	mp->locks++;
+	p = (P*)mp->p;
+	pool = &p->deferpool;
+	buf = (Defer**)pool->__values;
+	if(pool->__count == pool->__capacity) {
+		// Transfer half of local cache to the central cache.
+		first = nil;
+		last = nil;
+		while(pool->__count > pool->__capacity/2) {
+			x = buf[--pool->__count];
+			buf[pool->__count] = nil;
+			if(first == nil)
+				first = x;
+			else
+				last->next = x;
+			last = x;
+		}
+		runtime_lock(&deferlock);
+		last->next = deferpool;
+		deferpool = first;
+		runtime_unlock(&deferlock);
+	}
+	buf[pool->__count++] = d;
+	mp->locks--;
+}
+
+// Drop the central defer pool, called by the garbage collector.
+// The per-P pools are left alone; their size is bounded.
+void
+runtime_cleardeferpool(void)
+{
+	Defer *d, *dnext;
+
+	runtime_lock(&deferlock);
+	// Disconnect cached list before dropping it on the floor,
+	// so that a dangling ref to one entry does not pin all of them.
+	for(d = deferpool; d != nil; d = dnext) {
+		dnext = d->next;
+		d->next = nil;
+	}
+	deferpool = nil;
+	runtime_unlock(&deferlock);
 }
 
 // Run all deferred functions for the current goroutine.
@@ -87,6 +180,14 @@ runtime_startpanic(void)
 			runtime_g()->writebuf = nil;
 		runtime_xadd(&runtime_panicking, 1);
 		runtime_lock(&paniclk);
+		if(panicm != nil) {
+			// Some other m is already reporting a fatal
+			// error and will exit the process.  Don't
+			// interleave a second report with it.
+			runtime_unlock(&paniclk);
+			waitforever();
+		}
+		panicm = m;
 		if(runtime_debug.schedtrace > 0 || runtime_debug.scheddetail > 0)
 			runtime_schedtrace(true);
 		runtime_freezetheworld();
@@ -113,15 +214,26 @@ runtime_startpanic(void)
 void
 runtime_dopanic(int32 unused __attribute__ ((unused)))
 {
-	G *g;
+	G *g, *sg;
+	const char *signame;
 	static bool didothers;
 	bool crash;
 	int32 t;
 
 	g = runtime_g();
-	if(g->sig != 0)
-		runtime_printf("[signal %x code=%p addr=%p]\n",
-			       g->sig, (void*)g->sigcode0, (void*)g->sigcode1);
+	if(g->sig != 0) {
+		signame = runtime_signame(g->sig);
+		if(signame != nil)
+			runtime_printf("[signal %s", signame);
+		else
+			runtime_printf("[signal %x", g->sig);
+		runtime_printf(" code=%p addr=%p pc=%p]",
+			       (void*)g->sigcode0, (void*)g->sigcode1, (void*)g->sigpc);
+		// For a fatal signal, say which goroutine it interrupted.
+		if((sg = (G*)runtime_m()->caughtsig) != nil)
+			runtime_printf(" goroutine %D running", sg->goid);
+		runtime_printf("\n");
+	}
 
 	if((t = runtime_gotraceback(&crash)) > 0){
 		if(g != runtime_m()->g0) {
@@ -139,16 +251,10 @@ runtime_dopanic(int32 unused __attribute__ ((unused)))
 		}
 	}
 	runtime_unlock(&paniclk);
-	if(runtime_xadd(&runtime_panicking, -1) != 0) {
-		// Some other m is panicking too.
-		// Let it print what it needs to print.
-		// Wait forever without chewing up cpu.
-		// It will exit when it's done.
-		static Lock deadlock;
-		runtime_lock(&deadlock);
-		runtime_lock(&deadlock);
-	}
-	
+	// Any other m that is panicking is waiting in
+	// runtime_startpanic for us to exit, so don't wait for it.
+	runtime_xadd(&runtime_panicking, -1);
+
 	if(crash)
 		runtime_crash();
 
@@ -240,6 +346,23 @@ runtime_Goexit(void)
 	runtime_goexit();
 }
 
+static void
+systempanic(void *arg __attribute__ ((unused)))
+{
+	runtime_setsystemg(runtime_g());
+	runtime_panicstring("injected system goroutine fault");
+}
+
+void runtime_startsystempanic(void)
+  __asm__(GOSYM_PREFIX "runtime.startsystempanic");
+
+// For testing: start a system goroutine that panics.
+void
+runtime_startsystempanic(void)
+{
+	runtime_gonolimit(systempanic, nil);
+}
+
 void
 runtime_panicdivide(void)
 {
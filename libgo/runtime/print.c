@@ -9,7 +9,43 @@
 #include "array.h"
 #include "go-type.h"
 
-//static Lock debuglock;
+static Lock debuglock;
+
+// The compiler emits calls to printlock and printunlock around
+// the multiple calls that implement a single Go print or println
+// statement, and go_vprintf takes the lock for each runtime_printf.
+// A crash can happen during the print routines and need to take the
+// print lock to print information about the crash, and a signal can
+// arrive on the same M while it holds the lock, so let an M acquire
+// the lock 'recursively'.
+
+void
+runtime_printlock(void)
+{
+	M *m;
+
+	m = runtime_m();
+	if(m == nil)
+		return;
+	m->locks++;	// do not reschedule between printlock++ and lock(&debuglock).
+	m->printlock++;
+	if(m->printlock == 1)
+		runtime_lock(&debuglock);
+	m->locks--;	// now we know debuglock is held and holding up m->locks for us.
+}
+
+void
+runtime_printunlock(void)
+{
+	M *m;
+
+	m = runtime_m();
+	if(m == nil)
+		return;
+	m->printlock--;
+	if(m->printlock == 0)
+		runtime_unlock(&debuglock);
+}
 
 // Clang requires this function to not be inlined (see below).
 static void go_vprintf(const char*, va_list)
@@ -123,7 +159,7 @@ go_vprintf(const char *s, va_list va)
 {
 	const char *p, *lp;
 
-	//runtime_lock(&debuglock);
+	runtime_printlock();
 
 	lp = p = s;
 	for(; *p; p++) {
@@ -184,7 +220,7 @@ go_vprintf(const char *s, va_list va)
 	if(p > lp)
 		gwrite(lp, p-lp);
 
-	//runtime_unlock(&debuglock);
+	runtime_printunlock();
 }
 
 void
@@ -32,6 +32,8 @@ extern void *__splitstack_makecontext(size_t, void *context[10], size_t *);
 
 extern void * __splitstack_resetcontext(void *context[10], size_t *);
 
+extern void __splitstack_releasecontext(void *context[10]);
+
 extern void *__splitstack_find(void *, void *, size_t *, void **, void **,
 			       void **);
 
@@ -246,12 +248,15 @@ static void
 kickoff(void)
 {
 	void (*fn)(void*);
+	void *param;
 
 	if(g->traceback != nil)
 		gtraceback(g);
 
 	fn = (void (*)(void*))(g->entry);
-	fn(g->param);
+	param = g->param;
+	g->param = nil;
+	fn(param);
 	runtime_goexit();
 }
 
@@ -352,8 +357,12 @@ struct Sched {
 	int32	nmidle;	 // number of idle m's waiting for work
 	int32	nmidlelocked; // number of locked m's waiting for work
 	int32	mcount;	 // number of m's that have been created
+	int32	nmfreed;	 // number of m's that have exited
+	M*	freem;		 // exited m's whose threads may still use them
 	int32	maxmcount;	// maximum number of m's allowed (or die)
 
+	uint32	ngsys;	// number of system goroutines; updated atomically
+
 	P*	pidle;  // idle P's
 	uint32	npidle;
 	uint32	nmspinning;
@@ -366,15 +375,38 @@ struct Sched {
 	// Global cache of dead G's.
 	Lock	gflock;
 	G*	gfree;
+	G*	gfreenostack;	// dead G's whose stacks were released
+	int32	ngfree;
+
+	// Central cache of sudog structs.
+	Lock	sudoglock;
+	SudoG*	sudogcache;
+	uint32	nsudogalloc;	// sudogs allocated, for testing
 
 	uint32	gcwaiting;	// gc is waiting to run
 	int32	stopwait;
 	Note	stopnote;
 	uint32	sysmonwait;
 	Note	sysmonnote;
+	uint32	sysmontick;	// sysmon loop iterations, for testing
 	uint64	lastpoll;
 
 	int32	profilehz;	// cpu profiling rate
+
+	// Goroutine limit; see runtime_setMaxGoroutines.
+	int32	maxg;	// 0 for no limit
+	int32	nadmitted;	// goroutines counting against maxg
+	G*	heldhead;	// new goroutines waiting to be admitted
+	G*	heldtail;
+
+	int64	totaltime;	// gomaxprocs * elapsed ns, up to procresizetime
+	int64	procresizetime;	// nanotime() of last change to gomaxprocs
+
+	// safePointFn should be called on each P at the next GC
+	// safepoint if p->runSafePointFn is set.
+	void	(*safePointFn)(P*);
+	int32	safePointWait;
+	Note	safePointNote;
 };
 
 enum
@@ -398,21 +430,25 @@ int32	runtime_ncpu;
 bool	runtime_precisestack;
 static int32	newprocs;
 
-static	Lock allglock;	// the following vars are protected by this lock or by stoptheworld
+Lock	runtime_allglock;	// the following vars are protected by this lock or by stoptheworld
 G**	runtime_allg;
 uintptr runtime_allglen;
 static	uintptr allgcap;
+static	G**	allgsort;	// room for allgcap G's, for runtime_tracebackothers to sort
 
 bool	runtime_isarchive;
 
 void* runtime_mstart(void*);
-static void runqput(P*, G*);
-static G* runqget(P*);
+static void runqput(P*, G*, bool);
+static G* runqget(P*, bool*);
 static bool runqputslow(P*, G*, uint32, uint32);
-static G* runqsteal(P*, P*);
+static G* runqsteal(P*, P*, bool);
+static bool runqempty(P*);
 static void mput(M*);
 static M* mget(void);
 static void mcommoninit(M*);
+static void mexit(void);
+static void gstackfree(G*);
 static void schedule(void);
 static void procresize(int32);
 static void acquirep(P*);
@@ -426,6 +462,7 @@ static void stoplockedm(void);
 static void startlockedm(G*);
 static void sysmon(void);
 static uint32 retake(int64);
+static void checksyscallstall(int64);
 static void incidlelocked(int32);
 static void checkdead(void);
 static void exitsyscall0(G*);
@@ -435,14 +472,21 @@ static void gfput(P*, G*);
 static G* gfget(P*);
 static void gfpurge(P*);
 static void globrunqput(G*);
+static bool holdg(G*);
+static int32 admitg(void);
+static void admitheld(void);
+static void limitunpark(G*);
 static void globrunqputbatch(G*, G*, int32);
 static G* globrunqget(P*, int32);
 static P* pidleget(void);
 static void pidleput(P*);
 static void injectglist(G*);
 static bool preemptall(void);
+static bool preemptone(P*);
+static void runSafePointFn(void);
 static bool exitsyscallfast(void);
 static void allgadd(G*);
+static void setrunsince(G*);
 
 bool runtime_isstarted;
 
@@ -572,6 +616,26 @@ initDone(void *arg __attribute__ ((unused))) {
 	runtime_unlockOSThread();
 };
 
+// Woken by each background system goroutine or thread started by
+// runtime_main once it is running.  runtime_main waits for each of
+// them in turn, so that they are all up before initialization is
+// complete and, for -buildmode=c-archive or c-shared, before any
+// call from C into Go can proceed.
+static Note bgready;
+
+void
+runtime_bgready(void)
+{
+	runtime_notewakeup(&bgready);
+}
+
+static void
+waitbgready(void)
+{
+	runtime_notetsleepg(&bgready, -1);
+	runtime_noteclear(&bgready);
+}
+
 // The main goroutine.
 // Note: C frames in general are not copyable during stack growth, for two reasons:
 //   1) We don't know where in a frame to find pointers to other stack locations.
@@ -589,6 +653,7 @@ runtime_main(void* dummy __attribute__((unused)))
 	_Bool frame;
 	
 	newm(sysmon, nil);
+	waitbgready();
 
 	// Lock the main goroutine onto this, the main OS thread,
 	// during initialization.  Most programs won't care, but a few
@@ -611,7 +676,8 @@ runtime_main(void* dummy __attribute__((unused)))
 
 	if(g->m != &runtime_m0)
 		runtime_throw("runtime_main not on m0");
-	__go_go(runtime_MHeap_Scavenger, nil);
+	runtime_gonolimit(runtime_MHeap_Scavenger, nil);
+	waitbgready();
 
 	runtime_main_init_done = __go_new_channel(&chan_bool_type_descriptor, 0);
 
@@ -657,7 +723,7 @@ void
 runtime_goroutineheader(G *gp)
 {
 	String status;
-	int64 waitfor;
+	int64 waitfor, runfor;
 
 	switch(gp->atomicstatus) {
 	case _Gidle:
@@ -672,6 +738,9 @@ runtime_goroutineheader(G *gp)
 	case _Gsyscall:
 		status = runtime_gostringnocopy((const byte*)"syscall");
 		break;
+	case _Gcopystack:
+		status = runtime_gostringnocopy((const byte*)"copystack");
+		break;
 	case _Gwaiting:
 		if(gp->waitreason.len > 0)
 			status = gp->waitreason;
@@ -688,10 +757,28 @@ runtime_goroutineheader(G *gp)
 	if((gp->atomicstatus == _Gwaiting || gp->atomicstatus == _Gsyscall) && gp->waitsince != 0)
 		waitfor = (runtime_nanotime() - gp->waitsince) / (60LL*1000*1000*1000);
 
-	if(waitfor < 1)
-		runtime_printf("goroutine %D [%S]:\n", gp->goid, status);
-	else
+	// time the G has been running or waiting to run, in seconds;
+	// either is worth noting well before a minute
+	runfor = 0;
+	if(runtime_debug.runsince > 0 && (gp->atomicstatus == _Grunning || gp->atomicstatus == _Grunnable) && gp->runsince != 0)
+		runfor = (runtime_nanotime() - gp->runsince) / (1000*1000*1000);
+
+	if(waitfor >= 1)
 		runtime_printf("goroutine %D [%S, %D minutes]:\n", gp->goid, status, waitfor);
+	else if(runfor >= 1)
+		runtime_printf("goroutine %D [%S, %D seconds]:\n", gp->goid, status, runfor);
+	else
+		runtime_printf("goroutine %D [%S]:\n", gp->goid, status);
+}
+
+// Record when gp became runnable or started running, for
+// runtime_goroutineheader.  Reading the clock on every scheduling
+// event is not free, so this is only done with GODEBUG=runsince=1.
+static void
+setrunsince(G *gp)
+{
+	if(runtime_debug.runsince > 0)
+		gp->runsince = runtime_nanotime();
 }
 
 void
@@ -709,13 +796,75 @@ runtime_printcreatedby(G *g)
 	}
 }
 
+// Print the stack of a goroutine in a cgo call on mp.  We can't walk
+// the C frames, so they show up as a marker, followed by the Go
+// frames that syscall_cgocall recorded, if any.
+static void
+printcgocallers(M *mp)
+{
+	int32 i;
+	String fn, file;
+	intgo line;
+
+	runtime_printf("[cgo call]\n");
+	if(mp->ncgocallers == 0) {
+		runtime_printf("\tGo stack unavailable; GODEBUG=cgocallers=1 records it\n");
+		return;
+	}
+	for(i = 0; i < mp->ncgocallers; i++) {
+		if(!__go_file_line(mp->cgocallers[i] - 1, -1, &fn, &file, &line))
+			continue;
+		if(runtime_showframe(fn, false)) {
+			runtime_printf("%S\n", fn);
+			runtime_printf("\t%S:%D\n", file, (int64)line);
+		}
+	}
+}
+
+// Move gs[k] down the heap gs[0:n] ordered by goid.
+static void
+siftgs(G **gs, uintptr k, uintptr n)
+{
+	uintptr j;
+	G *t;
+
+	while((j = 2*k + 1) < n) {
+		if(j+1 < n && gs[j+1]->goid > gs[j]->goid)
+			j++;
+		if(gs[k]->goid >= gs[j]->goid)
+			break;
+		t = gs[k];
+		gs[k] = gs[j];
+		gs[j] = t;
+		k = j;
+	}
+}
+
+// Sort gs by goid.  This is a heap sort so that it needs no memory
+// and no recursion; it runs while printing a crash.
+static void
+sortgs(G **gs, uintptr n)
+{
+	uintptr i;
+	G *t;
+
+	for(i = n/2; i > 0; i--)
+		siftgs(gs, i-1, n);
+	for(i = n; i > 1; i--) {
+		t = gs[0];
+		gs[0] = gs[i-1];
+		gs[i-1] = t;
+		siftgs(gs, 0, i-1);
+	}
+}
+
 void
 runtime_tracebackothers(G * volatile me)
 {
 	G * volatile gp;
 	Traceback tb;
 	int32 traceback;
-	volatile uintptr i;
+	volatile uintptr i, n;
 
 	tb.gp = me;
 	traceback = runtime_gotraceback(nil);
@@ -739,12 +888,20 @@ runtime_tracebackothers(G * volatile me)
 		runtime_printcreatedby(gp);
 	}
 
-	runtime_lock(&allglock);
-	for(i = 0; i < runtime_allglen; i++) {
-		gp = runtime_allg[i];
+	runtime_lock(&runtime_allglock);
+
+	// Print the goroutines in goid order, so that dumps are stable
+	// from run to run.  Sort them in the space that allgadd set
+	// aside, as we may be crashing and unable to allocate.
+	n = runtime_allglen;
+	runtime_memmove(allgsort, runtime_allg, n * sizeof(G*));
+	sortgs(allgsort, n);
+
+	for(i = 0; i < n; i++) {
+		gp = allgsort[i];
 		if(gp == me || gp == g->m->curg || gp->atomicstatus == _Gdead)
 			continue;
-		if(gp->issystem && traceback < 2)
+		if(runtime_issystemg(gp) && traceback < 2)
 			continue;
 		runtime_printf("\n");
 		runtime_goroutineheader(gp);
@@ -761,8 +918,16 @@ runtime_tracebackothers(G * volatile me)
 		if(gp->atomicstatus == _Grunning) {
 			runtime_printf("\tgoroutine running on other thread; stack unavailable\n");
 			runtime_printcreatedby(gp);
+		} else if(gp->atomicstatus == _Gcopystack) {
+			// The stack is moving, so its saved context can't
+			// be trusted; don't switch to it.
+			runtime_printf("\tgoroutine stack is being copied; stack unavailable\n");
+			runtime_printcreatedby(gp);
 		} else if(gp->atomicstatus == _Gsyscall) {
-			runtime_printf("\tgoroutine in C code; stack unavailable\n");
+			if(gp->m != nil && gp->m->ncgo > 0)
+				printcgocallers(gp->m);
+			else
+				runtime_printf("\tgoroutine in C code; stack unavailable\n");
 			runtime_printcreatedby(gp);
 		} else {
 			gp->traceback = &tb;
@@ -780,15 +945,98 @@ runtime_tracebackothers(G * volatile me)
 			runtime_printcreatedby(gp);
 		}
 	}
-	runtime_unlock(&allglock);
+	runtime_unlock(&runtime_allglock);
+}
+
+// Collect the stack of gp into tb, the same way that
+// runtime_tracebackothers does when printing it.  Only a runnable or
+// waiting goroutine has a saved context that we can switch to, so
+// this reports false for any other gp, including the current one.
+// The world must be stopped.
+bool
+runtime_tracebackg(G *gp, Traceback *tb)
+{
+	G * volatile me;
+	G * volatile vgp;
+
+	if(gp->atomicstatus != _Grunnable && gp->atomicstatus != _Gwaiting)
+		return false;
+
+	me = g;
+	vgp = gp;
+	tb->gp = me;
+	vgp->traceback = tb;
+
+#ifdef USING_SPLIT_STACK
+	__splitstack_getcontext(&me->stackcontext[0]);
+#endif
+	getcontext(ucontext_arg(&me->context[0]));
+
+	if(vgp->traceback != nil) {
+		runtime_gogo(vgp);
+	}
+	return true;
+}
+
+static bool
+samecreatestack(M *a, M *b)
+{
+	uintptr i;
+
+	for(i = 0; i < nelem(a->createstack); i++) {
+		if(a->createstack[i].pc != b->createstack[i].pc)
+			return false;
+		if(a->createstack[i].pc == 0)
+			break;
+	}
+	return true;
+}
+
+// Print the stack that created the most threads, to show where a
+// program that ran out of threads is creating them.
+static void
+printcreatestack(void)
+{
+	M *mp, *mp1, *best;
+	int32 n, nbest;
+	uintptr i;
+	Location *loc;
+
+	best = nil;
+	nbest = 0;
+	for(mp = runtime_allm; mp; mp = mp->alllink) {
+		// Threads started without an mcache have no stack.
+		if(mp->createstack[0].pc == 0)
+			continue;
+		n = 0;
+		for(mp1 = runtime_allm; mp1; mp1 = mp1->alllink)
+			if(samecreatestack(mp, mp1))
+				n++;
+		if(n > nbest) {
+			best = mp;
+			nbest = n;
+		}
+	}
+	if(best == nil)
+		return;
+
+	runtime_printf("runtime: %d threads created at:\n", nbest);
+	for(i = 0; i < nelem(best->createstack); i++) {
+		loc = &best->createstack[i];
+		if(loc->pc == 0)
+			break;
+		runtime_printf("%S\n", loc->function);
+		runtime_printf("\t%S:%D\n", loc->filename, (int64)loc->lineno);
+	}
 }
 
 static void
 checkmcount(void)
 {
 	// sched lock is held
-	if(runtime_sched.mcount > runtime_sched.maxmcount) {
+	if(runtime_sched.mcount - runtime_sched.nmfreed > runtime_sched.maxmcount) {
 		runtime_printf("runtime: program exceeds %d-thread limit\n", runtime_sched.maxmcount);
+		printcreatestack();
 		runtime_throw("thread exhaustion");
 	}
 }
@@ -846,8 +1094,11 @@ runtime_ready(G *gp)
 		runtime_printf("goroutine %D has status %d\n", gp->goid, gp->atomicstatus);
 		runtime_throw("bad g->atomicstatus in ready");
 	}
+	if(runtime_traceenabled)
+		runtime_tracegounpark(gp);
+	limitunpark(gp);
 	gp->atomicstatus = _Grunnable;
-	runqput((P*)g->m->p, gp);
+	runqput((P*)g->m->p, gp, true);
 	if(runtime_atomicload(&runtime_sched.npidle) != 0 && runtime_atomicload(&runtime_sched.nmspinning) == 0)  // TODO: fast atomic
 		wakep();
 	g->m->locks--;
@@ -957,8 +1208,12 @@ runtime_stoptheworld(void)
 	for(i = 0; i < runtime_gomaxprocs; i++) {
 		p = runtime_allp[i];
 		s = p->status;
-		if(s == _Psyscall && runtime_cas(&p->status, s, _Pgcstop))
+		if(s == _Psyscall && runtime_cas(&p->status, s, _Pgcstop)) {
+			if(runtime_traceenabled)
+				runtime_tracegosysblock(p);
+			p->syscalltick++;
 			runtime_sched.stopwait--;
+		}
 	}
 	// stop idle P's
 	while((p = pidleget()) != nil) {
@@ -980,6 +1235,117 @@ runtime_stoptheworld(void)
 		if(p->status != _Pgcstop)
 			runtime_throw("stoptheworld: not stopped");
 	}
+	if(runtime_traceenabled)
+		runtime_traceflushps();
+}
+
+// Call fn(p) for every P p when p reaches a safe point.  If a P is
+// running a goroutine, ask it to stop at its next preemption point
+// and run fn there.  If a P is idle or in a system call, run fn(p)
+// directly while keeping the P in that state.  Waits until every P
+// has run fn exactly once.
+// Must not be called with the world stopped or with sched locked.
+void
+runtime_forEachP(void (*fn)(P*))
+{
+	int32 i;
+	uint32 s;
+	P *p, *p1;
+	bool wait;
+
+	g->m->locks++;
+	p1 = (P*)g->m->p;
+
+	runtime_lock(&runtime_sched);
+	if(runtime_sched.safePointWait != 0)
+		runtime_throw("forEachP: sched.safePointWait != 0");
+	runtime_sched.safePointWait = runtime_gomaxprocs - 1;
+	runtime_sched.safePointFn = fn;
+
+	// Ask all P's to run the safe point function.
+	for(i = 0; i < runtime_gomaxprocs; i++) {
+		p = runtime_allp[i];
+		if(p != p1)
+			runtime_atomicstore(&p->runSafePointFn, 1);
+	}
+	preemptall();
+
+	// Any P entering _Pidle or _Psyscall from now on will observe
+	// p->runSafePointFn == 1 and will call runSafePointFn when
+	// changing its status to _Pidle/_Psyscall.
+
+	// Run safe point function for all idle P's.  sched.pidle will
+	// not change because we hold sched.
+	for(p = runtime_sched.pidle; p != nil; p = (P*)p->link) {
+		if(runtime_cas(&p->runSafePointFn, 1, 0)) {
+			fn(p);
+			runtime_sched.safePointWait--;
+		}
+	}
+
+	wait = runtime_sched.safePointWait > 0;
+	runtime_unlock(&runtime_sched);
+
+	// Run fn for the current P.
+	fn(p1);
+
+	// Force P's currently in _Psyscall into _Pidle and hand them
+	// off to induce safe point function execution.
+	for(i = 0; i < runtime_gomaxprocs; i++) {
+		p = runtime_allp[i];
+		s = p->status;
+		if(s == _Psyscall && p->runSafePointFn == 1 && runtime_cas(&p->status, s, _Pidle)) {
+			p->syscalltick++;
+			handoffp(p);
+		}
+	}
+
+	// Wait for remaining P's to run fn.
+	if(wait) {
+		for(;;) {
+			// Wait for 100us, then try to re-preempt in
+			// case of any races.
+			if(runtime_notetsleep(&runtime_sched.safePointNote, 100*1000)) {
+				runtime_noteclear(&runtime_sched.safePointNote);
+				break;
+			}
+			preemptall();
+		}
+	}
+	if(runtime_sched.safePointWait != 0)
+		runtime_throw("forEachP: not done");
+	for(i = 0; i < runtime_gomaxprocs; i++) {
+		p = runtime_allp[i];
+		if(p->runSafePointFn != 0)
+			runtime_throw("forEachP: P did not run fn");
+	}
+
+	runtime_lock(&runtime_sched);
+	runtime_sched.safePointFn = nil;
+	runtime_unlock(&runtime_sched);
+	g->m->locks--;
+}
+
+// Run the forEachP safe point function for the current P, if
+// requested.  Called at the safe points: in the scheduler, before
+// giving up the P, and on entry to a system call.
+static void
+runSafePointFn(void)
+{
+	P *p;
+
+	p = (P*)g->m->p;
+	// Resolve the race between forEachP running the safe-point
+	// function on this P's behalf and this P running the
+	// safe-point function directly.
+	if(!runtime_cas(&p->runSafePointFn, 1, 0))
+		return;
+	runtime_sched.safePointFn(p);
+	runtime_lock(&runtime_sched);
+	runtime_sched.safePointWait--;
+	if(runtime_sched.safePointWait == 0)
+		runtime_notewakeup(&runtime_sched.safePointNote);
+	runtime_unlock(&runtime_sched);
 }
 
 static void
@@ -1012,7 +1378,7 @@ runtime_starttheworld(void)
 	while((p = pidleget()) != nil) {
 		// procresize() puts p's with work at the beginning of the list.
 		// Once we reach a p without a run queue, the rest don't have one either.
-		if(p->runqhead == p->runqtail) {
+		if(runqempty(p)) {
 			pidleput(p);
 			break;
 		}
@@ -1090,7 +1456,13 @@ runtime_mstart(void* mp)
 		void (*pfn)(G*) = (void (*)(G*))g->entry;
 		G* gp = (G*)g->param;
 		pfn(gp);
-		*(int*)0x21 = 0x21;
+		// Only goexit0 returns, after mexit, to let the
+		// thread exit.  This is the last use of the M.
+		if(g->m->freeWait == 0)
+			*(int*)0x21 = 0x21;
+		runtime_atomicstore(&g->m->freeWait, 0);
+		g = nil;
+		return nil;
 	}
 	runtime_minit();
 
@@ -1158,6 +1530,21 @@ runtime_allocm(P *p, int32 stacksize, byte** ret_g0_stack, uintptr* ret_g0_stack
 	}
 #endif
 
+	// Drop the M's of exited threads that are done with them.
+	if(runtime_sched.freem != nil) {
+		M **pprev;
+
+		runtime_lock(&runtime_sched);
+		pprev = &runtime_sched.freem;
+		while((mp = *pprev) != nil) {
+			if(runtime_atomicload(&mp->freeWait) != 0)
+				pprev = &mp->freelink;
+			else
+				*pprev = mp->freelink;
+		}
+		runtime_unlock(&runtime_sched);
+	}
+
 	mp = runtime_mal(sizeof *mp);
 	mcommoninit(mp);
 	mp->g0 = runtime_malg(stacksize, ret_g0_stack, ret_g0_stacksize);
@@ -1482,9 +1869,9 @@ retry:
 	m = g->m;
 	runtime_noteclear(&m->park);
 	if(m->helpgc) {
+		// runtime_gchelper clears m->helpgc and m->mcache
+		// before it reports that it is done.
 		runtime_gchelper();
-		m->helpgc = 0;
-		m->mcache = nil;
 		goto retry;
 	}
 	acquirep((P*)m->nextp);
@@ -1538,7 +1925,7 @@ static void
 handoffp(P *p)
 {
 	// if it has local work, start it straight away
-	if(p->runqhead != p->runqtail || runtime_sched.runqsize) {
+	if(!runqempty(p) || runtime_sched.runqsize) {
 		startm(p, false);
 		return;
 	}
@@ -1557,6 +1944,12 @@ handoffp(P *p)
 		runtime_unlock(&runtime_sched);
 		return;
 	}
+	if(p->runSafePointFn && runtime_cas(&p->runSafePointFn, 1, 0)) {
+		runtime_sched.safePointFn(p);
+		runtime_sched.safePointWait--;
+		if(runtime_sched.safePointWait == 0)
+			runtime_notewakeup(&runtime_sched.safePointNote);
+	}
 	if(runtime_sched.runqsize) {
 		runtime_unlock(&runtime_sched);
 		startm(p, false);
@@ -1654,9 +2047,11 @@ gcstopm(void)
 }
 
 // Schedules gp to run on the current M.
+// If inheritTime is true, gp inherits the remaining time in the
+// current time slice. Otherwise, it starts a new time slice.
 // Never returns.
 static void
-execute(G *gp)
+execute(G *gp, bool inheritTime)
 {
 	int32 hz;
 
@@ -1666,10 +2061,21 @@ execute(G *gp)
 	}
 	gp->atomicstatus = _Grunning;
 	gp->waitsince = 0;
-	((P*)g->m->p)->schedtick++;
+	setrunsince(gp);
+	if(!inheritTime)
+		((P*)g->m->p)->schedtick++;
 	g->m->curg = gp;
 	gp->m = g->m;
 
+	if(runtime_traceenabled) {
+		// GoSysExit has to happen when we have a P, but before GoStart.
+		// So we emit it here.
+		if(gp->sysexitticks != 0 && gp->sysblocktraced)
+			runtime_tracegosysexit(gp, gp->sysexitticks);
+		runtime_tracegostart(gp);
+	}
+	gp->sysexitticks = 0;
+
 	// Check whether the profiler needs to be turned on or off.
 	hz = runtime_sched.profilehz;
 	if(g->m->profilehz != hz)
@@ -1681,21 +2087,24 @@ execute(G *gp)
 // Finds a runnable goroutine to execute.
 // Tries to steal from other P's, get g from global queue, poll network.
 static G*
-findrunnable(void)
+findrunnable(bool *inheritTime)
 {
 	G *gp;
 	P *p;
 	int32 i;
+	bool stealRunNextG;
 
 top:
 	if(runtime_sched.gcwaiting) {
 		gcstopm();
 		goto top;
 	}
+	if(((P*)g->m->p)->runSafePointFn)
+		runSafePointFn();
 	if(runtime_fingwait && runtime_fingwake && (gp = runtime_wakefing()) != nil)
 		runtime_ready(gp);
 	// local runq
-	gp = runqget((P*)g->m->p);
+	gp = runqget((P*)g->m->p, inheritTime);
 	if(gp)
 		return gp;
 	// global runq
@@ -1703,14 +2112,21 @@ top:
 		runtime_lock(&runtime_sched);
 		gp = globrunqget((P*)g->m->p, 0);
 		runtime_unlock(&runtime_sched);
-		if(gp)
+		if(gp) {
+			*inheritTime = false;
 			return gp;
+		}
 	}
 	// poll network
 	gp = runtime_netpoll(false);  // non-blocking
 	if(gp) {
 		injectglist((G*)gp->schedlink);
+		gp->schedlink = 0;
+		limitunpark(gp);
 		gp->atomicstatus = _Grunnable;
+		if(runtime_traceenabled)
+			runtime_tracegounpark(gp);
+		*inheritTime = false;
 		return gp;
 	}
 	// If number of spinning M's >= number of busy P's, block.
@@ -1723,27 +2139,32 @@ top:
 		runtime_xadd(&runtime_sched.nmspinning, 1);
 	}
 	// random steal from other P's
-	for(i = 0; i < 2*runtime_gomaxprocs; i++) {
+	for(i = 0; i < 4*runtime_gomaxprocs; i++) {
 		if(runtime_sched.gcwaiting)
 			goto top;
 		p = runtime_allp[runtime_fastrand1()%runtime_gomaxprocs];
 		if(p == (P*)g->m->p)
-			gp = runqget(p);
-		else
-			gp = runqsteal((P*)g->m->p, p);
+			gp = runqget(p, inheritTime);
+		else {
+			// first look for ready queues with more than 1 g
+			stealRunNextG = i > 2*runtime_gomaxprocs;
+			gp = runqsteal((P*)g->m->p, p, stealRunNextG);
+			*inheritTime = false;
+		}
 		if(gp)
 			return gp;
 	}
 stop:
 	// return P and block
 	runtime_lock(&runtime_sched);
-	if(runtime_sched.gcwaiting) {
+	if(runtime_sched.gcwaiting || ((P*)g->m->p)->runSafePointFn) {
 		runtime_unlock(&runtime_sched);
 		goto top;
 	}
 	if(runtime_sched.runqsize) {
 		gp = globrunqget((P*)g->m->p, 0);
 		runtime_unlock(&runtime_sched);
+		*inheritTime = false;
 		return gp;
 	}
 	p = releasep();
@@ -1756,7 +2177,7 @@ stop:
 	// check all runqueues once again
 	for(i = 0; i < runtime_gomaxprocs; i++) {
 		p = runtime_allp[i];
-		if(p && p->runqhead != p->runqtail) {
+		if(p && !runqempty(p)) {
 			runtime_lock(&runtime_sched);
 			p = pidleget();
 			runtime_unlock(&runtime_sched);
@@ -1782,7 +2203,12 @@ stop:
 			if(p) {
 				acquirep(p);
 				injectglist((G*)gp->schedlink);
+				gp->schedlink = 0;
+				limitunpark(gp);
 				gp->atomicstatus = _Grunnable;
+				if(runtime_traceenabled)
+					runtime_tracegounpark(gp);
+				*inheritTime = false;
 				return gp;
 			}
 			injectglist(gp);
@@ -1821,10 +2247,16 @@ injectglist(G *glist)
 
 	if(glist == nil)
 		return;
+	if(runtime_traceenabled) {
+		for(gp = glist; gp != nil; gp = (G*)gp->schedlink)
+			runtime_tracegounpark(gp);
+	}
 	runtime_lock(&runtime_sched);
 	for(n = 0; glist; n++) {
 		gp = glist;
 		glist = (G*)gp->schedlink;
+		gp->schedlink = 0;
+		limitunpark(gp);
 		gp->atomicstatus = _Grunnable;
 		globrunqput(gp);
 	}
@@ -1834,6 +2266,25 @@ injectglist(G *glist)
 		startm(nil, false);
 }
 
+// checkglobrunq reports whether schedule should look at the global
+// runnable queue before the local one on scheduler tick tick.  This
+// happens once every GODEBUG=schedfairness ticks, by default 61.
+static bool
+checkglobrunq(uint32 tick)
+{
+	int32 n;
+
+	n = runtime_debug.schedfairness;
+	if(n == 61) {
+		// This is a fancy way to say tick%61==0,
+		// it uses 2 MUL instructions instead of a single DIV and so is faster on modern processors.
+		return tick - (((uint64)tick*0x4325c53fu)>>36)*61 == 0;
+	}
+	if(n < 1)
+		n = 1;
+	return tick%n == 0;
+}
+
 // One round of scheduler: find a runnable goroutine and execute it.
 // Never returns.
 static void
@@ -1841,6 +2292,7 @@ schedule(void)
 {
 	G *gp;
 	uint32 tick;
+	bool inheritTime;
 
 	if(g->m->locks)
 		runtime_throw("schedule: holding locks");
@@ -1850,15 +2302,16 @@ top:
 		gcstopm();
 		goto top;
 	}
+	if(((P*)g->m->p)->runSafePointFn)
+		runSafePointFn();
 
 	gp = nil;
+	inheritTime = false;
 	// Check the global runnable queue once in a while to ensure fairness.
 	// Otherwise two goroutines can completely occupy the local runqueue
 	// by constantly respawning each other.
 	tick = ((P*)g->m->p)->schedtick;
-	// This is a fancy way to say tick%61==0,
-	// it uses 2 MUL instructions instead of a single DIV and so is faster on modern processors.
-	if(tick - (((uint64)tick*0x4325c53fu)>>36)*61 == 0 && runtime_sched.runqsize > 0) {
+	if(checkglobrunq(tick) && runtime_sched.runqsize > 0) {
 		runtime_lock(&runtime_sched);
 		gp = globrunqget((P*)g->m->p, 1);
 		runtime_unlock(&runtime_sched);
@@ -1866,12 +2319,12 @@ top:
 			resetspinning();
 	}
 	if(gp == nil) {
-		gp = runqget((P*)g->m->p);
+		gp = runqget((P*)g->m->p, &inheritTime);
 		if(gp && g->m->spinning)
 			runtime_throw("schedule: spinning with local work");
 	}
 	if(gp == nil) {
-		gp = findrunnable();  // blocks until work is available
+		gp = findrunnable(&inheritTime);  // blocks until work is available
 		resetspinning();
 	}
 
@@ -1882,7 +2335,7 @@ top:
 		goto top;
 	}
 
-	execute(gp);
+	execute(gp, inheritTime);
 }
 
 // Puts the current goroutine into a waiting state and calls unlockf.
@@ -1895,6 +2348,11 @@ runtime_park(bool(*unlockf)(G*, void*), void *lock, const char *reason)
 	g->m->waitlock = lock;
 	g->m->waitunlockf = unlockf;
 	g->waitreason = runtime_gostringnocopy((const byte*)reason);
+	// Set after waitreason, for goroutineWaits in mprof.goc.
+	runtime_atomicstore64(&g->waitsince, runtime_nanotime());
+	g->m->waittraceev = 0;
+	if(runtime_traceenabled)
+		g->m->waittraceev = runtime_traceblockev(reason);
 	runtime_mcall(park0);
 }
 
@@ -1922,21 +2380,32 @@ park0(G *gp)
 	bool ok;
 
 	m = g->m;
+	if(runtime_traceenabled)
+		runtime_tracegopark(m->waittraceev);
 	gp->atomicstatus = _Gwaiting;
 	gp->m = nil;
 	m->curg = nil;
+	// Stop counting gp against the goroutine limit before unlockf
+	// lets another M ready it.
+	if(gp->admitted)
+		runtime_xadd(&runtime_sched.nadmitted, -1);
 	if(m->waitunlockf) {
 		ok = ((bool (*)(G*, void*))m->waitunlockf)(gp, m->waitlock);
 		m->waitunlockf = nil;
 		m->waitlock = nil;
 		if(!ok) {
+			if(runtime_traceenabled)
+				runtime_tracegounpark(gp);
+			limitunpark(gp);
 			gp->atomicstatus = _Grunnable;
-			execute(gp);  // Schedule it back, never returns.
+			execute(gp, true);  // Schedule it back, never returns.
 		}
 	}
+	if(gp->admitted)
+		admitheld();
 	if(m->lockedg) {
 		stoplockedm();
-		execute(gp);  // Never returns.
+		execute(gp, false);  // Never returns.
 	}
 	schedule();
 }
@@ -1957,6 +2426,8 @@ runtime_gosched0(G *gp)
 	M *m;
 
 	m = g->m;
+	if(runtime_traceenabled)
+		runtime_tracegosched();
 	gp->atomicstatus = _Grunnable;
 	gp->m = nil;
 	m->curg = nil;
@@ -1965,7 +2436,7 @@ runtime_gosched0(G *gp)
 	runtime_unlock(&runtime_sched);
 	if(m->lockedg) {
 		stoplockedm();
-		execute(gp);  // Never returns.
+		execute(gp, false);  // Never returns.
 	}
 	schedule();
 }
@@ -1988,8 +2459,12 @@ static void
 goexit0(G *gp)
 {
 	M *m;
+	bool locked;
 
 	m = g->m;
+	if(runtime_traceenabled)
+		runtime_tracegoend();
+	locked = gp->lockedm != nil && !m->dropextram;
 	gp->atomicstatus = _Gdead;
 	gp->entry = nil;
 	gp->m = nil;
@@ -2001,6 +2476,10 @@ goexit0(G *gp)
 	gp->writebuf = nil;
 	gp->waitreason = runtime_gostringnocopy(nil);
 	gp->param = nil;
+	if(gp->issystem) {
+		gp->issystem = false;
+		runtime_xadd(&runtime_sched.ngsys, -1);
+	}
 	m->curg = nil;
 	m->lockedg = nil;
 	if(m->locked & ~_LockExternal) {
@@ -2008,10 +2487,71 @@ goexit0(G *gp)
 		runtime_throw("internal lockOSThread error");
 	}	
 	m->locked = 0;
+	if(gp->admitted) {
+		gp->admitted = false;
+		runtime_xadd(&runtime_sched.nadmitted, -1);
+		admitheld();
+	}
 	gfput((P*)m->p, gp);
+	if(locked) {
+		// The goroutine may have left the thread in a state
+		// that other goroutines should not inherit, so
+		// don't reuse the thread.
+		mexit();
+		return;
+	}
 	schedule();
 }
 
+// Called on g0 when a goroutine exits while locked to its thread.
+// Gives up the P, removes the M from allm and frees its signal stack.
+// The g0 stack is the thread's own and goes away with it.  mexit
+// returns, and goexit0 with it, so that runtime_mstart returns and the
+// thread exits.
+static void
+mexit(void)
+{
+	M *mp, **pprev;
+	sigset_t sigs;
+
+	mp = g->m;
+	if(mp == &runtime_m0) {
+		// Exiting the main thread would exit the process,
+		// so just park it forever.
+		handoffp(releasep());
+		runtime_lock(&runtime_sched);
+		runtime_sched.nmfreed++;
+		checkdead();
+		runtime_unlock(&runtime_sched);
+		runtime_notesleep(&mp->park);
+		runtime_throw("locked m0 woke up");
+	}
+
+	// Block signals before giving up the signal stack, and free
+	// it while we still have a P to free it to.
+	sigfillset(&sigs);
+	pthread_sigmask(SIG_SETMASK, &sigs, nil);
+	runtime_unminit();
+	gstackfree(mp->gsignal);
+	mp->gsignalstack = nil;
+	mp->gsignalstacksize = 0;
+
+	handoffp(releasep());
+	runtime_lock(&runtime_sched);
+	runtime_sched.nmfreed++;
+	// Others walk allm without the lock, so leave mp->alllink
+	// alone; one that is looking at mp still finds the rest.
+	for(pprev = &runtime_allm; *pprev != mp; pprev = &(*pprev)->alllink)
+		;
+	runtime_atomicstorep(pprev, mp->alllink);
+	// Keep the M reachable until the thread is done with it.
+	mp->freeWait = 1;
+	mp->freelink = runtime_sched.freem;
+	runtime_sched.freem = mp;
+	checkdead();
+	runtime_unlock(&runtime_sched);
+}
+
 // The goroutine g is about to enter a system call.
 // Record that it's not using the cpu anymore.
 // This is called only from the go syscall library and cgocall,
@@ -2022,7 +2562,7 @@ goexit0(G *gp)
 // entersyscall is going to return immediately after.
 
 void runtime_entersyscall(int32) __attribute__ ((no_split_stack));
-static void doentersyscall(void) __attribute__ ((no_split_stack, noinline));
+static void doentersyscall(uintptr) __attribute__ ((no_split_stack, noinline));
 
 void
 runtime_entersyscall(int32 dummy __attribute__ ((unused)))
@@ -2040,16 +2580,25 @@ runtime_entersyscall(int32 dummy __attribute__ ((unused)))
 	// callee-saved registers to access the TLS variable g.  We
 	// don't want to put the ucontext_t on the stack because it is
 	// large and we can not split the stack here.
-	doentersyscall();
+	doentersyscall((uintptr)__builtin_return_address(0));
 }
 
 static void
-doentersyscall()
+doentersyscall(uintptr pc)
 {
 	// Disable preemption because during this function g is in _Gsyscall status,
 	// but can have inconsistent g->sched, do not let GC observe it.
 	g->m->locks++;
 
+	// Run the safe point function before recording the stack
+	// pointer, as it may split the stack.
+	if(((P*)g->m->p)->runSafePointFn)
+		runSafePointFn();
+
+	// Likewise emit the trace event first.
+	if(runtime_traceenabled)
+		runtime_tracegosyscall();
+
 	// Leave SP around for GC and traceback.
 #ifdef USING_SPLIT_STACK
 	{
@@ -2069,6 +2618,11 @@ doentersyscall()
 
 	g->atomicstatus = _Gsyscall;
 
+	if(runtime_debug.syscallstall > 0) {
+		g->m->syscallpc = pc;
+		runtime_atomicstore64(&g->m->syscallwhen, runtime_nanotime());
+	}
+
 	if(runtime_atomicload(&runtime_sched.sysmonwait)) {  // TODO: fast atomic
 		runtime_lock(&runtime_sched);
 		if(runtime_atomicload(&runtime_sched.sysmonwait)) {
@@ -2078,12 +2632,17 @@ doentersyscall()
 		runtime_unlock(&runtime_sched);
 	}
 
+	g->m->syscalltick = ((P*)g->m->p)->syscalltick;
+	g->sysblocktraced = true;
 	g->m->mcache = nil;
 	((P*)(g->m->p))->m = 0;
 	runtime_atomicstore(&((P*)g->m->p)->status, _Psyscall);
 	if(runtime_atomicload(&runtime_sched.gcwaiting)) {
 		runtime_lock(&runtime_sched);
 		if (runtime_sched.stopwait > 0 && runtime_cas(&((P*)g->m->p)->status, _Psyscall, _Pgcstop)) {
+			if(runtime_traceenabled)
+				runtime_tracegosysblock((P*)g->m->p);
+			((P*)g->m->p)->syscalltick++;
 			if(--runtime_sched.stopwait == 0)
 				runtime_notewakeup(&runtime_sched.stopnote);
 		}
@@ -2101,6 +2660,16 @@ runtime_entersyscallblock(int32 dummy __attribute__ ((unused)))
 
 	g->m->locks++;  // see comment in entersyscall
 
+	// Emit the trace events before recording the stack pointer,
+	// as doentersyscall does.
+	if(runtime_traceenabled) {
+		runtime_tracegosyscall();
+		runtime_tracegosysblock((P*)g->m->p);
+	}
+	g->m->syscalltick = ((P*)g->m->p)->syscalltick;
+	g->sysblocktraced = true;
+	((P*)g->m->p)->syscalltick++;
+
 	// Leave SP around for GC and traceback.
 #ifdef USING_SPLIT_STACK
 	{
@@ -2119,6 +2688,7 @@ runtime_entersyscallblock(int32 dummy __attribute__ ((unused)))
 	getcontext(ucontext_arg(&g->gcregs[0]));
 
 	g->atomicstatus = _Gsyscall;
+	runtime_atomicstore64(&g->m->syscallwhen, 0);  // blocking calls are not stalls
 
 	p = releasep();
 	handoffp(p);
@@ -2136,6 +2706,7 @@ void
 runtime_exitsyscall(int32 dummy __attribute__ ((unused)))
 {
 	G *gp;
+	P *oldp;
 
 	gp = g;
 	gp->m->locks++;  // see comment in entersyscall
@@ -2144,10 +2715,16 @@ runtime_exitsyscall(int32 dummy __attribute__ ((unused)))
 		incidlelocked(-1);
 
 	gp->waitsince = 0;
+	oldp = (P*)gp->m->p;
 	if(exitsyscallfast()) {
 		// There's a cpu for us, so we can run.
+		if(runtime_traceenabled) {
+			if(oldp != (P*)gp->m->p || gp->m->syscalltick != ((P*)gp->m->p)->syscalltick)
+				runtime_tracegostart(gp);
+		}
 		((P*)gp->m->p)->syscalltick++;
 		gp->atomicstatus = _Grunning;
+		setrunsince(gp);
 		// Garbage collector isn't running (since we are),
 		// so okay to clear gcstack and gcsp.
 #ifdef USING_SPLIT_STACK
@@ -2159,6 +2736,18 @@ runtime_exitsyscall(int32 dummy __attribute__ ((unused)))
 		return;
 	}
 
+	gp->sysexitticks = 0;
+	if(runtime_traceenabled) {
+		// Wait till the GoSysBlock event is emitted.
+		// This ensures consistency of the current syscall
+		// events: GoSysBlock must come before GoSysExit.
+		while(oldp != nil && oldp->syscalltick == gp->m->syscalltick)
+			runtime_osyield();
+		// We can't emit GoSysExit without a P, so record the
+		// time now and emit it in execute.
+		gp->sysexitticks = runtime_cputicks();
+	}
+
 	gp->m->locks--;
 
 	// Call the scheduler.
@@ -2185,7 +2774,7 @@ static bool
 exitsyscallfast(void)
 {
 	G *gp;
-	P *p;
+	P *p, *oldp;
 
 	gp = g;
 
@@ -2196,10 +2785,20 @@ exitsyscallfast(void)
 	}
 
 	// Try to re-acquire the last P.
-	if(gp->m->p && ((P*)gp->m->p)->status == _Psyscall && runtime_cas(&((P*)gp->m->p)->status, _Psyscall, _Prunning)) {
+	oldp = (P*)gp->m->p;
+	if(oldp && oldp->status == _Psyscall && runtime_cas(&oldp->status, _Psyscall, _Prunning)) {
 		// There's a cpu for us, so we can run.
-		gp->m->mcache = ((P*)gp->m->p)->mcache;
-		((P*)gp->m->p)->m = (uintptr)gp->m;
+		gp->m->mcache = oldp->mcache;
+		oldp->m = (uintptr)gp->m;
+		if(gp->m->syscalltick != oldp->syscalltick) {
+			// The p was retaken and then entered a syscall
+			// again (since m->syscalltick has changed).
+			if(runtime_traceenabled) {
+				runtime_tracegosysblock(oldp);
+				runtime_tracegosysexit(gp, 0);
+			}
+			oldp->syscalltick++;
+		}
 		return true;
 	}
 	// Try to get any other idle P.
@@ -2214,6 +2813,12 @@ exitsyscallfast(void)
 		runtime_unlock(&runtime_sched);
 		if(p) {
 			acquirep(p);
+			if(runtime_traceenabled) {
+				// Wait till the GoSysBlock event is emitted.
+				while(oldp != nil && oldp->syscalltick == gp->m->syscalltick)
+					runtime_osyield();
+				runtime_tracegosysexit(gp, 0);
+			}
 			return true;
 		}
 	}
@@ -2243,12 +2848,12 @@ exitsyscall0(G *gp)
 	runtime_unlock(&runtime_sched);
 	if(p) {
 		acquirep(p);
-		execute(gp);  // Never returns.
+		execute(gp, false);  // Never returns.
 	}
 	if(m->lockedg) {
 		// Wait until another thread schedules gp and so m again.
 		stoplockedm();
-		execute(gp);  // Never returns.
+		execute(gp, false);  // Never returns.
 	}
 	stopm();
 	schedule();  // Never returns.
@@ -2303,6 +2908,69 @@ syscall_runtime_AfterFork(void)
 	runtime_m()->locks--;
 }
 
+// Allocate a stack of stacksize bytes for newg, returning its lowest
+// address and setting *ret_stacksize to its size.
+static byte*
+gstackalloc(G *newg, int32 stacksize, uintptr* ret_stacksize)
+{
+	byte *stack;
+
+#if USING_SPLIT_STACK
+	int dont_block_signals = 0;
+	size_t ss_stacksize;
+
+	stack = __splitstack_makecontext(stacksize,
+					 &newg->stackcontext[0],
+					 &ss_stacksize);
+	*ret_stacksize = (uintptr)ss_stacksize;
+	__splitstack_block_signals_context(&newg->stackcontext[0],
+					   &dont_block_signals, nil);
+#else
+	// In 64-bit mode, the maximum Go allocation space is
+	// 128G.  Our stack size is 4M, which only permits 32K
+	// goroutines.  In order to not limit ourselves,
+	// allocate the stacks out of separate memory.  In
+	// 32-bit mode, the Go allocation space is all of
+	// memory anyhow.
+	if(sizeof(void*) == 8) {
+		void *p = runtime_SysAlloc(stacksize, &mstats.other_sys);
+		if(p == nil)
+			runtime_throw("runtime: cannot allocate memory for goroutine stack");
+		// Make the lowest page inaccessible, so that a
+		// goroutine that runs off the end of its stack
+		// faults there and the signal handler can report
+		// a stack overflow.
+		runtime_SysFault(p, getpagesize());
+		stack = (byte*)p;
+	} else {
+		stack = runtime_mallocgc(stacksize, 0, FlagNoProfiling|FlagNoGC);
+		runtime_xadd(&runtime_stacks_sys, stacksize);
+	}
+	*ret_stacksize = (uintptr)stacksize;
+	newg->gcinitialsp = stack;
+	newg->gcstacksize = (uintptr)stacksize;
+#endif
+	return stack;
+}
+
+// Free the stack of the dead G gp, allocated by gstackalloc.
+static void
+gstackfree(G *gp)
+{
+#if USING_SPLIT_STACK
+	__splitstack_releasecontext(&gp->stackcontext[0]);
+#else
+	if(sizeof(void*) == 8)
+		runtime_SysFree(gp->gcinitialsp, gp->gcstacksize, &mstats.other_sys);
+	else {
+		runtime_free(gp->gcinitialsp);
+		runtime_xadd(&runtime_stacks_sys, -gp->gcstacksize);
+	}
+	gp->gcinitialsp = nil;
+	gp->gcstacksize = 0;
+#endif
+}
+
 // Allocate a new g, with a stack big enough for stacksize bytes.
 G*
 runtime_malg(int32 stacksize, byte** ret_stack, uintptr* ret_stacksize)
@@ -2310,48 +2978,22 @@ runtime_malg(int32 stacksize, byte** ret_stack, uintptr* ret_stacksize)
 	G *newg;
 
 	newg = allocg();
-	if(stacksize >= 0) {
-#if USING_SPLIT_STACK
-		int dont_block_signals = 0;
-		size_t ss_stacksize;
-
-		*ret_stack = __splitstack_makecontext(stacksize,
-						      &newg->stackcontext[0],
-						      &ss_stacksize);
-		*ret_stacksize = (uintptr)ss_stacksize;
-		__splitstack_block_signals_context(&newg->stackcontext[0],
-						   &dont_block_signals, nil);
-#else
-                // In 64-bit mode, the maximum Go allocation space is
-                // 128G.  Our stack size is 4M, which only permits 32K
-                // goroutines.  In order to not limit ourselves,
-                // allocate the stacks out of separate memory.  In
-                // 32-bit mode, the Go allocation space is all of
-                // memory anyhow.
-		if(sizeof(void*) == 8) {
-			void *p = runtime_SysAlloc(stacksize, &mstats.other_sys);
-			if(p == nil)
-				runtime_throw("runtime: cannot allocate memory for goroutine stack");
-			*ret_stack = (byte*)p;
-		} else {
-			*ret_stack = runtime_mallocgc(stacksize, 0, FlagNoProfiling|FlagNoGC);
-			runtime_xadd(&runtime_stacks_sys, stacksize);
-		}
-		*ret_stacksize = (uintptr)stacksize;
-		newg->gcinitialsp = *ret_stack;
-		newg->gcstacksize = (uintptr)stacksize;
-#endif
-	}
+	if(stacksize >= 0)
+		*ret_stack = gstackalloc(newg, stacksize, ret_stacksize);
 	return newg;
 }
 
-G*
-__go_go(void (*fn)(void*), void* arg)
+// Create a new goroutine running fn(arg), created at gopc.  If
+// limited, the goroutine counts against the goroutine limit and may be
+// held back by it; see runtime_setMaxGoroutines.
+static G*
+newproc(void (*fn)(void*), void *arg, uintptr gopc, bool limited)
 {
 	byte *sp;
 	size_t spsize;
 	G *newg;
 	P *p;
+	bool held;
 
 //runtime_printf("newproc1 %p %p narg=%d nret=%d\n", fn->fn, argp, narg, nret);
 	if(fn == nil) {
@@ -2374,7 +3016,10 @@ __go_go(void (*fn)(void*), void* arg)
 		spsize = newg->gcstacksize;
 		if(spsize == 0)
 			runtime_throw("bad spsize in __go_go");
-		newg->gcnextsp = sp;
+		// Nothing on the stack is live until the goroutine
+		// starts running, as for a newly allocated stack.
+		// Scanning from sp would also touch the guard page.
+		newg->gcnextsp = nil;
 #endif
 	} else {
 		uintptr malsize;
@@ -2386,13 +3031,19 @@ __go_go(void (*fn)(void*), void* arg)
 
 	newg->entry = (byte*)fn;
 	newg->param = arg;
-	newg->gopc = (uintptr)__builtin_return_address(0);
+	newg->gopc = gopc;
 	newg->atomicstatus = _Grunnable;
 	if(p->goidcache == p->goidcacheend) {
+		// Sched.goidgen is the last allocated id,
+		// this batch must be [sched.goidgen+1, sched.goidgen+GoidCacheBatch].
+		// At startup sched.goidgen=0, so main goroutine receives goid=1.
 		p->goidcache = runtime_xadd64(&runtime_sched.goidgen, GoidCacheBatch);
+		p->goidcache -= GoidCacheBatch - 1;
 		p->goidcacheend = p->goidcache + GoidCacheBatch;
 	}
 	newg->goid = p->goidcache++;
+	if(runtime_traceenabled)
+		runtime_tracegocreate(newg);
 
 	{
 		// Avoid warnings about variables clobbered by
@@ -2408,7 +3059,11 @@ __go_go(void (*fn)(void*), void* arg)
 		uc->uc_stack.ss_size = vspsize;
 		makecontext(uc, kickoff, 0);
 
-		runqput(p, vnewg);
+		held = false;
+		if(limited && runtime_atomicload((volatile uint32*)&runtime_sched.maxg) != 0)
+			held = holdg(vnewg);
+		if(!held)
+			runqput(p, vnewg, true);
 
 		if(runtime_atomicload(&runtime_sched.npidle) != 0 && runtime_atomicload(&runtime_sched.nmspinning) == 0 && fn != runtime_main)  // TODO: fast atomic
 			wakep();
@@ -2417,13 +3072,29 @@ __go_go(void (*fn)(void*), void* arg)
 	}
 }
 
+// Start a goroutine for a go statement.
+G*
+__go_go(void (*fn)(void*), void* arg)
+{
+	return newproc(fn, arg, (uintptr)__builtin_return_address(0), true);
+}
+
+// Start a goroutine that the runtime needs for itself, such as the
+// timer or finalizer goroutine.  It is never held back by the
+// goroutine limit, which could otherwise keep it from running at all.
+G*
+runtime_gonolimit(void (*fn)(void*), void* arg)
+{
+	return newproc(fn, arg, (uintptr)__builtin_return_address(0), false);
+}
+
 static void
 allgadd(G *gp)
 {
 	G **new;
 	uintptr cap;
 
-	runtime_lock(&allglock);
+	runtime_lock(&runtime_allglock);
 	if(runtime_allglen >= allgcap) {
 		cap = 4096/sizeof(new[0]);
 		if(cap < 2*allgcap)
@@ -2436,28 +3107,183 @@ allgadd(G *gp)
 			runtime_free(runtime_allg);
 		}
 		runtime_allg = new;
+		// The GC does not scan allgsort, so it comes from the
+		// system; every G it holds is also in allg.
+		new = runtime_SysAlloc(cap*sizeof(new[0]), &mstats.other_sys);
+		if(new == nil)
+			runtime_throw("runtime: cannot allocate memory");
+		if(allgsort != nil)
+			runtime_SysFree(allgsort, allgcap*sizeof(new[0]), &mstats.other_sys);
+		allgsort = new;
 		allgcap = cap;
 	}
 	runtime_allg[runtime_allglen++] = gp;
-	runtime_unlock(&allglock);
+	runtime_unlock(&runtime_allglock);
 }
 
-// Put on gfree list.
-// If local list is too long, transfer a batch to the global list.
-static void
-gfput(P *p, G *gp)
+// gfreecap returns the number of dead G's a P may cache before
+// half of them are moved to the global list (GODEBUG=gfreecap).
+static int32
+gfreecap(void)
 {
-	gp->schedlink = (uintptr)p->gfree;
-	p->gfree = gp;
+	int32 cap;
+
+	cap = runtime_debug.gfreecap;
+	if(cap < 1)
+		cap = 1;
+	return cap;
+}
+
+// Get a sudog from the current P's cache, refilling the cache from
+// the central cache or allocating a new sudog if it is empty.
+// The sudog is allocated without type information, so the garbage
+// collector scans it conservatively: elem may point into a stack.
+SudoG*
+runtime_acquireSudog(void)
+{
+	M *mp;
+	P *pp;
+	SudoG *s, **buf;
+	intgo n;
+
+	// Prevent rescheduling to another P while using its cache.
+	mp = g->m;
+	mp->locks++;
+	pp = (P*)mp->p;
+	buf = (SudoG**)pp->sudogcache.__values;
+	if(pp->sudogcache.__count == 0) {
+		runtime_lock(&runtime_sched.sudoglock);
+		// First, try to grab a batch from central cache.
+		while(pp->sudogcache.__count < pp->sudogcache.__capacity/2 && runtime_sched.sudogcache != nil) {
+			s = runtime_sched.sudogcache;
+			runtime_sched.sudogcache = s->next;
+			s->next = nil;
+			buf[pp->sudogcache.__count++] = s;
+		}
+		runtime_unlock(&runtime_sched.sudoglock);
+		// If the central cache is empty, allocate a new one.
+		if(pp->sudogcache.__count == 0) {
+			buf[0] = runtime_mallocgc(sizeof(SudoG), 0, 0);
+			pp->sudogcache.__count = 1;
+			runtime_xadd(&runtime_sched.nsudogalloc, 1);
+		}
+	}
+	n = pp->sudogcache.__count;
+	s = buf[n-1];
+	buf[n-1] = nil;
+	pp->sudogcache.__count = n-1;
+	if(s->elem != nil)
+		runtime_throw("acquireSudog: found s->elem != nil in cache");
+	mp->locks--;
+	return s;
+}
+
+// Return a sudog to the current P's cache, moving half of the cache
+// to the central cache if it is full.
+void
+runtime_releaseSudog(SudoG *s)
+{
+	M *mp;
+	P *pp;
+	SudoG *first, *last, *p, **buf;
+
+	if(s->elem != nil)
+		runtime_throw("runtime: sudog with non-nil elem");
+	if(s->selectdone != nil)
+		runtime_throw("runtime: sudog with non-nil selectdone");
+	if(s->next != nil)
+		runtime_throw("runtime: sudog with non-nil next");
+	if(s->prev != nil)
+		runtime_throw("runtime: sudog with non-nil prev");
+	if(s->waitlink != nil)
+		runtime_throw("runtime: sudog with non-nil waitlink");
+	if(s->c != nil)
+		runtime_throw("runtime: sudog with non-nil c");
+	if(g->param != nil)
+		runtime_throw("runtime: releaseSudog with non-nil gp->param");
+	mp = g->m;
+	mp->locks++;  // avoid rescheduling to another P
+	pp = (P*)mp->p;
+	buf = (SudoG**)pp->sudogcache.__values;
+	if(pp->sudogcache.__count == pp->sudogcache.__capacity) {
+		// Transfer half of local cache to the central cache.
+		first = nil;
+		last = nil;
+		while(pp->sudogcache.__count > pp->sudogcache.__capacity/2) {
+			p = buf[--pp->sudogcache.__count];
+			buf[pp->sudogcache.__count] = nil;
+			if(first == nil)
+				first = p;
+			else
+				last->next = p;
+			last = p;
+		}
+		runtime_lock(&runtime_sched.sudoglock);
+		last->next = runtime_sched.sudogcache;
+		runtime_sched.sudogcache = first;
+		runtime_unlock(&runtime_sched.sudoglock);
+	}
+	buf[pp->sudogcache.__count++] = s;
+	mp->locks--;
+}
+
+// Drop the central sudog cache, called by the garbage collector.
+// The per-P caches are left alone; their size is bounded.
+void
+runtime_clearsudogcache(void)
+{
+	SudoG *sg, *sgnext;
+
+	runtime_lock(&runtime_sched.sudoglock);
+	// Disconnect cached list before dropping it on the floor,
+	// so that a dangling ref to one entry does not pin all of them.
+	for(sg = runtime_sched.sudogcache; sg != nil; sg = sgnext) {
+		sgnext = sg->next;
+		sg->next = nil;
+	}
+	runtime_sched.sudogcache = nil;
+	runtime_unlock(&runtime_sched.sudoglock);
+}
+
+// With GODEBUG=schedcheck=1, throw if gp is already on a scheduler
+// list: the global run queue, a free list, the netpoll list or the
+// list of goroutines held back by the goroutine limit.  Each of these
+// clears schedlink when it takes a G off, so a G with a link is still
+// on one; tail, if not nil, is the end of the list gp is being added
+// to, which is on the list but has no link.
+static void
+checkunlinked(G *gp, G *tail)
+{
+	if(runtime_debug.schedcheck == 0)
+		return;
+	if(gp->schedlink != 0 || gp == tail) {
+		runtime_printf("runtime: goroutine %D (status %d) is already on a scheduler list\n",
+			gp->goid, gp->atomicstatus);
+		runtime_throw("schedlink: G on two lists");
+	}
+}
+
+// Put on gfree list.
+// If local list is too long, transfer a batch to the global list.
+static void
+gfput(P *p, G *gp)
+{
+	int32 cap;
+
+	checkunlinked(gp, nil);
+	gp->schedlink = (uintptr)p->gfree;
+	p->gfree = gp;
 	p->gfreecnt++;
-	if(p->gfreecnt >= 64) {
+	cap = gfreecap();
+	if(p->gfreecnt >= cap) {
 		runtime_lock(&runtime_sched.gflock);
-		while(p->gfreecnt >= 32) {
+		while(p->gfreecnt > cap/2) {
 			p->gfreecnt--;
 			gp = p->gfree;
 			p->gfree = (G*)gp->schedlink;
 			gp->schedlink = (uintptr)runtime_sched.gfree;
 			runtime_sched.gfree = gp;
+			runtime_sched.ngfree++;
 		}
 		runtime_unlock(&runtime_sched.gflock);
 	}
@@ -2468,25 +3294,53 @@ gfput(P *p, G *gp)
 static G*
 gfget(P *p)
 {
-	G *gp;
+	G *gp, *nostack;
+	int32 batch, n;
+	uintptr stacksize;
 
 retry:
 	gp = p->gfree;
-	if(gp == nil && runtime_sched.gfree) {
+	if(gp == nil && (runtime_sched.gfree || runtime_sched.gfreenostack)) {
+		batch = gfreecap()/2;
+		if(batch < 1)
+			batch = 1;
+		nostack = nil;
+		n = p->gfreecnt;
 		runtime_lock(&runtime_sched.gflock);
-		while(p->gfreecnt < 32 && runtime_sched.gfree) {
-			p->gfreecnt++;
-			gp = runtime_sched.gfree;
-			runtime_sched.gfree = (G*)gp->schedlink;
+		while(n < batch) {
+			if(runtime_sched.gfree) {
+				gp = runtime_sched.gfree;
+				runtime_sched.gfree = (G*)gp->schedlink;
+				gp->schedlink = (uintptr)p->gfree;
+				p->gfree = gp;
+				p->gfreecnt++;
+			} else if(runtime_sched.gfreenostack) {
+				gp = runtime_sched.gfreenostack;
+				runtime_sched.gfreenostack = (G*)gp->schedlink;
+				gp->schedlink = (uintptr)nostack;
+				nostack = gp;
+			} else
+				break;
+			runtime_sched.ngfree--;
+			n++;
+		}
+		runtime_unlock(&runtime_sched.gflock);
+		// Give the G's whose stacks were released new ones,
+		// outside of the lock.
+		while(nostack) {
+			gp = nostack;
+			nostack = (G*)gp->schedlink;
+			gstackalloc(gp, StackMin, &stacksize);
 			gp->schedlink = (uintptr)p->gfree;
 			p->gfree = gp;
+			p->gfreecnt++;
 		}
-		runtime_unlock(&runtime_sched.gflock);
 		goto retry;
 	}
 	if(gp) {
 		p->gfree = (G*)gp->schedlink;
 		p->gfreecnt--;
+		gp->schedlink = 0;
 	}
 	return gp;
 }
@@ -2504,6 +3358,27 @@ gfpurge(P *p)
 		p->gfree = (G*)gp->schedlink;
 		gp->schedlink = (uintptr)runtime_sched.gfree;
 		runtime_sched.gfree = gp;
+		runtime_sched.ngfree++;
+	}
+	runtime_unlock(&runtime_sched.gflock);
+}
+
+// Release the stacks of the dead G's on the global list, called by
+// the garbage collector with the world stopped.  The G's stay on allg;
+// gfget gives them new stacks when they are reused.  The per-P lists
+// are left alone; their size is bounded by gfreecap.
+void
+runtime_gfreerelease(void)
+{
+	G *gp;
+
+	runtime_lock(&runtime_sched.gflock);
+	while(runtime_sched.gfree) {
+		gp = runtime_sched.gfree;
+		runtime_sched.gfree = (G*)gp->schedlink;
+		gstackfree(gp);
+		gp->schedlink = (uintptr)runtime_sched.gfreenostack;
+		runtime_sched.gfreenostack = gp;
 	}
 	runtime_unlock(&runtime_sched.gflock);
 }
@@ -2522,6 +3397,37 @@ runtime_Gosched(void)
 	runtime_gosched();
 }
 
+// Implementation of runtime.setMaxGoroutines.  Once n > 0, each new
+// goroutine counts against the limit n while it is runnable or
+// running, and a go statement that would go over the limit starts its
+// goroutine only once enough of the counted goroutines have blocked or
+// exited.  A blocked goroutine that is woken counts again, even if
+// that takes the count over the limit.  Goroutines that were already
+// running when the limit was set, and the runtime's own goroutines,
+// do not count.  n == 0 removes the limit and starts any goroutines
+// held back.  This is meant for servers that start a goroutine per
+// request.
+intgo runtime_setMaxGoroutines(intgo)
+  __asm__ (GOSYM_PREFIX "runtime.setMaxGoroutines");
+
+intgo
+runtime_setMaxGoroutines(intgo n)
+{
+	intgo old;
+	int32 nadmit;
+
+	if(n < 0 || n > 0x7fffffff)
+		runtime_throw("setMaxGoroutines: bad limit");
+	runtime_lock(&runtime_sched);
+	old = runtime_sched.maxg;
+	runtime_atomicstore((volatile uint32*)&runtime_sched.maxg, n);
+	nadmit = admitg();
+	runtime_unlock(&runtime_sched);
+	if(nadmit && runtime_atomicload(&runtime_sched.npidle) != 0)
+		wakep();
+	return old;
+}
+
 // Implementation of runtime.GOMAXPROCS.
 // delete when scheduler is even stronger
 int32
@@ -2539,7 +3445,7 @@ runtime_gomaxprocsfunc(int32 n)
 	}
 	runtime_unlock(&runtime_sched);
 
-	runtime_semacquire(&runtime_worldsema, false);
+	runtime_semacquire(&runtime_worldsema, 0);
 	g->m->gcing = 1;
 	runtime_stoptheworld();
 	newprocs = n;
@@ -2612,33 +3518,40 @@ runtime_lockedOSThread(void)
 	return g->lockedm != nil && g->m->lockedg != nil;
 }
 
+// Mark gp as a system goroutine, leaving it out of stack dumps and
+// of runtime.NumGoroutine.
+void
+runtime_setsystemg(G *gp)
+{
+	if(!gp->issystem) {
+		gp->issystem = true;
+		runtime_xadd(&runtime_sched.ngsys, 1);
+	}
+}
+
 int32
 runtime_gcount(void)
 {
-	G *gp;
-	int32 n, s;
-	uintptr i;
+	P *p, **pp;
+	int32 n;
 
-	n = 0;
-	runtime_lock(&allglock);
-	// TODO(dvyukov): runtime.NumGoroutine() is O(N).
-	// We do not want to increment/decrement centralized counter in newproc/goexit,
-	// just to make runtime.NumGoroutine() faster.
-	// Compromise solution is to introduce per-P counters of active goroutines.
-	for(i = 0; i < runtime_allglen; i++) {
-		gp = runtime_allg[i];
-		s = gp->atomicstatus;
-		if(s == _Grunnable || s == _Grunning || s == _Gsyscall || s == _Gwaiting)
-			n++;
-	}
-	runtime_unlock(&allglock);
+	// The dead G's are on the free lists, except for those that
+	// belong to idle extra M's waiting for a cgo callback.
+	n = (int32)runtime_allglen - runtime_sched.ngfree - (int32)runtime_atomicload(&runtime_sched.ngsys) - countextra();
+	for(pp=runtime_allp; (p=*pp) != nil; pp++)
+		n -= p->gfreecnt;
+
+	// All these variables can be changed concurrently, so the result can be inconsistent.
+	// But at least the current goroutine is running.
+	if(n < 1)
+		n = 1;
 	return n;
 }
 
 int32
 runtime_mcount(void)
 {
-	return runtime_sched.mcount;
+	return runtime_sched.mcount - runtime_sched.nmfreed;
 }
 
 static struct {
@@ -2748,13 +3661,21 @@ static void
 procresize(int32 new)
 {
 	int32 i, old;
-	bool pempty;
+	bool pempty, moved;
 	G *gp;
 	P *p;
+	int64 now;
 
 	old = runtime_gomaxprocs;
 	if(old < 0 || old > _MaxGomaxprocs || new <= 0 || new >_MaxGomaxprocs)
 		runtime_throw("procresize: invalid arg");
+
+	// update statistics
+	now = runtime_nanotime();
+	if(runtime_sched.procresizetime != 0)
+		runtime_sched.totaltime += (int64)old * (now - runtime_sched.procresizetime);
+	runtime_sched.procresizetime = now;
+
 	// initialize new P's
 	for(i = 0; i < new; i++) {
 		p = runtime_allp[i];
@@ -2770,6 +3691,16 @@ procresize(int32 new)
 			else
 				p->mcache = runtime_allocmcache();
 		}
+		if(p->sudogcache.__values == nil) {
+			p->sudogcache.__values = &p->sudogbuf[0];
+			p->sudogcache.__count = 0;
+			p->sudogcache.__capacity = nelem(p->sudogbuf);
+		}
+		if(p->deferpool.__values == nil) {
+			p->deferpool.__values = &p->deferpoolbuf[0];
+			p->deferpool.__count = 0;
+			p->deferpool.__capacity = nelem(p->deferpoolbuf);
+		}
 	}
 
 	// redistribute runnable G's evenly
@@ -2788,6 +3719,7 @@ procresize(int32 new)
 			p->runqtail--;
 			gp = (G*)p->runq[p->runqtail%nelem(p->runq)];
 			// push onto head of global queue
+			checkunlinked(gp, runtime_sched.runqtail);
 			gp->schedlink = (uintptr)runtime_sched.runqhead;
 			runtime_sched.runqhead = gp;
 			if(runtime_sched.runqtail == nil)
@@ -2795,24 +3727,49 @@ procresize(int32 new)
 			runtime_sched.runqsize++;
 		}
 	}
+	// the runnext G's go in front of everything else
+	for(i = 0; i < old; i++) {
+		p = runtime_allp[i];
+		if(p->runnext == 0)
+			continue;
+		gp = (G*)p->runnext;
+		p->runnext = 0;
+		checkunlinked(gp, runtime_sched.runqtail);
+		gp->schedlink = (uintptr)runtime_sched.runqhead;
+		runtime_sched.runqhead = gp;
+		if(runtime_sched.runqtail == nil)
+			runtime_sched.runqtail = gp;
+		runtime_sched.runqsize++;
+	}
 	// fill local queues with at most nelem(p->runq)/2 goroutines
 	// start at 1 because current M already executes some G and will acquire allp[0] below,
 	// so if we have a spare G we want to put it into allp[1].
 	for(i = 1; (uint32)i < (uint32)new * nelem(p->runq)/2 && runtime_sched.runqsize > 0; i++) {
 		gp = runtime_sched.runqhead;
 		runtime_sched.runqhead = (G*)gp->schedlink;
+		gp->schedlink = 0;
 		if(runtime_sched.runqhead == nil)
 			runtime_sched.runqtail = nil;
 		runtime_sched.runqsize--;
-		runqput(runtime_allp[i%new], gp);
+		runqput(runtime_allp[i%new], gp, false);
 	}
 
+	// the current goroutine moves to allp[0] below
+	moved = g->m->p != 0 && (P*)g->m->p != runtime_allp[0];
+	if(runtime_traceenabled && moved)
+		runtime_tracegosched();
+
 	// free unused P's
 	for(i = new; i < old; i++) {
 		p = runtime_allp[i];
+		runtime_traceprocfree(p);
 		runtime_freemcache(p->mcache);
 		p->mcache = nil;
 		gfpurge(p);
+		runtime_memclr((byte*)&p->sudogbuf[0], sizeof p->sudogbuf);
+		p->sudogcache.__count = 0;
+		runtime_memclr((byte*)&p->deferpoolbuf[0], sizeof p->deferpoolbuf);
+		p->deferpool.__count = 0;
 		p->status = _Pdead;
 		// can't free P itself because it can be referenced by an M in syscall
 	}
@@ -2825,6 +3782,8 @@ procresize(int32 new)
 	p->m = 0;
 	p->status = _Pidle;
 	acquirep(p);
+	if(runtime_traceenabled && moved)
+		runtime_tracegostart(g);
 	for(i = new-1; i > 0; i--) {
 		p = runtime_allp[i];
 		p->status = _Pidle;
@@ -2833,6 +3792,15 @@ procresize(int32 new)
 	runtime_atomicstore((uint32*)&runtime_gomaxprocs, new);
 }
 
+// Return the CPU time available to the program up to now, in
+// nanoseconds summed over all P's.  Used to compute the fraction of
+// that time spent in the garbage collector.
+int64
+runtime_schedtotaltime(int64 now)
+{
+	return runtime_sched.totaltime + (now - runtime_sched.procresizetime)*runtime_gomaxprocs;
+}
+
 // Associate p and the current m.
 static void
 acquirep(P *p)
@@ -2902,7 +3870,7 @@ checkdead(void)
 	}
 
 	// -1 for sysmon
-	run = runtime_sched.mcount - runtime_sched.nmidle - runtime_sched.nmidlelocked - 1 - countextra();
+	run = runtime_sched.mcount - runtime_sched.nmfreed - runtime_sched.nmidle - runtime_sched.nmidlelocked - 1 - countextra();
 	if(run > 0)
 		return;
 	// If we are dying because of a signal caught on an already idle thread,
@@ -2912,12 +3880,12 @@ checkdead(void)
 	if(runtime_panicking > 0)
 		return;
 	if(run < 0) {
-		runtime_printf("runtime: checkdead: nmidle=%d nmidlelocked=%d mcount=%d\n",
-			runtime_sched.nmidle, runtime_sched.nmidlelocked, runtime_sched.mcount);
+		runtime_printf("runtime: checkdead: nmidle=%d nmidlelocked=%d mcount=%d nmfreed=%d\n",
+			runtime_sched.nmidle, runtime_sched.nmidlelocked, runtime_sched.mcount, runtime_sched.nmfreed);
 		runtime_throw("checkdead: inconsistent counts");
 	}
 	grunning = 0;
-	runtime_lock(&allglock);
+	runtime_lock(&runtime_allglock);
 	for(i = 0; i < runtime_allglen; i++) {
 		gp = runtime_allg[i];
 		if(gp->isbackground)
@@ -2926,12 +3894,12 @@ checkdead(void)
 		if(s == _Gwaiting)
 			grunning++;
 		else if(s == _Grunnable || s == _Grunning || s == _Gsyscall) {
-			runtime_unlock(&allglock);
+			runtime_unlock(&runtime_allglock);
 			runtime_printf("runtime: checkdead: find g %D in status %d\n", gp->goid, s);
 			runtime_throw("checkdead: runnable g");
 		}
 	}
-	runtime_unlock(&allglock);
+	runtime_unlock(&runtime_allglock);
 	if(grunning == 0)  // possible if main goroutine calls runtime_Goexit()
 		runtime_throw("no goroutines (main called runtime.Goexit) - deadlock!");
 	g->m->throwing = -1;  // do not dump full stacks
@@ -2945,6 +3913,8 @@ sysmon(void)
 	int64 now, lastpoll, lasttrace;
 	G *gp;
 
+	runtime_bgready();
+
 	lasttrace = 0;
 	idle = 0;  // how many cycles in succession we had not wokeup somebody
 	delay = 0;
@@ -2956,6 +3926,7 @@ sysmon(void)
 		if(delay > 10*1000)  // up to 10ms
 			delay = 10*1000;
 		runtime_usleep(delay);
+		runtime_xadd(&runtime_sched.sysmontick, 1);
 		if(runtime_debug.schedtrace <= 0 &&
 			(runtime_sched.gcwaiting || runtime_atomicload(&runtime_sched.npidle) == (uint32)runtime_gomaxprocs)) {  // TODO: fast atomic
 			runtime_lock(&runtime_sched);
@@ -2994,6 +3965,9 @@ sysmon(void)
 			idle = 0;
 		else
 			idle++;
+		// report long non-blocking syscalls
+		if(runtime_debug.syscallstall > 0)
+			checksyscallstall(now);
 
 		if(runtime_debug.schedtrace > 0 && lasttrace + runtime_debug.schedtrace*1000000ll <= now) {
 			lasttrace = now;
@@ -3012,6 +3986,10 @@ struct Pdesc
 };
 static Pdesc pdesc[_MaxGomaxprocs];
 
+// If set, sysmon does not ask long-running goroutines to yield.
+// Set by tests that check when a preemption point yields.
+static uint32 sysmonpreemptoff;
+
 static uint32
 retake(int64 now)
 {
@@ -3038,7 +4016,7 @@ retake(int64 now)
 			// On the one hand we don't want to retake Ps if there is no other work to do,
 			// but on the other hand we want to retake them eventually
 			// because they can prevent the sysmon thread from deep sleep.
-			if(p->runqhead == p->runqtail &&
+			if(runqempty(p) &&
 				runtime_atomicload(&runtime_sched.nmspinning) + runtime_atomicload(&runtime_sched.npidle) > 0 &&
 				pd->syscallwhen + 10*1000*1000 > now)
 				continue;
@@ -3048,7 +4026,10 @@ retake(int64 now)
 			// increment nmidle and report deadlock.
 			incidlelocked(-1);
 			if(runtime_cas(&p->status, s, _Pidle)) {
+				if(runtime_traceenabled)
+					runtime_tracegosysblock(p);
 				n++;
+				p->syscalltick++;
 				handoffp(p);
 			}
 			incidlelocked(1);
@@ -3062,12 +4043,46 @@ retake(int64 now)
 			}
 			if(pd->schedwhen + 10*1000*1000 > now)
 				continue;
-			// preemptone(p);
+			if(runtime_atomicload(&sysmonpreemptoff))
+				continue;
+			preemptone(p);
 		}
 	}
 	return n;
 }
 
+// Report goroutines that have been in a non-blocking system call
+// for more than syscallstall milliseconds.  Such a call holds on to
+// its P until retake hands it off, so it should probably be using
+// entersyscallblock instead.  Each system call is reported once.
+// Only the M itself writes syscallwhen; sysmon remembers which call it
+// reported in syscallreported.
+static void
+checksyscallstall(int64 now)
+{
+	M *mp;
+	G *gp;
+	int64 when;
+	String fn, file;
+	intgo line;
+
+	for(mp = runtime_allm; mp; mp = mp->alllink) {
+		gp = mp->curg;
+		if(gp == nil || gp->atomicstatus != _Gsyscall)
+			continue;
+		when = runtime_atomicload64(&mp->syscallwhen);
+		if(when == 0 || when == mp->syscallreported ||
+		   when + runtime_debug.syscallstall*1000000LL > now)
+			continue;
+		mp->syscallreported = when;
+		runtime_printf("runtime: goroutine %D in system call for %Dms on M%d",
+			gp->goid, (now - when)/1000000, mp->id);
+		if(mp->syscallpc != 0 && __go_file_line(mp->syscallpc - 1, -1, &fn, &file, &line))
+			runtime_printf(" called from %S at %S:%D", fn, file, (int64)line);
+		runtime_printf("\n");
+	}
+}
+
 // Tell all goroutines that they have been preempted and they should stop.
 // This function is purely best-effort.  It can fail to inform a goroutine if a
 // processor just started running it.
@@ -3076,7 +4091,45 @@ retake(int64 now)
 static bool
 preemptall(void)
 {
-	return false;
+	P *p;
+	int32 i;
+	bool res;
+
+	res = false;
+	for(i = 0; i < runtime_gomaxprocs; i++) {
+		p = runtime_allp[i];
+		if(p == nil || p->status != _Prunning)
+			continue;
+		if(preemptone(p))
+			res = true;
+	}
+	return res;
+}
+
+// Tell the goroutine running on processor P to stop.
+// This function is purely best-effort.  It can incorrectly fail to inform the
+// goroutine.  It can inform the wrong goroutine.  Even if it informs the
+// correct goroutine, that goroutine might ignore the request if it is
+// simultaneously executing runtime_newstack.
+// No lock needs to be held.
+// Returns true if preemption request was issued.
+// gccgo has no stack check to trip, so the request is only seen at a
+// preemption point: runtime_checkpreempt, runtime.preemptionPoint, or
+// a new or make in Go code (__go_new, __go_make_slice2).
+static bool
+preemptone(P *p)
+{
+	M *mp;
+	G *gp;
+
+	mp = (M*)p->m;
+	if(mp == nil || mp == g->m)
+		return false;
+	gp = mp->curg;
+	if(gp == nil || gp == mp->g0)
+		return false;
+	gp->preempt = true;
+	return true;
 }
 
 void
@@ -3154,7 +4207,7 @@ runtime_schedtrace(bool detailed)
 			mp->mallocing, mp->throwing, mp->gcing, mp->locks, mp->dying, mp->helpgc,
 			mp->spinning, mp->blocked, id3);
 	}
-	runtime_lock(&allglock);
+	runtime_lock(&runtime_allglock);
 	for(gi = 0; gi < runtime_allglen; gi++) {
 		gp = runtime_allg[gi];
 		mp = gp->m;
@@ -3163,7 +4216,7 @@ runtime_schedtrace(bool detailed)
 			gp->goid, gp->atomicstatus, gp->waitreason, mp ? mp->id : -1,
 			lockedm ? lockedm->id : -1);
 	}
-	runtime_unlock(&allglock);
+	runtime_unlock(&runtime_allglock);
 	runtime_unlock(&runtime_sched);
 }
 
@@ -3192,11 +4245,105 @@ mget(void)
 	return mp;
 }
 
+// Count newg against the goroutine limit, or, if the limit has been
+// reached, hold it back until a goroutine that counts against the limit
+// blocks or exits.  Returns whether newg was held back.
+static bool
+holdg(G *newg)
+{
+	bool held;
+
+	held = false;
+	runtime_lock(&runtime_sched);
+	if(runtime_sched.maxg > 0) {
+		if((int32)runtime_atomicload((volatile uint32*)&runtime_sched.nadmitted) < runtime_sched.maxg) {
+			runtime_xadd(&runtime_sched.nadmitted, 1);
+			newg->admitted = true;
+		} else {
+			newg->atomicstatus = _Gwaiting;
+			newg->waitreason = runtime_gostringnocopy((const byte*)"goroutine limit");
+			runtime_atomicstore64(&newg->waitsince, runtime_nanotime());
+			checkunlinked(newg, runtime_sched.heldtail);
+			newg->schedlink = 0;
+			if(runtime_sched.heldtail)
+				runtime_sched.heldtail->schedlink = (uintptr)newg;
+			else
+				runtime_atomicstorep(&runtime_sched.heldhead, newg);
+			runtime_sched.heldtail = newg;
+			held = true;
+			// A goroutine may have stopped counting after we
+			// looked, and before admitheld could see newg.
+			admitg();
+		}
+	}
+	runtime_unlock(&runtime_sched);
+	return held;
+}
+
+// Move held goroutines to the global run queue while the goroutine
+// limit allows it, and return how many were moved.
+// Sched must be locked.
+static int32
+admitg(void)
+{
+	int32 n;
+	G *gp;
+
+	n = 0;
+	while((gp = runtime_sched.heldhead) != nil &&
+	      (runtime_sched.maxg == 0 ||
+	       (int32)runtime_atomicload((volatile uint32*)&runtime_sched.nadmitted) < runtime_sched.maxg)) {
+		runtime_atomicstorep(&runtime_sched.heldhead, (G*)gp->schedlink);
+		gp->schedlink = 0;
+		if(runtime_sched.heldhead == nil)
+			runtime_sched.heldtail = nil;
+		if(runtime_sched.maxg > 0) {
+			runtime_xadd(&runtime_sched.nadmitted, 1);
+			gp->admitted = true;
+		}
+		gp->waitreason = runtime_gostringnocopy(nil);
+		gp->atomicstatus = _Grunnable;
+		globrunqput(gp);
+		n++;
+	}
+	return n;
+}
+
+// Called after a goroutine stops counting against the goroutine
+// limit, because it blocked or exited: start held goroutines that now
+// fit under the limit.
+static void
+admitheld(void)
+{
+	int32 n;
+
+	if(runtime_atomicloadp(&runtime_sched.heldhead) == nil)
+		return;
+	runtime_lock(&runtime_sched);
+	n = admitg();
+	runtime_unlock(&runtime_sched);
+	if(n && runtime_atomicload(&runtime_sched.npidle) != 0)
+		wakep();
+}
+
+// Called when the waiting goroutine gp is made runnable.  The limit
+// applies to runnable goroutines, so a goroutine counts against it
+// again once it is woken.  It is not held back, though: the limit only
+// delays new goroutines.
+static void
+limitunpark(G *gp)
+{
+	if(gp->admitted)
+		runtime_xadd(&runtime_sched.nadmitted, 1);
+}
+
 // Put gp on the global runnable queue.
 // Sched must be locked.
 static void
 globrunqput(G *gp)
 {
+	checkunlinked(gp, runtime_sched.runqtail);
+	setrunsince(gp);
 	gp->schedlink = 0;
 	if(runtime_sched.runqtail)
 		runtime_sched.runqtail->schedlink = (uintptr)gp;
@@ -3211,6 +4358,7 @@ globrunqput(G *gp)
 static void
 globrunqputbatch(G *ghead, G *gtail, int32 n)
 {
+	checkunlinked(gtail, runtime_sched.runqtail);
 	gtail->schedlink = 0;
 	if(runtime_sched.runqtail)
 		runtime_sched.runqtail->schedlink = (uintptr)ghead;
@@ -3242,11 +4390,13 @@ globrunqget(P *p, int32 max)
 		runtime_sched.runqtail = nil;
 	gp = runtime_sched.runqhead;
 	runtime_sched.runqhead = (G*)gp->schedlink;
+	gp->schedlink = 0;
 	n--;
 	while(n--) {
 		gp1 = runtime_sched.runqhead;
 		runtime_sched.runqhead = (G*)gp1->schedlink;
-		runqput(p, gp1);
+		gp1->schedlink = 0;
+		runqput(p, gp1, false);
 	}
 	return gp;
 }
@@ -3276,13 +4426,28 @@ pidleget(void)
 	return p;
 }
 
-// Try to put g on local runnable queue.
-// If it's full, put onto global queue.
+// runqput tries to put g on the local runnable queue.
+// If next is false, runqput adds g to the tail of the runnable queue.
+// If next is true, runqput puts g in the p->runnext slot.
+// If the run queue is full, runqput puts g on the global queue.
 // Executed only by the owner P.
 static void
-runqput(P *p, G *gp)
+runqput(P *p, G *gp, bool next)
 {
 	uint32 h, t;
+	uintptr oldnext;
+
+	setrunsince(gp);
+	if(next) {
+	retryNext:
+		oldnext = p->runnext;
+		if(!runtime_casp(&p->runnext, oldnext, (uintptr)gp))
+			goto retryNext;
+		if(oldnext == 0)
+			return;
+		// Kick the old runnext out to the regular run queue.
+		gp = (G*)oldnext;
+	}
 
 retry:
 	h = runtime_atomicload(&p->runqhead);  // load-acquire, synchronize with consumers
@@ -3316,6 +4481,8 @@ runqputslow(P *p, G *gp, uint32 h, uint32 t)
 	if(!runtime_cas(&p->runqhead, h, h+n))  // cas-release, commits consume
 		return false;
 	batch[n] = gp;
+	for(i=0; i<=n; i++)
+		checkunlinked(batch[i], nil);
 	// Link the goroutines.
 	for(i=0; i<n; i++)
 		batch[i]->schedlink = (uintptr)batch[i+1];
@@ -3326,13 +4493,47 @@ runqputslow(P *p, G *gp, uint32 h, uint32 t)
 	return true;
 }
 
+// Reports whether the time slice on p has run long enough that
+// retake would ask the running G to yield.
+static bool
+sliceexpired(P *p)
+{
+	Pdesc *pd;
+
+	pd = &pdesc[p->id];
+	return pd->schedtick == p->schedtick && pd->schedwhen + 10*1000*1000 <= runtime_nanotime();
+}
+
 // Get g from local runnable queue.
+// If inheritTime is true, gp should inherit the remaining time in the
+// current time slice. Otherwise, it should start a new time slice.
 // Executed only by the owner P.
 static G*
-runqget(P *p)
+runqget(P *p, bool *inheritTime)
 {
 	G *gp;
 	uint32 t, h;
+	uintptr next;
+
+	// If there's a runnext, it's the next G to run.
+	for(;;) {
+		next = p->runnext;
+		if(next == 0)
+			break;
+		if(runtime_casp(&p->runnext, next, 0)) {
+			// A G that blocks at a point where retake's
+			// request to yield is never seen would otherwise
+			// hand the slice back and forth with its partner
+			// forever, so once the slice is used up runnext
+			// goes to the back of the queue.
+			if(!sliceexpired(p)) {
+				*inheritTime = true;
+				return (G*)next;
+			}
+			runqput(p, (G*)next, false);
+			break;
+		}
+	}
 
 	for(;;) {
 		h = runtime_atomicload(&p->runqhead);  // load-acquire, synchronize with other consumers
@@ -3340,8 +4541,31 @@ runqget(P *p)
 		if(t == h)
 			return nil;
 		gp = (G*)p->runq[h%nelem(p->runq)];
-		if(runtime_cas(&p->runqhead, h, h+1))  // cas-release, commits consume
+		if(runtime_cas(&p->runqhead, h, h+1)) {  // cas-release, commits consume
+			*inheritTime = false;
 			return gp;
+		}
+	}
+}
+
+// Reports whether p has no G's on its local run queue.
+// It never returns true spuriously.
+static bool
+runqempty(P *p)
+{
+	uint32 head, tail;
+	uintptr runnext;
+
+	// Defend against a race where 1) p has G1 in runnext but runqhead == runqtail,
+	// 2) runqput on p kicks G1 to the runq, 3) runqget on p empties runnext.
+	// Simply observing that runqhead == runqtail and then observing that runnext == nil
+	// does not mean the queue is empty.
+	for(;;) {
+		head = runtime_atomicload(&p->runqhead);
+		tail = runtime_atomicload(&p->runqtail);
+		runnext = runtime_atomicload(&p->runnext);
+		if(tail == runtime_atomicload(&p->runqtail))
+			return head == tail && runnext == 0;
 	}
 }
 
@@ -3349,17 +4573,34 @@ runqget(P *p)
 // batch array must be of size nelem(p->runq)/2. Returns number of grabbed goroutines.
 // Can be executed by any P.
 static uint32
-runqgrab(P *p, G **batch)
+runqgrab(P *p, G **batch, bool stealRunNextG)
 {
 	uint32 t, h, n, i;
+	uintptr next;
 
 	for(;;) {
 		h = runtime_atomicload(&p->runqhead);  // load-acquire, synchronize with other consumers
 		t = runtime_atomicload(&p->runqtail);  // load-acquire, synchronize with the producer
 		n = t-h;
 		n = n - n/2;
-		if(n == 0)
+		if(n == 0) {
+			if(stealRunNextG) {
+				// Try to steal from p->runnext.
+				next = runtime_atomicload(&p->runnext);
+				if(next != 0) {
+					// Sleep to ensure that p isn't about to run the g we are about to steal.
+					// The important use case here is when the g running on p ready()s another g and then almost
+					// immediately blocks. Instead of stealing runnext in this window, back off to give p
+					// a chance to schedule runnext and avoid thrashing gs between different Ps.
+					runtime_usleep(3);
+					if(!runtime_casp(&p->runnext, next, 0))
+						continue;
+					batch[0] = (G*)next;
+					return 1;
+				}
+			}
 			break;
+		}
 		if(n > nelem(p->runq)/2)  // read inconsistent h and t
 			continue;
 		for(i=0; i<n; i++)
@@ -3374,13 +4615,13 @@ runqgrab(P *p, G **batch)
 // and put onto local runnable queue of p.
 // Returns one of the stolen elements (or nil if failed).
 static G*
-runqsteal(P *p, P *p2)
+runqsteal(P *p, P *p2, bool stealRunNextG)
 {
 	G *gp;
 	G *batch[nelem(p->runq)/2];
 	uint32 t, h, n, i;
 
-	n = runqgrab(p2, batch);
+	n = runqgrab(p2, batch, stealRunNextG);
 	if(n == 0)
 		return nil;
 	n--;
@@ -3406,21 +4647,22 @@ runtime_testSchedLocalQueue(void)
 	P p;
 	G gs[nelem(p.runq)];
 	int32 i, j;
+	bool inheritTime;
 
 	runtime_memclr((byte*)&p, sizeof(p));
 
 	for(i = 0; i < (int32)nelem(gs); i++) {
-		if(runqget(&p) != nil)
+		if(runqget(&p, &inheritTime) != nil)
 			runtime_throw("runq is not empty initially");
 		for(j = 0; j < i; j++)
-			runqput(&p, &gs[i]);
+			runqput(&p, &gs[i], false);
 		for(j = 0; j < i; j++) {
-			if(runqget(&p) != &gs[i]) {
+			if(runqget(&p, &inheritTime) != &gs[i]) {
 				runtime_printf("bad element at iter %d/%d\n", i, j);
 				runtime_throw("bad element");
 			}
 		}
-		if(runqget(&p) != nil)
+		if(runqget(&p, &inheritTime) != nil)
 			runtime_throw("runq is not empty afterwards");
 	}
 }
@@ -3434,6 +4676,7 @@ runtime_testSchedLocalQueueSteal(void)
 	P p1, p2;
 	G gs[nelem(p1.runq)], *gp;
 	int32 i, j, s;
+	bool inheritTime;
 
 	runtime_memclr((byte*)&p1, sizeof(p1));
 	runtime_memclr((byte*)&p2, sizeof(p2));
@@ -3441,19 +4684,19 @@ runtime_testSchedLocalQueueSteal(void)
 	for(i = 0; i < (int32)nelem(gs); i++) {
 		for(j = 0; j < i; j++) {
 			gs[j].sig = 0;
-			runqput(&p1, &gs[j]);
+			runqput(&p1, &gs[j], false);
 		}
-		gp = runqsteal(&p2, &p1);
+		gp = runqsteal(&p2, &p1, true);
 		s = 0;
 		if(gp) {
 			s++;
 			gp->sig++;
 		}
-		while((gp = runqget(&p2)) != nil) {
+		while((gp = runqget(&p2, &inheritTime)) != nil) {
 			s++;
 			gp->sig++;
 		}
-		while((gp = runqget(&p1)) != nil)
+		while((gp = runqget(&p1, &inheritTime)) != nil)
 			gp->sig++;
 		for(j = 0; j < i; j++) {
 			if(gs[j].sig != 1) {
@@ -3469,6 +4712,62 @@ runtime_testSchedLocalQueueSteal(void)
 	}
 }
 
+int64 runtime_Goid(void)
+  __asm__(GOSYM_PREFIX "runtime.Goid");
+
+// Return the current goroutine's ID.  IDs come from
+// runtime_sched.goidgen, directly or through a P's goidcache, and a
+// reused G gets a fresh ID in __go_go, so they are never reused.
+int64
+runtime_Goid(void)
+{
+	return g->goid;
+}
+
+bool runtime_casgstatusgoid(int64, uint32, uint32)
+  __asm__(GOSYM_PREFIX "runtime.casgstatusgoid");
+
+// For testing: change the status of the goroutine with ID goid from
+// old to new, returning whether it was in status old.
+bool
+runtime_casgstatusgoid(int64 goid, uint32 old, uint32 new)
+{
+	uintptr i;
+	G *gp;
+	bool ok;
+
+	ok = false;
+	runtime_lock(&runtime_allglock);
+	for(i = 0; i < runtime_allglen; i++) {
+		gp = runtime_allg[i];
+		if(gp->goid == goid) {
+			ok = runtime_cas(&gp->atomicstatus, old, new);
+			break;
+		}
+	}
+	runtime_unlock(&runtime_allglock);
+	return ok;
+}
+
+// Return the largest number of dead G's cached by any P.
+static int32
+pgfreecntmax(void)
+{
+	int32 i, n, max;
+	P *p;
+
+	max = 0;
+	for(i = 0; i < runtime_gomaxprocs; i++) {
+		p = runtime_allp[i];
+		if(p == nil)
+			continue;
+		n = runtime_atomicload(&p->gfreecnt);
+		if(n > max)
+			max = n;
+	}
+	return max;
+}
+
 int32
 runtime_setmaxthreads(int32 in)
 {
@@ -3497,6 +4796,29 @@ runtime_gcwaiting(void)
 	return runtime_sched.gcwaiting;
 }
 
+// A cooperative preemption point.  If the world is being stopped,
+// yield so that it can stop.  While the M holds locks (m->locks > 0)
+// or has preemption turned off (m->preemptoff) the goroutine must not
+// be rescheduled, so the request is recorded in g->preempt and
+// honored at the first preemption point reached after that.
+void
+runtime_checkpreempt(void)
+{
+	M *mp;
+
+	if(!g->preempt && !runtime_sched.gcwaiting)
+		return;
+	mp = g->m;
+	if(mp->locks > 0 || mp->mallocing || mp->preemptoff.len > 0 ||
+	   g == mp->g0 || g->atomicstatus != _Grunning) {
+		if(runtime_sched.gcwaiting)
+			g->preempt = true;
+		return;
+	}
+	g->preempt = false;
+	runtime_gosched();
+}
+
 // os_beforeExit is called from os.Exit(0).
 //go:linkname os_beforeExit os.runtime_beforeExit
 
@@ -3533,7 +4855,7 @@ sync_runtime_canSpin(intgo i)
 		return false;
 	}
 	p = (P*)g->m->p;
-	return p != nil && p->runqhead == p->runqtail;
+	return p != nil && runqempty(p);
 }
 
 //go:linkname sync_runtime_doSpin sync.runtime_doSpin
@@ -3547,3 +4869,287 @@ sync_runtime_doSpin()
 {
 	runtime_procyield(ACTIVE_SPIN_CNT);
 }
+
+// Walk the idle P list and return its length, or -1 if
+// it holds a P that is not idle, holds a P twice, or does not agree
+// with npidle.
+static int32
+checkpidle(void)
+{
+	P *p;
+	int32 n;
+	bool seen[_MaxGomaxprocs];
+
+	runtime_memclr((byte*)seen, sizeof seen);
+	n = 0;
+	runtime_lock(&runtime_sched);
+	for(p = runtime_sched.pidle; p != nil; p = (P*)p->link) {
+		if(p->status != _Pidle || p->id < 0 || p->id >= _MaxGomaxprocs ||
+		   seen[p->id] || ++n > runtime_gomaxprocs) {
+			n = -1;
+			break;
+		}
+		seen[p->id] = true;
+	}
+	if(n >= 0 && (uint32)n != runtime_atomicload(&runtime_sched.npidle))
+		n = -1;
+	runtime_unlock(&runtime_sched);
+	return n;
+}
+
+// Walk the idle M list and return its length, or -1 if
+// it holds an M with a P, is longer than the number of M's, or does
+// not agree with nmidle.
+static int32
+checkmidle(void)
+{
+	M *mp;
+	int32 n;
+
+	n = 0;
+	runtime_lock(&runtime_sched);
+	for(mp = runtime_sched.midle; mp != nil; mp = (M*)mp->schedlink) {
+		if(mp->p != 0 || mp->spinning || ++n > runtime_sched.mcount) {
+			n = -1;
+			break;
+		}
+	}
+	if(n >= 0 && n != runtime_sched.nmidle)
+		n = -1;
+	runtime_unlock(&runtime_sched);
+	return n;
+}
+
+// Walk allm without the scheduler lock, the way
+// NumCgoCall and ThreadCreateProfile do, and return its length, or -1
+// if it is inconsistent.  M's are prepended in id order and removed
+// only when their threads exit, so the ids must count down to zero.
+static int32
+checkallm(void)
+{
+	M *mp;
+	int32 n;
+	int64 id;
+
+	n = 0;
+	id = -1;
+	for(mp = runtime_atomicloadp(&runtime_allm); mp != nil; mp = mp->alllink) {
+		if(id >= 0 && mp->id >= id)
+			return -1;
+		id = mp->id;
+		n++;
+	}
+	if(id != 0)
+		return -1;
+	return n;
+}
+
+// Count the live system goroutines by walking allg.
+static int32
+countsystemg(void)
+{
+	G *gp;
+	int32 n;
+	uintptr i;
+
+	n = 0;
+	runtime_lock(&runtime_allglock);
+	for(i = 0; i < runtime_allglen; i++) {
+		gp = runtime_allg[i];
+		if(gp->issystem && gp->atomicstatus != _Gdead)
+			n++;
+	}
+	runtime_unlock(&runtime_allglock);
+	return n;
+}
+
+int32 runtime_teststoptheworld(uint64*, int32, int64*)
+  __asm__(GOSYM_PREFIX "runtime.testStopTheWorld");
+
+// For testing: stop the world and return the number of P's found in
+// _Pgcstop once runtime_stoptheworld returns, or -1 if stopwait has
+// not dropped to zero by then.  If counter is not nil, also check that
+// gcwaiting is set and store in *delta how much *counter changes in
+// the following us microseconds, before starting the world again.
+int32
+runtime_teststoptheworld(uint64 *counter, int32 us, int64 *delta)
+{
+	int32 i, n;
+	uint64 before;
+
+	runtime_semacquire(&runtime_worldsema, 0);
+	g->m->gcing = 1;
+	runtime_stoptheworld();
+	n = -1;
+	if(runtime_sched.stopwait == 0) {
+		n = 0;
+		for(i = 0; i < runtime_gomaxprocs; i++)
+			if(runtime_allp[i]->status == _Pgcstop)
+				n++;
+	}
+	if(counter != nil) {
+		if(!runtime_atomicload(&runtime_sched.gcwaiting))
+			runtime_throw("testStopTheWorld: gcwaiting not set");
+		before = runtime_atomicload64(counter);
+		runtime_usleep(us);
+		*delta = runtime_atomicload64(counter) - before;
+	}
+	g->m->gcing = 0;
+	runtime_semrelease(&runtime_worldsema);
+	runtime_starttheworld();
+	return n;
+}
+
+// Values for runtime_schedtestvalue; export_test.go has the same
+// numbers.
+enum
+{
+	SchedTestGoidgen,
+	SchedTestTotaltime,
+	SchedTestNmspinning,
+	SchedTestNgsys,
+	SchedTestCountsystemg,
+	SchedTestSysmontick,
+	SchedTestSudogallocs,
+	SchedTestPgfreecntmax,
+	SchedTestCheckpidle,
+	SchedTestCheckmidle,
+	SchedTestCheckallm,
+	SchedTestSplitstack,
+};
+
+int64 runtime_schedtestvalue(int32)
+  __asm__(GOSYM_PREFIX "runtime.schedTestValue");
+
+// For testing: return the scheduler value what, one of the SchedTest
+// constants.  The list checks return the length of the list, or -1 if
+// it is inconsistent.
+int64
+runtime_schedtestvalue(int32 what)
+{
+	switch(what) {
+	case SchedTestGoidgen:
+		// The last goroutine ID handed out to a P's cache.
+		return runtime_atomicload64(&runtime_sched.goidgen);
+	case SchedTestTotaltime:
+		return runtime_schedtotaltime(runtime_nanotime());
+	case SchedTestNmspinning:
+		return runtime_atomicload(&runtime_sched.nmspinning);
+	case SchedTestNgsys:
+		return runtime_atomicload(&runtime_sched.ngsys);
+	case SchedTestCountsystemg:
+		return countsystemg();
+	case SchedTestSysmontick:
+		return runtime_atomicload(&runtime_sched.sysmontick);
+	case SchedTestSudogallocs:
+		return runtime_atomicload(&runtime_sched.nsudogalloc);
+	case SchedTestPgfreecntmax:
+		return pgfreecntmax();
+	case SchedTestCheckpidle:
+		return checkpidle();
+	case SchedTestCheckmidle:
+		return checkmidle();
+	case SchedTestCheckallm:
+		return checkallm();
+	case SchedTestSplitstack:
+		// Whether goroutines run on split stacks, which grow
+		// as needed rather than overflowing into a guard page.
+#ifdef USING_SPLIT_STACK
+		return 1;
+#else
+		return 0;
+#endif
+	}
+	runtime_throw("schedTestValue: unknown value");
+	return 0;
+}
+
+static uint32 *foreachpcounts;
+
+static void
+foreachpcount(P *p)
+{
+	runtime_xadd(&foreachpcounts[p->id], 1);
+}
+
+void runtime_testforeachp(Slice)
+  __asm__(GOSYM_PREFIX "runtime.testForEachP");
+
+// For testing: run forEachP with a function that counts in
+// counts[p->id] how many times it ran for each P.
+void
+runtime_testforeachp(Slice counts)
+{
+	if(counts.__count < runtime_gomaxprocs)
+		runtime_throw("testForEachP: counts too short");
+	foreachpcounts = (uint32*)counts.__values;
+	runtime_forEachP(foreachpcount);
+	foreachpcounts = nil;
+}
+
+static G *paramg;
+
+static bool
+parkparamcommit(G *gp, void *arg)
+{
+	USED(arg);
+	runtime_atomicstorep(&paramg, gp);
+	return true;
+}
+
+void *runtime_testparkparam(void)
+  __asm__(GOSYM_PREFIX "runtime.testParkParam");
+
+// For testing: park the calling goroutine until testReadyParam wakes
+// it, and return the param it was woken with.  Like the channel code,
+// the param is cleared once it has been read.
+void *
+runtime_testparkparam(void)
+{
+	void *param;
+
+	g->param = nil;
+	runtime_park(parkparamcommit, nil, "test param");
+	param = g->param;
+	g->param = nil;
+	return param;
+}
+
+bool runtime_testreadyparam(void*)
+  __asm__(GOSYM_PREFIX "runtime.testReadyParam");
+
+// For testing: wake the goroutine parked in testParkParam, passing it
+// param.  Returns false if no goroutine is parked there yet.
+bool
+runtime_testreadyparam(void *param)
+{
+	G *gp;
+
+	gp = runtime_atomicloadp(&paramg);
+	if(gp == nil || !runtime_casp(&paramg, gp, nil))
+		return false;
+	gp->param = param;
+	runtime_ready(gp);
+	return true;
+}
+
+void *runtime_testgparam(void)
+  __asm__(GOSYM_PREFIX "runtime.testGParam");
+
+// For testing: return the calling goroutine's param.
+void *
+runtime_testgparam(void)
+{
+	return g->param;
+}
+
+bool runtime_setsysmonpreempt(bool)
+  __asm__(GOSYM_PREFIX "runtime.setsysmonpreempt");
+
+// For testing: turn sysmon's preemption of long-running goroutines
+// on or off, returning the previous setting.
+bool
+runtime_setsysmonpreempt(bool on)
+{
+	return runtime_xchg(&sysmonpreemptoff, !on) == 0;
+}
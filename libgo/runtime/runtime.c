@@ -107,7 +107,10 @@ runtime_showframe(String s, bool current)
 void
 runtime_mpreinit(M *mp)
 {
-	int32 stacksize = 32 * 1024;	// OS X wants >=8K, Linux >=2K
+	// OS X wants >=8K, Linux >=2K.  Without split stacks the
+	// signal stack must also be large enough to print a
+	// traceback when a goroutine overflows its own stack.
+	int32 stacksize = 64 * 1024;
 
 #ifdef SIGSTKSZ
 	if(stacksize < SIGSTKSZ)
@@ -125,20 +128,42 @@ runtime_minit(void)
 {
 	M* m;
 	sigset_t sigs;
+	stack_t st;
 
-	// Initialize signal handling.
+	// Initialize signal handling.  A thread created by C code may
+	// already have a signal stack; remember it so that dropm can
+	// restore it.  Otherwise the C thread would be left using
+	// gsignalstack, which belongs to the M and will be used by
+	// whichever thread next picks up the M.
 	m = runtime_m();
+	if(sigaltstack(nil, &st) < 0)
+		*(int *)0xf1 = 0xf1;
+	m->newSigstack = (st.ss_flags & SS_DISABLE) != 0;
+	m->replacedSigstack = !m->newSigstack;
+	if(m->replacedSigstack) {
+		m->oldsigstack = st.ss_sp;
+		m->oldsigstacksize = st.ss_size;
+	}
 	runtime_signalstack(m->gsignalstack, m->gsignalstacksize);
 	if (sigemptyset(&sigs) != 0)
 		runtime_throw("sigemptyset");
 	pthread_sigmask(SIG_SETMASK, &sigs, nil);
+	*(sigset_t*)&m->sigmask = sigs;
 }
 
 // Called from dropm to undo the effect of an minit.
 void
 runtime_unminit(void)
 {
-	runtime_signalstack(nil, 0);
+	M *m;
+
+	m = runtime_m();
+	if(!m->replacedSigstack) {
+		runtime_signalstack(nil, 0);
+		return;
+	}
+	m->replacedSigstack = false;
+	runtime_signalstack(m->oldsigstack, m->oldsigstacksize);
 }
 
 
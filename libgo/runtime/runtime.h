@@ -75,6 +75,7 @@ typedef	struct	ParFor		ParFor;
 typedef	struct	ParForThread	ParForThread;
 typedef	struct	cgoMal		CgoMal;
 typedef	struct	PollDesc	PollDesc;
+typedef	struct	sudog		SudoG;
 
 typedef	struct	__go_open_array		Slice;
 typedef struct	__go_interface		Iface;
@@ -273,6 +274,8 @@ void	runtime_hashinit(void);
 
 void	runtime_traceback(void);
 void	runtime_tracebackothers(G*);
+bool	runtime_tracebackg(G*, Traceback*);
+void	runtime_printcgotraceback(void*, uintptr);
 enum
 {
 	// The maximum number of frames we print for a traceback
@@ -283,6 +286,7 @@ enum
  * external data
  */
 extern	uintptr runtime_zerobase;
+extern	Lock	runtime_allglock;
 extern	G**	runtime_allg;
 extern	uintptr runtime_allglen;
 extern	G*	runtime_lastg;
@@ -348,6 +352,7 @@ void	runtime_ready(G*);
 String	runtime_getenv(const char*);
 int32	runtime_atoi(const byte*, intgo);
 void*	runtime_mstart(void*);
+void	runtime_bgready(void);
 G*	runtime_malg(int32, byte**, uintptr*);
 void	runtime_mpreinit(M*);
 void	runtime_minit(void);
@@ -364,6 +369,8 @@ void	runtime_mprofinit(void);
 #define runtime_getcallersp(p) __builtin_frame_address(1)
 int32	runtime_mcount(void);
 int32	runtime_gcount(void);
+void	runtime_setsystemg(G*);
+bool	runtime_issystemg(G*);
 void	runtime_mcall(void(*)(G*));
 uint32	runtime_fastrand1(void) __asm__ (GOSYM_PREFIX "runtime.fastrand1");
 int32	runtime_timediv(int64, int32, int32*)
@@ -407,9 +414,15 @@ void	runtime_entersyscallblock(int32)
 void	runtime_exitsyscall(int32)
   __asm__ (GOSYM_PREFIX "runtime.exitsyscall");
 G*	__go_go(void (*pfn)(void*), void*);
+G*	runtime_gonolimit(void (*pfn)(void*), void*);
 void	siginit(void);
 bool	__go_sigsend(int32 sig);
 int32	runtime_callers(int32, Location*, int32, bool keep_callers);
+int32	runtime_callersfp(int32, Location*, int32);
+int32	runtime_callerspcs(int32, uintptr*, int32);
+int32	runtime_callerspcsfrom(const char*, uintptr*, int32);
+intgo	runtime_funcframecount(uintptr)
+  __asm__ (GOSYM_PREFIX "runtime.funcframecount");
 int64	runtime_nanotime(void)	// monotonic time
   __asm__(GOSYM_PREFIX "runtime.nanotime");
 int64	runtime_unixnanotime(void); // real time, can skip
@@ -428,6 +441,8 @@ int64	runtime_tickspersecond(void)
      __asm__ (GOSYM_PREFIX "runtime.tickspersecond");
 void	runtime_blockevent(int64, int32);
 extern int64 runtime_blockprofilerate;
+void	runtime_mutexevent(int64, int32);
+extern uint64 runtime_mutexprofilerate;
 void	runtime_addtimer(Timer*);
 bool	runtime_deltimer(Timer*);
 G*	runtime_netpoll(bool);
@@ -452,8 +467,31 @@ void	runtime_iterate_finq(void (*callback)(FuncVal*, void*, const FuncType*, con
 
 void	runtime_stoptheworld(void);
 void	runtime_starttheworld(void);
+void	runtime_forEachP(void (*)(P*));
+SudoG*	runtime_acquireSudog(void);
+void	runtime_releaseSudog(SudoG*);
+void	runtime_clearsudogcache(void);
+void	runtime_gfreerelease(void);
 extern uint32 runtime_worldsema;
 
+/*
+ * execution tracer (trace.c).  the event hooks must only be called
+ * when runtime_traceenabled is set.
+ */
+extern	bool	runtime_traceenabled;
+void	runtime_tracegocreate(G*);
+void	runtime_tracegostart(G*);
+void	runtime_tracegoend(void);
+void	runtime_tracegosched(void);
+byte	runtime_traceblockev(const char*);
+void	runtime_tracegopark(byte);
+void	runtime_tracegounpark(G*);
+void	runtime_tracegosyscall(void);
+void	runtime_tracegosysblock(P*);
+void	runtime_tracegosysexit(G*, int64);
+void	runtime_traceprocfree(P*);
+void	runtime_traceflushps(void);
+
 /*
  * mutual exclusion locks.  in the uncontended case,
  * as fast as spin locks (just a few user-level instructions),
@@ -486,6 +524,11 @@ void	runtime_unlock(Lock*)
  *
  * notesleep/notetsleep are generally called on g0,
  * notetsleepg is similar to notetsleep but is called on user g.
+ *
+ * notetsleepgresult is like notetsleepg but returns why it woke up.
+ * notecancel wakes it up early; it may be called any number of
+ * times and may race with notewakeup, but the same rule about
+ * noteclear applies as when notetsleep wakes up early.
  */
 void	runtime_noteclear(Note*)
   __asm__ (GOSYM_PREFIX "runtime.noteclear");
@@ -497,6 +540,10 @@ bool	runtime_notetsleep(Note*, int64)  // false - timeout
   __asm__ (GOSYM_PREFIX "runtime.notetsleep");
 bool	runtime_notetsleepg(Note*, int64)  // false - timeout
   __asm__ (GOSYM_PREFIX "runtime.notetsleepg");
+int32	runtime_notetsleepgresult(Note*, int64)  // 0 - timeout, 1 - wakeup, 2 - canceled
+  __asm__ (GOSYM_PREFIX "runtime.notetsleepgresult");
+bool	runtime_notecancel(Note*)
+  __asm__ (GOSYM_PREFIX "runtime.notecancel");
 
 /*
  * Lock-free stack.
@@ -546,6 +593,8 @@ void __wrap_rtems_task_variable_add(void **);
 #define runtime_printuint	__go_print_uint64
 #define runtime_printslice	__go_print_slice
 #define runtime_printcomplex	__go_print_complex
+#define runtime_printlock	__go_print_lock
+#define runtime_printunlock	__go_print_unlock
 
 /*
  * runtime go-called
@@ -557,6 +606,8 @@ void	runtime_printint(int64);
 void	runtime_printiface(Iface);
 void	runtime_printeface(Eface);
 void	runtime_printstring(String);
+void	runtime_printlock(void);
+void	runtime_printunlock(void);
 void	runtime_printpc(void*);
 void	runtime_printpointer(void*);
 void	runtime_printuint(uint64);
@@ -573,15 +624,27 @@ void reflect_call(const struct __go_func_type *, FuncVal *, _Bool, _Bool,
  */
 void	runtime_printany(Eface)
      __asm__ (GOSYM_PREFIX "runtime.Printany");
+void	runtime_preprintpanics(Panic*)
+     __asm__ (GOSYM_PREFIX "runtime.Preprintpanics");
 void	runtime_newTypeAssertionError(const String*, const String*, const String*, const String*, Eface*)
      __asm__ (GOSYM_PREFIX "runtime.NewTypeAssertionError");
 void	runtime_newErrorCString(const char*, Eface*)
      __asm__ (GOSYM_PREFIX "runtime.NewErrorCString");
+void	runtime_panicmemAddr(uintptr)
+     __asm__ (GOSYM_PREFIX "runtime.panicmemAddr")
+     __attribute__ ((noreturn));
 
 /*
  * wrapped for go users
  */
-void	runtime_semacquire(uint32 volatile *, bool);
+enum
+{
+	// Flags for runtime_semacquire: record the wait in the block
+	// profile, and report it in the mutex profile when released.
+	SemaBlockProfile = 1<<0,
+	SemaMutexProfile = 1<<1,
+};
+void	runtime_semacquire(uint32 volatile *, int32);
 void	runtime_semrelease(uint32 volatile *);
 int32	runtime_gomaxprocsfunc(int32 n);
 void	runtime_procyield(uint32)
@@ -636,9 +699,13 @@ int32 getproccount(void);
 #define PREFETCH(p) __builtin_prefetch(p)
 
 bool	runtime_gcwaiting(void);
+void	runtime_checkpreempt(void)
+  __asm__ (GOSYM_PREFIX "runtime.checkpreempt");
 void	runtime_badsignal(int);
+const char*	runtime_signame(int32);
 Defer*	runtime_newdefer(void);
 void	runtime_freedefer(Defer*);
+void	runtime_cleardeferpool(void);
 
 struct time_now_ret
 {
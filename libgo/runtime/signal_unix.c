@@ -120,6 +120,40 @@ runtime_sigignore(uint32 sig)
 	}
 }
 
+// Block sig on the current thread for a critical section.  The
+// goroutine is locked to its thread until the matching
+// runtime_sigunblock.  The mask in effect before the call is stored
+// in *old and m->sigmask is updated to the new Go signal mask, so
+// calls nest as long as each runtime_sigunblock is passed the mask
+// saved by the matching runtime_sigblock.
+void runtime_sigblock(uint32, sigset_t*)
+  __asm__ (GOSYM_PREFIX "runtime.sigblock");
+
+void
+runtime_sigblock(uint32 sig, sigset_t *old)
+{
+	sigset_t *mask;
+
+	runtime_lockOSThread();
+	mask = (sigset_t*)&runtime_m()->sigmask;
+	*old = *mask;
+	sigaddset(mask, sig);
+	pthread_sigmask(SIG_SETMASK, mask, nil);
+}
+
+// Undo a runtime_sigblock, restoring the mask it saved in *old.
+// Signals that became pending while blocked are delivered now.
+void runtime_sigunblock(sigset_t*)
+  __asm__ (GOSYM_PREFIX "runtime.sigunblock");
+
+void
+runtime_sigunblock(sigset_t *old)
+{
+	*(sigset_t*)&runtime_m()->sigmask = *old;
+	pthread_sigmask(SIG_SETMASK, old, nil);
+	runtime_unlockOSThread();
+}
+
 void
 runtime_resetcpuprofiler(int32 hz)
 {
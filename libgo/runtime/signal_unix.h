@@ -18,5 +18,6 @@ void	runtime_setsig(int32, GoSighandler*, bool);
 GoSighandler* runtime_getsig(int32);
 
 void	runtime_sighandler(int32 sig, Siginfo *info, void *context, G *gp);
-void	runtime_raise(int32);
+void	runtime_raise(int32)
+  __asm__ (GOSYM_PREFIX "runtime.raise");
 
@@ -0,0 +1,675 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Go execution tracer.
+// The tracer captures goroutine creation/blocking/unblocking and
+// syscall enter/exit/block events and writes them to a buffer in a
+// compact form.  A precise timestamp is attached to each event.
+// The format is the one read by internal/trace and cmd/trace; see
+// go/runtime/trace.go for what gccgo does not trace.
+
+#include <stdarg.h>
+
+#include "runtime.h"
+#include "arch.h"
+#include "malloc.h"
+#include "go-string.h"
+
+// Event types in the trace, args are given in square brackets.
+// Event types that gccgo never emits are omitted.
+enum
+{
+	TraceEvBatch		= 1,	// start of per-P batch of events [pid, timestamp]
+	TraceEvFrequency	= 2,	// contains tracer timer frequency [frequency (ticks per second)]
+	TraceEvGoCreate		= 13,	// goroutine creation [timestamp, new goroutine id, new stack id, stack id]
+	TraceEvGoStart		= 14,	// goroutine starts running [timestamp, goroutine id, seq]
+	TraceEvGoEnd		= 15,	// goroutine ends [timestamp]
+	TraceEvGoStop		= 16,	// goroutine stops (like in select{}) [timestamp, stack]
+	TraceEvGoSched		= 17,	// goroutine calls Gosched [timestamp, stack]
+	TraceEvGoSleep		= 19,	// goroutine calls Sleep [timestamp, stack]
+	TraceEvGoBlock		= 20,	// goroutine blocks [timestamp, stack]
+	TraceEvGoUnblock	= 21,	// goroutine is unblocked [timestamp, goroutine id, seq, stack]
+	TraceEvGoBlockSend	= 22,	// goroutine blocks on chan send [timestamp, stack]
+	TraceEvGoBlockRecv	= 23,	// goroutine blocks on chan recv [timestamp, stack]
+	TraceEvGoBlockSelect	= 24,	// goroutine blocks on select [timestamp, stack]
+	TraceEvGoBlockSync	= 25,	// goroutine blocks on Mutex/RWMutex [timestamp, stack]
+	TraceEvGoBlockNet	= 27,	// goroutine blocks on network [timestamp, stack]
+	TraceEvGoSysCall	= 28,	// syscall enter [timestamp, stack]
+	TraceEvGoSysExit	= 29,	// syscall exit [timestamp, goroutine id, seq, real timestamp]
+	TraceEvGoSysBlock	= 30,	// syscall blocks [timestamp]
+	TraceEvGoWaiting	= 31,	// denotes that goroutine is blocked when tracing starts [timestamp, goroutine id]
+	TraceEvGoInSyscall	= 32,	// denotes that goroutine is in syscall when tracing starts [timestamp, goroutine id]
+	TraceEvGoStartLocal	= 38,	// goroutine starts running on the same P as the last event [timestamp, goroutine id]
+	TraceEvGoUnblockLocal	= 39,	// goroutine is unblocked on the same P as the last event [timestamp, goroutine id, stack]
+	TraceEvGoSysExitLocal	= 40,	// syscall exit on the same P as the last event [timestamp, goroutine id, real timestamp]
+};
+
+enum
+{
+	// Timestamps in trace are cputicks/TraceTickDiv.
+	// This makes absolute values of timestamp diffs smaller,
+	// and so they are encoded in less number of bytes.
+	// 64 on x86 is somewhat arbitrary (one tick is ~20ns on a 3GHz machine).
+#if defined(__i386__) || defined(__x86_64__)
+	TraceTickDiv = 64,
+#else
+	TraceTickDiv = 16,
+#endif
+	// Shift of the number of arguments in the first event byte.
+	TraceArgCountShift = 6,
+	// Maximum number of bytes to encode uint64 in base-128.
+	TraceBytesPerNumber = 10,
+	// Maximum size of an event: type byte, length byte, timestamp,
+	// up to three arguments and a stack id.
+	TraceMaxEventSize = 2 + 5*TraceBytesPerNumber,
+	// The pid written in batches of events emitted without a P.
+	TraceGlobProc = -1,
+};
+
+typedef struct TraceBuf TraceBuf;
+
+// TraceBuf is per-P tracing buffer.
+struct TraceBuf
+{
+	TraceBuf	*link;		// in trace.emptybuf/fullhead
+	uintptr		pos;		// next write offset in arr
+	uint64		lastticks;	// when we wrote the last event
+	byte		arr[(64<<10) - 2*sizeof(uintptr) - sizeof(uint64)];
+};
+
+// trace is global tracing context.
+static struct
+{
+	Lock		lock;		// protects the fields below and the buffer queues
+	bool		shutdown;	// set when we are waiting for trace reader to finish after setting runtime_traceenabled=false
+	bool		headerwritten;	// whether ReadTrace has emitted trace header
+	bool		footerwritten;	// whether ReadTrace has emitted trace footer
+	G		*reader;	// goroutine waiting in ReadTrace, or nil
+	Note		readnote;	// wakes up reader
+	uint32		shutdownsema;	// used to wait for ReadTrace completion
+	int64		ticksstart;	// cputicks when tracing was started
+	int64		ticksend;	// cputicks when tracing was stopped
+	int64		timestart;	// nanotime when tracing was started
+	int64		timeend;	// nanotime when tracing was stopped
+	TraceBuf	*emptybuf;	// stack of empty buffers
+	TraceBuf	*fullhead;	// queue of full buffers
+	TraceBuf	*fulltail;
+	TraceBuf	*reading;	// buffer currently handed off to user
+	TraceBuf	*buf;		// global trace buffer, used when running without a p
+	byte		header[16];
+	byte		footer[1+TraceBytesPerNumber];
+} trace;
+
+// runtime_traceenabled is set when the tracer is recording events.
+bool runtime_traceenabled;
+
+static void
+tracebyte(TraceBuf *buf, byte v)
+{
+	buf->arr[buf->pos++] = v;
+}
+
+// tracevarint appends v to buf in little-endian-base-128 encoding.
+static void
+tracevarint(TraceBuf *buf, uint64 v)
+{
+	for(; v >= 0x80; v >>= 7)
+		tracebyte(buf, 0x80 | (byte)v);
+	tracebyte(buf, (byte)v);
+}
+
+// tracefullqueue queues buf into the queue of full buffers and wakes
+// the reader if it is waiting for one.
+// trace.lock must be held.
+static void
+tracefullqueue(TraceBuf *buf)
+{
+	buf->link = nil;
+	if(trace.fullhead == nil)
+		trace.fullhead = buf;
+	else
+		trace.fulltail->link = buf;
+	trace.fulltail = buf;
+	if(trace.reader != nil) {
+		trace.reader = nil;
+		runtime_notewakeup(&trace.readnote);
+	}
+}
+
+// tracefulldequeue dequeues from the queue of full buffers.
+// trace.lock must be held.
+static TraceBuf*
+tracefulldequeue(void)
+{
+	TraceBuf *buf;
+
+	buf = trace.fullhead;
+	if(buf == nil)
+		return nil;
+	trace.fullhead = buf->link;
+	if(trace.fullhead == nil)
+		trace.fulltail = nil;
+	buf->link = nil;
+	return buf;
+}
+
+// traceflush puts buf onto the queue of full buffers and returns an
+// empty buffer that starts a new batch of events for P pid.
+// trace.lock must be held.
+static TraceBuf*
+traceflush(TraceBuf *buf, int32 pid)
+{
+	uint64 ticks;
+
+	if(buf != nil)
+		tracefullqueue(buf);
+	if(trace.emptybuf != nil) {
+		buf = trace.emptybuf;
+		trace.emptybuf = buf->link;
+	} else {
+		buf = runtime_SysAlloc(sizeof(TraceBuf), &mstats.other_sys);
+		if(buf == nil)
+			runtime_throw("trace: out of memory");
+	}
+	buf->link = nil;
+	buf->pos = 0;
+
+	// initialize the buffer for a new batch
+	ticks = (uint64)runtime_cputicks() / TraceTickDiv;
+	buf->lastticks = ticks;
+	tracebyte(buf, TraceEvBatch | 1<<TraceArgCountShift);
+	tracevarint(buf, (uint64)(int64)pid);
+	tracevarint(buf, ticks);
+	return buf;
+}
+
+// traceevent writes a single event with nargs uint64 arguments to the
+// trace buffer of p, or to the global buffer if p is nil.  If stack
+// is true the event also carries a stack id; gccgo does not record
+// stacks, so it is always 0.
+static void
+traceevent(P *p, byte ev, bool stack, int32 nargs, ...)
+{
+	M *m;
+	TraceBuf *buf, **bufp;
+	uint64 ticks, tickdiff;
+	uintptr startpos, evsize;
+	byte narg, *lenp;
+	int32 i, pid;
+	va_list ap;
+
+	m = runtime_m();
+	// Disable preemption so that the P cannot change under us.
+	m->locks++;
+	if(p == nil) {
+		runtime_lock(&trace.lock);
+		bufp = &trace.buf;
+		pid = TraceGlobProc;
+	} else {
+		bufp = (TraceBuf**)&p->tracebuf;
+		pid = p->id;
+	}
+	// Double-check now that we may hold the lock: StopTrace may
+	// have collected the buffers while we were getting here.
+	if(!runtime_traceenabled && !m->startingtrace) {
+		if(p == nil)
+			runtime_unlock(&trace.lock);
+		m->locks--;
+		return;
+	}
+	buf = *bufp;
+	if(buf == nil || sizeof buf->arr - buf->pos < TraceMaxEventSize) {
+		if(p != nil)
+			runtime_lock(&trace.lock);
+		buf = traceflush(buf, pid);
+		if(p != nil)
+			runtime_unlock(&trace.lock);
+		*bufp = buf;
+	}
+
+	ticks = (uint64)runtime_cputicks() / TraceTickDiv;
+	tickdiff = ticks - buf->lastticks;
+	buf->lastticks = ticks;
+	narg = (byte)nargs;
+	if(stack)
+		narg++;
+	// We have only 2 bits for number of arguments.
+	// If number is >= 3, then the event type is followed by event length in bytes.
+	if(narg > 3)
+		narg = 3;
+	startpos = buf->pos;
+	tracebyte(buf, ev | narg<<TraceArgCountShift);
+	lenp = nil;
+	if(narg == 3) {
+		// Reserve the byte for length assuming that length < 128.
+		tracevarint(buf, 0);
+		lenp = &buf->arr[buf->pos-1];
+	}
+	tracevarint(buf, tickdiff);
+	va_start(ap, nargs);
+	for(i = 0; i < nargs; i++)
+		tracevarint(buf, va_arg(ap, uint64));
+	va_end(ap);
+	if(stack)
+		tracevarint(buf, 0);
+	evsize = buf->pos - startpos;
+	if(evsize > TraceMaxEventSize)
+		runtime_throw("invalid length of trace event");
+	if(lenp != nil) {
+		// Fill in actual length.
+		*lenp = (byte)(evsize - 2);
+	}
+
+	if(p == nil)
+		runtime_unlock(&trace.lock);
+	m->locks--;
+}
+
+// curp returns the P of the current M, or nil.
+static P*
+curp(void)
+{
+	return (P*)runtime_m()->p;
+}
+
+// Each goroutine's traceseq numbers the events that the parser must
+// order across P's: GoWaiting, GoInSyscall, and every start, unblock
+// and syscall exit.  GoCreate resets it to 0, and each of these
+// events takes the next number, whether or not the event records it,
+// so the parser can tell which event of the goroutine comes next.
+
+void
+runtime_tracegocreate(G *newg)
+{
+	newg->traceseq = 0;
+	newg->tracelastp = runtime_m()->p;
+	traceevent(curp(), TraceEvGoCreate, true, 2, (uint64)newg->goid, (uint64)0);
+}
+
+// tracenextseq advances gp's sequence number for a start, unblock or
+// syscall exit event emitted on the current P, and reports whether the
+// Local variant of the event may be used.  That is so when the
+// goroutine's previous event was emitted on the same P: the Local
+// events carry no sequence number, since their position in the P's
+// batch already orders them after that event.
+static bool
+tracenextseq(G *gp)
+{
+	uintptr p;
+
+	p = runtime_m()->p;
+	gp->traceseq++;
+	if(gp->tracelastp == p)
+		return true;
+	gp->tracelastp = p;
+	return false;
+}
+
+void
+runtime_tracegostart(G *gp)
+{
+	if(tracenextseq(gp))
+		traceevent(curp(), TraceEvGoStartLocal, false, 1, (uint64)gp->goid);
+	else
+		traceevent(curp(), TraceEvGoStart, false, 2, (uint64)gp->goid, gp->traceseq);
+}
+
+void
+runtime_tracegoend(void)
+{
+	traceevent(curp(), TraceEvGoEnd, false, 0);
+}
+
+void
+runtime_tracegosched(void)
+{
+	traceevent(curp(), TraceEvGoSched, true, 0);
+}
+
+// runtime_traceblockev returns the event that reports a goroutine
+// parking with the given wait reason.
+byte
+runtime_traceblockev(const char *reason)
+{
+	if(runtime_strcmp(reason, "chan send") == 0)
+		return TraceEvGoBlockSend;
+	if(runtime_strcmp(reason, "chan receive") == 0)
+		return TraceEvGoBlockRecv;
+	if(runtime_strcmp(reason, "select") == 0)
+		return TraceEvGoBlockSelect;
+	if(runtime_strcmp(reason, "semacquire") == 0)
+		return TraceEvGoBlockSync;
+	if(runtime_strcmp(reason, "IO wait") == 0)
+		return TraceEvGoBlockNet;
+	if(runtime_strcmp(reason, "sleep") == 0)
+		return TraceEvGoSleep;
+	if(runtime_strcmp(reason, "select (no cases)") == 0 ||
+	   runtime_strcmp(reason, "chan send (nil chan)") == 0 ||
+	   runtime_strcmp(reason, "chan receive (nil chan)") == 0)
+		return TraceEvGoStop;
+	return TraceEvGoBlock;
+}
+
+// runtime_tracegopark records that the current goroutine parks.  ev
+// is the event computed by runtime_traceblockev, or 0 if it is not
+// known.
+void
+runtime_tracegopark(byte ev)
+{
+	if(ev == 0)
+		ev = TraceEvGoBlock;
+	traceevent(curp(), ev, true, 0);
+}
+
+void
+runtime_tracegounpark(G *gp)
+{
+	if(tracenextseq(gp))
+		traceevent(curp(), TraceEvGoUnblockLocal, true, 1, (uint64)gp->goid);
+	else
+		traceevent(curp(), TraceEvGoUnblock, true, 2, (uint64)gp->goid, gp->traceseq);
+}
+
+void
+runtime_tracegosyscall(void)
+{
+	traceevent(curp(), TraceEvGoSysCall, true, 0);
+}
+
+// runtime_tracegosysblock records that the goroutine in a system call
+// on p has lost p.  Sysmon and stoptheworld declare system calls
+// running on remote P's as blocked, so this writes to the buffer of
+// p rather than that of the current M.
+void
+runtime_tracegosysblock(P *p)
+{
+	traceevent(p, TraceEvGoSysBlock, false, 0);
+}
+
+// runtime_tracegosysexit records that gp has returned from a blocked
+// system call.  ts is the cputicks when the call returned, or 0 if
+// the event is emitted right away.
+void
+runtime_tracegosysexit(G *gp, int64 ts)
+{
+	if(ts != 0 && ts < trace.ticksstart) {
+		// There is a race between the code that initializes
+		// sysexitticks (in exitsyscall, which runs without a P,
+		// and therefore is not stopped with the rest of the world)
+		// and the code that initializes a new trace.  The recorded
+		// sysexitticks must therefore be treated as "best effort".
+		ts = 0;
+	}
+	if(tracenextseq(gp))
+		traceevent(curp(), TraceEvGoSysExitLocal, false, 2, (uint64)gp->goid, (uint64)ts/TraceTickDiv);
+	else
+		traceevent(curp(), TraceEvGoSysExit, false, 3, (uint64)gp->goid, gp->traceseq, (uint64)ts/TraceTickDiv);
+}
+
+// runtime_traceprocfree frees trace buffer associated with p.
+void
+runtime_traceprocfree(P *p)
+{
+	TraceBuf *buf;
+
+	buf = (TraceBuf*)p->tracebuf;
+	p->tracebuf = 0;
+	if(buf == nil)
+		return;
+	runtime_lock(&trace.lock);
+	tracefullqueue(buf);
+	runtime_unlock(&trace.lock);
+}
+
+// runtime_traceflushps queues the trace buffer of every P for the
+// reader.  Called with the world stopped, so that the events of P's
+// that emit few of them reach the reader without waiting for their
+// buffers to fill.
+void
+runtime_traceflushps(void)
+{
+	P *p;
+	TraceBuf *buf;
+	int32 i;
+
+	runtime_lock(&trace.lock);
+	for(i = 0; (p = runtime_allp[i]) != nil; i++) {
+		buf = (TraceBuf*)p->tracebuf;
+		if(buf != nil) {
+			tracefullqueue(buf);
+			p->tracebuf = 0;
+		}
+	}
+	runtime_unlock(&trace.lock);
+}
+
+// startTrace enables tracing for the current process and reports
+// whether it did; it fails if tracing is already enabled.  Called by
+// StartTrace in trace.go.
+bool runtime_startTrace(void)
+  __asm__(GOSYM_PREFIX "runtime.startTrace");
+
+bool
+runtime_startTrace(void)
+{
+	M *m;
+	G *gp;
+	String held;
+	uintptr i;
+	bool ok;
+
+	// Stop the world, so that we can take a consistent snapshot
+	// of all goroutines at the beginning of the trace.
+	runtime_semacquire(&runtime_worldsema, 0);
+	m = runtime_m();
+	m->gcing = 1;
+	runtime_stoptheworld();
+
+	runtime_lock(&trace.lock);
+	ok = !runtime_traceenabled && !trace.shutdown;
+	if(ok) {
+		trace.ticksstart = runtime_cputicks();
+		trace.timestart = runtime_nanotime();
+		trace.headerwritten = false;
+		trace.footerwritten = false;
+	}
+	runtime_unlock(&trace.lock);
+
+	if(ok) {
+		// Can't set runtime_traceenabled yet.  While the world is
+		// stopped, exitsyscall could already emit a GoSysExit for
+		// a goroutine before we emit GoInSyscall for it.
+		m->startingtrace = true;
+		held = runtime_gostringnocopy((const byte*)"goroutine limit");
+		for(i = 0; i < runtime_allglen; i++) {
+			gp = runtime_allg[i];
+			if(gp->atomicstatus == _Gdead)
+				continue;
+			runtime_tracegocreate(gp);
+			// Goroutines held back by the goroutine limit have
+			// never run; they are simply runnable as far as
+			// the trace is concerned.
+			if(gp->atomicstatus == _Gwaiting && !__go_strings_equal(gp->waitreason, held)) {
+				gp->traceseq++;
+				traceevent(curp(), TraceEvGoWaiting, false, 1, (uint64)gp->goid);
+			}
+			if(gp->atomicstatus == _Gsyscall) {
+				gp->traceseq++;
+				traceevent(curp(), TraceEvGoInSyscall, false, 1, (uint64)gp->goid);
+			} else
+				gp->sysblocktraced = false;
+		}
+		runtime_tracegostart(runtime_g());
+		m->startingtrace = false;
+		runtime_traceenabled = true;
+	}
+
+	m->gcing = 0;
+	runtime_semrelease(&runtime_worldsema);
+	runtime_starttheworld();
+	return ok;
+}
+
+// StopTrace stops tracing, if it was previously enabled, and waits
+// for the reader to consume the trace.  See trace.go.
+void runtime_StopTrace(void)
+  __asm__(GOSYM_PREFIX "runtime.StopTrace");
+
+void
+runtime_StopTrace(void)
+{
+	M *m;
+	TraceBuf *buf;
+	bool enabled;
+
+	// Stop the world so that we can collect the trace buffers from
+	// all p's below, and also to avoid races with traceevent.
+	runtime_semacquire(&runtime_worldsema, 0);
+	m = runtime_m();
+	m->gcing = 1;
+	runtime_stoptheworld();
+
+	enabled = runtime_traceenabled;
+	if(enabled) {
+		runtime_tracegosched();
+		runtime_traceflushps();
+
+		runtime_lock(&trace.lock);
+		if(trace.buf != nil && trace.buf->pos != 0) {
+			tracefullqueue(trace.buf);
+			trace.buf = nil;
+		}
+
+		for(;;) {
+			trace.ticksend = runtime_cputicks();
+			trace.timeend = runtime_nanotime();
+			// The clock may be coarse; wait for at least one tick
+			// so that the footer frequency is meaningful.
+			if(trace.timeend != trace.timestart)
+				break;
+			runtime_osyield();
+		}
+
+		runtime_traceenabled = false;
+		trace.shutdown = true;
+		if(trace.reader != nil) {
+			trace.reader = nil;
+			runtime_notewakeup(&trace.readnote);
+		}
+		runtime_unlock(&trace.lock);
+	}
+
+	m->gcing = 0;
+	runtime_semrelease(&runtime_worldsema);
+	runtime_starttheworld();
+
+	if(!enabled)
+		return;
+
+	// The world is started but we've set trace.shutdown, so new
+	// tracing can't start.  Wait for the trace reader to flush
+	// pending buffers and stop.
+	runtime_semacquire(&trace.shutdownsema, 0);
+
+	// The lock protects us from races with StartTrace/StopTrace
+	// because they do stop-the-world.
+	runtime_lock(&trace.lock);
+	if(trace.buf != nil) {
+		trace.buf->link = trace.emptybuf;
+		trace.emptybuf = trace.buf;
+		trace.buf = nil;
+	}
+	while((buf = trace.emptybuf) != nil) {
+		trace.emptybuf = buf->link;
+		runtime_SysFree(buf, sizeof(TraceBuf), &mstats.other_sys);
+	}
+	trace.shutdown = false;
+	runtime_unlock(&trace.lock);
+}
+
+// ReadTrace returns the next chunk of binary tracing data, blocking
+// until data is available, or nil once tracing has stopped and all of
+// its data has been returned.  See trace.go.
+Slice runtime_ReadTrace(void)
+  __asm__(GOSYM_PREFIX "runtime.ReadTrace");
+
+Slice
+runtime_ReadTrace(void)
+{
+	Slice ret;
+	TraceBuf *buf;
+	uintptr n;
+	uint64 freq;
+
+	ret.__values = nil;
+	ret.__count = 0;
+	ret.__capacity = 0;
+
+	runtime_lock(&trace.lock);
+	if(trace.reader != nil) {
+		// More than one goroutine reads trace.  This is bad.
+		// But we rather do not crash the program because of
+		// tracing, because tracing can be enabled at runtime on
+		// prod servers.
+		runtime_unlock(&trace.lock);
+		runtime_printf("runtime: ReadTrace called from multiple goroutines simultaneously\n");
+		return ret;
+	}
+	// Recycle the old buffer.
+	if((buf = trace.reading) != nil) {
+		buf->link = trace.emptybuf;
+		trace.emptybuf = buf;
+		trace.reading = nil;
+	}
+	// Write trace header.
+	if(!trace.headerwritten) {
+		trace.headerwritten = true;
+		runtime_memmove(trace.header, "go 1.7 trace\x00\x00\x00\x00", sizeof trace.header);
+		runtime_unlock(&trace.lock);
+		ret.__values = trace.header;
+		ret.__count = sizeof trace.header;
+		ret.__capacity = sizeof trace.header;
+		return ret;
+	}
+	// Wait for new data.
+	while(trace.fullhead == nil && !trace.shutdown) {
+		trace.reader = runtime_g();
+		runtime_noteclear(&trace.readnote);
+		runtime_unlock(&trace.lock);
+		runtime_notetsleepg(&trace.readnote, -1);
+		runtime_lock(&trace.lock);
+	}
+	// Write a buffer.
+	if(trace.fullhead != nil) {
+		buf = tracefulldequeue();
+		trace.reading = buf;
+		runtime_unlock(&trace.lock);
+		ret.__values = buf->arr;
+		ret.__count = buf->pos;
+		ret.__capacity = buf->pos;
+		return ret;
+	}
+	// Write footer with timer frequency.
+	if(!trace.footerwritten) {
+		trace.footerwritten = true;
+		// Use float64 because (trace.ticksend - trace.ticksstart) * 1e9 can overflow int64.
+		freq = (uint64)((float64)(trace.ticksend - trace.ticksstart) * 1e9 / (float64)(trace.timeend - trace.timestart) / TraceTickDiv);
+		n = 0;
+		trace.footer[n++] = TraceEvFrequency | 0<<TraceArgCountShift;
+		for(; freq >= 0x80; freq >>= 7)
+			trace.footer[n++] = 0x80 | (byte)freq;
+		trace.footer[n++] = (byte)freq;
+		runtime_unlock(&trace.lock);
+		ret.__values = trace.footer;
+		ret.__count = n;
+		ret.__capacity = n;
+		return ret;
+	}
+	// Done.
+	runtime_unlock(&trace.lock);
+	// runtime_traceenabled is already reset, so the semrelease is
+	// not traced.
+	runtime_semrelease(&trace.shutdownsema);
+	return ret;
+}